@@ -0,0 +1,134 @@
+package localstorage
+
+import (
+	"context"
+
+	"github.com/comfforts/errors"
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/audit"
+)
+
+type AuditConfig = audit.Config
+
+type AuditEntry = audit.Entry
+
+type AuditHead = audit.Head
+
+// WithPrincipal returns context carrying caller principal, recorded in audit log
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return audit.WithPrincipal(ctx, principal)
+}
+
+// VerifyAuditLog verifies hash chain, keyed by key, of audit log at path, returns head of verified entries
+func VerifyAuditLog(path string, key []byte) (AuditHead, error) {
+	return audit.Verify(path, key)
+}
+
+// VerifyAuditHead verifies audit log at path reaches head, kept outside the log,
+// detecting entries deleted from log's end, returns head of verified entries
+func VerifyAuditHead(path string, key []byte, head AuditHead) (AuditHead, error) {
+	return audit.VerifyHead(path, key, head)
+}
+
+// AuditHead returns sequence & hash of client's last audit entry, for keeping outside the log,
+// zero head if audit log isn't configured
+func (lc *localStorageClient) AuditHead() AuditHead {
+	if lc.audit == nil {
+		return AuditHead{}
+	}
+	return lc.audit.Head()
+}
+
+// recordOp records delete, move & purge operation, deferred with pointers to its results,
+// dry runs & purges without expired entries aren't recorded
+func (lc *localStorageClient) recordOp(ctx context.Context, op, path string, res *OpResult, err *error) {
+	if res.DryRun || (op == "PurgeTrash" && len(res.Paths) == 0 && *err == nil) {
+		return
+	}
+	lc.recordResult(ctx, AuditEntry{Op: op, Path: path, Dest: res.Target, Bytes: res.Bytes}, err)
+}
+
+// recordShards records every written shard of sharded write & its error, if any,
+// deferred with pointers to its results
+func (lc *localStorageClient) recordShards(ctx context.Context, op, path string, shards *[]Shard, err *error) {
+	opErr := *err
+	for _, s := range *shards {
+		if auditErr := lc.record(ctx, AuditEntry{Op: op, Path: s.Path, Bytes: s.Bytes}, nil); auditErr != nil && *err == nil {
+			*err = auditErr
+		}
+	}
+	if opErr != nil {
+		lc.record(ctx, AuditEntry{Op: op, Path: path}, opErr)
+	}
+}
+
+// recordResult records operation, deferred with pointer to its error,
+// audit failure returned by record fails operation, if it succeeded
+func (lc *localStorageClient) recordResult(ctx context.Context, e AuditEntry, err *error) {
+	if auditErr := lc.record(ctx, e, *err); auditErr != nil && *err == nil {
+		*err = auditErr
+	}
+}
+
+// recordStream forwards write response stream & records write, with its first error, once stream is closed,
+// audit failure returned by record is sent as stream's last response, if write succeeded
+func (lc *localStorageClient) recordStream(ctx context.Context, e AuditEntry, in <-chan WriteResponse) <-chan WriteResponse {
+	out := make(chan WriteResponse)
+	go func() {
+		defer close(out)
+		var first error
+		// writer is waited for after cancel, so its outcome is recorded
+		for r := range in {
+			if r.Error != nil && first == nil {
+				first = r.Error
+			}
+			select {
+			case <-ctx.Done():
+			case out <- r:
+			}
+		}
+		if first == nil {
+			first = ctx.Err()
+		}
+		if auditErr := lc.record(ctx, e, first); auditErr != nil && first == nil {
+			select {
+			case <-ctx.Done():
+			case out <- WriteResponse{Error: auditErr}:
+			}
+		}
+	}()
+	return out
+}
+
+// record appends mutating operation's entry to audit log, if configured,
+// checksum & size, if not set, are of destination, or path, if it's a file once done,
+// audit failures are logged & returned if audit config fails on error, operation itself stands
+func (lc *localStorageClient) record(ctx context.Context, e AuditEntry, err error) error {
+	if lc.audit == nil {
+		return nil
+	}
+	if err != nil {
+		e.Error = err.Error()
+	} else {
+		target := e.Path
+		if e.Dest != "" {
+			target = e.Dest
+		}
+		if fi, statErr := fileStats(target); statErr == nil && fi.Mode().IsRegular() {
+			if e.Bytes == 0 {
+				e.Bytes = fi.Size()
+			}
+			if sum, sumErr := audit.Checksum(target); sumErr == nil {
+				e.Checksum = sum
+			}
+		}
+	}
+	if _, err := lc.audit.Append(ctx, e); err != nil {
+		lc.logger.Error("error recording audit entry", zap.Error(err), zap.String("op", e.Op), zap.String("path", e.Path))
+		if lc.config.Audit.FailOnError {
+			return errors.WrapError(err, ERROR_RECORDING_AUDIT, e.Op, e.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/comfforts/localstorage"
+	"github.com/comfforts/localstorage/pkg/profile"
+	"github.com/comfforts/localstorage/pkg/schema"
+)
+
+func runCopy(ctx context.Context, c *cli, args []string) error {
+	fs, opts := c.flagSet("copy", "<src> <dst>")
+	buffered := fs.Bool("buffered", false, "copy through buffer")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+	client, err := c.client(opts)
+	if err != nil {
+		return err
+	}
+
+	copyFn := client.Copy
+	if *buffered {
+		copyFn = client.CopyBuf
+	}
+	n, err := copyFn(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "copied %d bytes\n", n)
+	return nil
+}
+
+func runCat(ctx context.Context, c *cli, args []string) error {
+	fs, opts := c.flagSet("cat", "<file>")
+	opts.readFlags(fs)
+	opts.outputFlags(fs)
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	return c.printRecords(ctx, opts, fs.Arg(0), -1)
+}
+
+func runHead(ctx context.Context, c *cli, args []string) error {
+	fs, opts := c.flagSet("head", "<file>")
+	n := fs.Int("n", 10, "number of records")
+	opts.readFlags(fs)
+	opts.outputFlags(fs)
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	return c.printRecords(ctx, opts, fs.Arg(0), *n)
+}
+
+func runTail(ctx context.Context, c *cli, args []string) error {
+	fs, opts := c.flagSet("tail", "<file>")
+	n := fs.Int("n", 10, "number of records")
+	opts.readFlags(fs)
+	opts.outputFlags(fs)
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rs, headers, err := c.readRecords(ctx, opts, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// ring of last n records
+	last := make([]localstorage.JSONMapper, 0, *n)
+	next := 0
+	err = each(ctx, rs, func(r localstorage.JSONMapper) (bool, error) {
+		if *n < 1 {
+			return false, nil
+		}
+		if len(last) < *n {
+			last = append(last, r)
+		} else {
+			last[next] = r
+		}
+		next = (next + 1) % *n
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(last) == *n && *n > 0 {
+		last = append(last[next:], last[:next]...)
+	}
+
+	recCh := make(chan localstorage.JSONMapper)
+	go func() {
+		defer close(recCh)
+		for _, r := range last {
+			select {
+			case <-ctx.Done():
+				return
+			case recCh <- r:
+			}
+		}
+	}()
+	return c.print(ctx, opts, headers, recCh)
+}
+
+func runCount(ctx context.Context, c *cli, args []string) error {
+	fs, opts := c.flagSet("count", "<file>")
+	opts.readFlags(fs)
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rs, _, err := c.readRecords(ctx, opts, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	count := 0
+	err = each(ctx, rs, func(r localstorage.JSONMapper) (bool, error) {
+		count++
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, count)
+	return nil
+}
+
+func runConvert(ctx context.Context, c *cli, args []string) error {
+	fs, opts := c.flagSet("convert", "<src> <dst>")
+	opts.readFlags(fs)
+	infer := fs.Bool("infer", false, "infer numbers, booleans & nulls of csv fields")
+	nest := fs.Bool("nest", false, "nest csv columns joined by separator into objects")
+	sep := fs.String("sep", ".", "nested key separator of csv columns")
+	headers := fs.String("headers", "", "comma separated csv output columns, in order")
+	if err := parse(fs, args, 2); err != nil {
+		return err
+	}
+
+	cfg, err := opts.csvConfig()
+	if err != nil {
+		return err
+	}
+	client, err := c.client(opts)
+	if err != nil {
+		return err
+	}
+
+	convertOpts := localstorage.ConvertOptions{
+		CSV:        cfg,
+		InferTypes: *infer,
+		Nest:       *nest,
+		Separator:  *sep,
+	}
+	if *headers != "" {
+		for _, h := range strings.Split(*headers, ",") {
+			convertOpts.Headers = append(convertOpts.Headers, strings.TrimSpace(h))
+		}
+	}
+	return client.Convert(ctx, fs.Arg(0), fs.Arg(1), convertOpts)
+}
+
+func runValidate(ctx context.Context, c *cli, args []string) error {
+	fs, opts := c.flagSet("validate", "<file>")
+	schemaPath := fs.String("schema", "", "json schema file, required")
+	opts.readFlags(fs)
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+	if *schemaPath == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	s, err := schema.Load(*schemaPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rs, _, err := c.readRecords(ctx, opts, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	valid := 0
+	invalid := map[int]bool{}
+	for r := range localstorage.ValidateReadStream(ctx, s, rs) {
+		if r.Error != nil {
+			var v schema.Violation
+			if !errors.As(r.Error, &v) {
+				return r.Error
+			}
+			invalid[v.Index] = true
+			fmt.Fprintln(c.stdout, v.Error())
+			continue
+		}
+		valid++
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("%d of %d records invalid", len(invalid), valid+len(invalid))
+	}
+	fmt.Fprintf(c.stdout, "%d records valid\n", valid)
+	return nil
+}
+
+// fileStats are stats printed by stats command
+type fileStats struct {
+	Name     string           `json:"name"`
+	Size     int64            `json:"size"`
+	Modified time.Time        `json:"modified"`
+	Records  int              `json:"records"`
+	Headers  []string         `json:"headers,omitempty"`
+	Profile  *profile.Profile `json:"profile,omitempty"`
+}
+
+func runStats(ctx context.Context, c *cli, args []string) error {
+	fs, opts := c.flagSet("stats", "<file>")
+	opts.readFlags(fs)
+	profiled := fs.Bool("profile", false, "profile columns, null & empty counts, distinct values, min, max, lengths & types")
+	infer := fs.Bool("infer", false, "infer types of json string values when profiling")
+	if err := parse(fs, args, 1); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	stats := fileStats{
+		Name:     info.Name(),
+		Size:     info.Size(),
+		Modified: info.ModTime().UTC(),
+	}
+
+	if *profiled {
+		cfg, err := opts.csvConfig()
+		if err != nil {
+			return err
+		}
+		client, err := c.client(opts)
+		if err != nil {
+			return err
+		}
+		prof, err := client.ProfileFile(ctx, fs.Arg(0), localstorage.ProfileOptions{
+			CSV:        cfg,
+			InferTypes: *infer,
+		})
+		if err != nil {
+			return err
+		}
+		stats.Records = prof.Records
+		stats.Profile = prof
+	} else {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		rs, headers, err := c.readRecords(ctx, opts, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		stats.Headers = headers
+		err = each(ctx, rs, func(r localstorage.JSONMapper) (bool, error) {
+			stats.Records++
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.stdout, "%s\n", data)
+	return err
+}
+
+// readRecords returns record stream of filePath, csv files read as per dialect flags
+func (c *cli) readRecords(ctx context.Context, opts *options, filePath string) (<-chan localstorage.ReadResponse, []string, error) {
+	cfg, err := opts.csvConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := c.client(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.ReadRecords(ctx, filePath, cfg)
+}
+
+// printRecords prints up to limit records of filePath, all records for negative limit
+func (c *cli) printRecords(ctx context.Context, opts *options, filePath string, limit int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rs, headers, err := c.readRecords(ctx, opts, filePath)
+	if err != nil {
+		return err
+	}
+
+	recCh := make(chan localstorage.JSONMapper)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		count := 0
+		readErr <- each(ctx, rs, func(r localstorage.JSONMapper) (bool, error) {
+			if limit >= 0 && count >= limit {
+				return false, nil
+			}
+			count++
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case recCh <- r:
+				return true, nil
+			}
+		})
+	}()
+
+	if err := c.print(ctx, opts, headers, recCh); err != nil {
+		cancel()
+		return err
+	}
+	return <-readErr
+}
+
+// print prints records of record chan in output format, at most rate per second
+func (c *cli) print(ctx context.Context, opts *options, headers []string, recCh <-chan localstorage.JSONMapper) error {
+	rw, err := newRecordWriter(c.stdout, opts.output, headers)
+	if err != nil {
+		return err
+	}
+	wait, stop := throttle(opts.rate)
+	defer stop()
+
+	for r := range recCh {
+		if !wait(ctx.Done()) {
+			return ctx.Err()
+		}
+		if err := rw.Write(r); err != nil {
+			return err
+		}
+	}
+	return rw.Close()
+}
+
+// each calls fn with each record until fn returns false or an error,
+// read errors abort reading
+func each(ctx context.Context, rs <-chan localstorage.ReadResponse, fn func(r localstorage.JSONMapper) (bool, error)) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case r, ok := <-rs:
+			if !ok {
+				return nil
+			}
+			if r.Error != nil {
+				return r.Error
+			}
+			more, err := fn(r.Result)
+			if err != nil || !more {
+				return err
+			}
+		}
+	}
+}
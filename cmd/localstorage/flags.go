@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/comfforts/localstorage"
+	"github.com/comfforts/localstorage/pkg/charset"
+	csvFiler "github.com/comfforts/localstorage/pkg/csv"
+)
+
+const (
+	OUTPUT_JSON   string = "json"
+	OUTPUT_NDJSON string = "ndjson"
+	OUTPUT_CSV    string = "csv"
+)
+
+// options are flags shared by commands
+type options struct {
+	verbose bool
+
+	// csv dialect
+	encoding   string
+	delim      string
+	lazyQuotes bool
+	trim       bool
+	snakeCase  bool
+	columns    string
+	rowPolicy  string
+
+	// output
+	output string
+	rate   float64
+}
+
+// flagSet returns named command flag set, with verbose flag & given positional args usage
+func (c *cli) flagSet(name, argsUsage string) (*flag.FlagSet, *options) {
+	opts := &options{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "usage: localstorage %s [flags] %s\n\nflags:\n", name, argsUsage)
+		fs.PrintDefaults()
+	}
+	fs.BoolVar(&opts.verbose, "v", false, "log library debug output to stderr")
+	return fs, opts
+}
+
+// readFlags registers csv dialect flags
+func (o *options) readFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.encoding, "encoding", "auto", "input encoding, auto, utf-8, utf-16le, utf-16be, latin1 or windows-1252")
+	fs.StringVar(&o.delim, "delim", string(csvFiler.DEFAULT_COMMA), "csv field delimiter")
+	fs.BoolVar(&o.lazyQuotes, "lazy-quotes", false, "allow malformed csv quotes")
+	fs.BoolVar(&o.trim, "trim", false, "trim spaces around csv headers")
+	fs.BoolVar(&o.snakeCase, "snake", false, "convert csv headers to snake case")
+	fs.StringVar(&o.columns, "columns", "", "comma separated csv columns to read, in order")
+	fs.StringVar(&o.rowPolicy, "rows", "pad", "csv short & long row policy, pad, drop, error or extras")
+}
+
+// outputFlags registers output format & rate flags
+func (o *options) outputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.output, "o", OUTPUT_JSON, "output format, json, ndjson or csv")
+	fs.Float64Var(&o.rate, "rate", 0, "max records printed per second, 0 for no limit")
+}
+
+// parse parses flags & checks positional arg count
+func parse(fs *flag.FlagSet, args []string, nArgs int) error {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() != nArgs {
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+func (o *options) csvConfig() (localstorage.CSVConfig, error) {
+	cfg := localstorage.CSVConfig{
+		LazyQuotes: o.lazyQuotes,
+		Headers: csvFiler.HeaderRules{
+			Trim:      o.trim,
+			SnakeCase: o.snakeCase,
+		},
+	}
+
+	enc, err := charset.ParseEncoding(o.encoding)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Encoding = enc
+
+	if o.delim != "" {
+		delim, size := utf8.DecodeRuneInString(o.delim)
+		if size != len(o.delim) {
+			return cfg, fmt.Errorf("delimiter %q must be a single character", o.delim)
+		}
+		cfg.Comma = delim
+	}
+
+	if o.columns != "" {
+		for _, col := range strings.Split(o.columns, ",") {
+			cfg.Columns = append(cfg.Columns, strings.TrimSpace(col))
+		}
+	}
+
+	switch policy := csvFiler.RowPolicy(o.rowPolicy); policy {
+	case "pad":
+		cfg.RowPolicy = csvFiler.ROW_PAD
+	case csvFiler.ROW_DROP, csvFiler.ROW_ERROR, csvFiler.ROW_EXTRAS:
+		cfg.RowPolicy = policy
+	default:
+		return cfg, fmt.Errorf("unknown row policy %q", o.rowPolicy)
+	}
+	return cfg, nil
+}
+
+// client returns local storage client logging to stderr,
+// library logs are limited to warnings unless verbose
+func (c *cli) client(o *options) (localstorage.LocalStorage, error) {
+	level := zapcore.WarnLevel
+	if o.verbose {
+		level = zapcore.DebugLevel
+	}
+	return localstorage.NewLocalStorageClient(newLogger(c.stderr, level))
+}
+
+func newLogger(w io.Writer, level zapcore.Level) *zap.Logger {
+	cfg := zap.NewDevelopmentEncoderConfig()
+	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	return zap.New(zapcore.NewCore(zapcore.NewConsoleEncoder(cfg), zapcore.AddSync(w), level))
+}
+
+// throttle returns a wait func limiting calls to rate per second, no limit for rate <= 0,
+// stop func releases the underlying ticker
+func throttle(rate float64) (wait func(done <-chan struct{}) bool, stop func()) {
+	if rate <= 0 {
+		return func(<-chan struct{}) bool { return true }, func() {}
+	}
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	first := true
+	return func(done <-chan struct{}) bool {
+		if first {
+			first = false
+			return true
+		}
+		select {
+		case <-done:
+			return false
+		case <-ticker.C:
+			return true
+		}
+	}, ticker.Stop
+}
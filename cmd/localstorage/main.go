@@ -0,0 +1,100 @@
+// Command localstorage wraps local storage client file operations
+// for use from the shell, e.g.
+//
+//	localstorage cat -o csv filings.json
+//	localstorage convert -infer -nest filings.csv filings.json
+//	localstorage validate -schema filing.schema.json filings.json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+)
+
+const usage = `usage: localstorage <command> [flags] <args>
+
+commands:
+%s
+run 'localstorage <command> -h' for command flags
+`
+
+type command struct {
+	summary string
+	run     func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"copy":     {"copy file, buffered or not", runCopy},
+	"cat":      {"print records of json, ndjson or csv file", runCat},
+	"convert":  {"convert records between json, ndjson & csv files", runConvert},
+	"count":    {"count records", runCount},
+	"head":     {"print first records", runHead},
+	"tail":     {"print last records", runTail},
+	"validate": {"validate records against json schema", runValidate},
+	"stats":    {"print file stats, record count & column profile", runStats},
+}
+
+// errUsage reports bad command line, after flag set printed usage
+var errUsage = errors.New("usage error")
+
+// cli holds command output streams
+type cli struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs command named by first arg & returns exit code,
+// 0 on success, 1 on command failure & 2 on usage error
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		printUsage(stderr)
+		return 2
+	}
+	name := args[0]
+	cmd, ok := commands[name]
+	if !ok {
+		if name != "-h" && name != "-help" && name != "help" {
+			fmt.Fprintf(stderr, "localstorage: unknown command %q\n", name)
+		}
+		printUsage(stderr)
+		return 2
+	}
+
+	err := cmd.run(ctx, &cli{stdout: stdout, stderr: stderr}, args[1:])
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "localstorage %s: %v\n", name, err)
+		return 1
+	}
+}
+
+func printUsage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := ""
+	for _, name := range names {
+		list += fmt.Sprintf("  %-9s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(w, usage, list)
+}
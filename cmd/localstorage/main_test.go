@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const TEST_DIR = "data"
+
+func TestCLI(t *testing.T) {
+	err := os.MkdirAll(TEST_DIR, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	csvPath := filepath.Join(TEST_DIR, "filings.psv")
+	err = os.WriteFile(csvPath, []byte("entity_num|name|employees\nC0002|Beta|3\nC0001|Alpha|12\nC0003|Gamma|\n"), os.ModePerm)
+	require.NoError(t, err)
+	jsonPath := filepath.Join(TEST_DIR, "filings.json")
+	schemaPath := filepath.Join(TEST_DIR, "filing.schema.json")
+	err = os.WriteFile(schemaPath, []byte(`{"type": "object", "properties": {"employees": {"type": "number"}}}`), os.ModePerm)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		// contains is expected part of stdout, when exact stdout isn't given
+		contains string
+	}{
+		{
+			name:   "cat prints ndjson",
+			args:   []string{"cat", "-o", "ndjson", csvPath},
+			stdout: "{\"employees\":\"3\",\"entity_num\":\"C0002\",\"name\":\"Beta\"}\n{\"employees\":\"12\",\"entity_num\":\"C0001\",\"name\":\"Alpha\"}\n{\"employees\":\"\",\"entity_num\":\"C0003\",\"name\":\"Gamma\"}\n",
+		},
+		{
+			name:   "head prints first records",
+			args:   []string{"head", "-n", "1", "-o", "csv", csvPath},
+			stdout: "entity_num|name|employees\nC0002|Beta|3\n",
+		},
+		{
+			name:   "tail prints last records",
+			args:   []string{"tail", "-n", "2", "-o", "csv", "-columns", "name", csvPath},
+			stdout: "name\nAlpha\nGamma\n",
+		},
+		{
+			name: "convert writes inferred json",
+			args: []string{"convert", "-infer", csvPath, jsonPath},
+		},
+		{
+			name:   "count counts converted records",
+			args:   []string{"count", jsonPath},
+			stdout: "3\n",
+		},
+		{
+			name:   "validate reports violations",
+			args:   []string{"validate", "-schema", schemaPath, jsonPath},
+			code:   1,
+			stdout: "element 2: /employees: expected number, got null\n",
+		},
+		{
+			name:   "copy copies file",
+			args:   []string{"copy", csvPath, filepath.Join(TEST_DIR, "copy", "filings.psv")},
+			stdout: "copied 67 bytes\n",
+		},
+		{
+			name:     "stats profiles columns",
+			args:     []string{"stats", "-profile", csvPath},
+			contains: `"distinct": 3`,
+		},
+		{
+			name: "unknown command fails",
+			args: []string{"bogus"},
+			code: 2,
+		},
+		{
+			name: "missing args fails",
+			args: []string{"cat"},
+			code: 2,
+		},
+		{
+			name: "unknown output format fails",
+			args: []string{"cat", "-o", "xml", csvPath},
+			code: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(context.Background(), tc.args, &stdout, &stderr)
+			require.Equal(t, tc.code, code, stderr.String())
+			if tc.contains != "" {
+				require.Contains(t, stdout.String(), tc.contains)
+			} else if tc.stdout != "" || tc.code == 0 {
+				require.Equal(t, tc.stdout, stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/comfforts/localstorage"
+	"github.com/comfforts/localstorage/pkg/convert"
+	csvFiler "github.com/comfforts/localstorage/pkg/csv"
+)
+
+// recordWriter prints records in output format,
+// pretty printed json array, ndjson or pipe delimited csv
+type recordWriter struct {
+	w       *bufio.Writer
+	format  string
+	headers []string
+	csv     *csv.Writer
+	count   int
+}
+
+// newRecordWriter returns record writer for output format,
+// csv columns are given headers, or sorted flattened keys of first record
+func newRecordWriter(w io.Writer, format string, headers []string) (*recordWriter, error) {
+	switch format {
+	case OUTPUT_JSON, OUTPUT_NDJSON, OUTPUT_CSV:
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	rw := &recordWriter{
+		w:       bufio.NewWriter(w),
+		format:  format,
+		headers: headers,
+	}
+	if format == OUTPUT_CSV {
+		rw.csv = csv.NewWriter(rw.w)
+		rw.csv.Comma = csvFiler.DEFAULT_COMMA
+	}
+	return rw, nil
+}
+
+func (rw *recordWriter) Write(r localstorage.JSONMapper) error {
+	defer func() {
+		rw.count++
+	}()
+
+	switch rw.format {
+	case OUTPUT_CSV:
+		r = convert.Flatten(r, "")
+		if rw.count == 0 {
+			if len(rw.headers) == 0 {
+				rw.headers = sortedKeys(r)
+			}
+			if err := rw.csv.Write(rw.headers); err != nil {
+				return err
+			}
+		}
+		row := make([]string, len(rw.headers))
+		for i, h := range rw.headers {
+			row[i] = field(r[h])
+		}
+		if err := rw.csv.Write(row); err != nil {
+			return err
+		}
+		// flushed per record, so rate limited output shows up as it's printed
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	case OUTPUT_NDJSON:
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := rw.w.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	default:
+		data, err := json.MarshalIndent(r, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		sep := ",\n  "
+		if rw.count == 0 {
+			sep = "[\n  "
+		}
+		if _, err := rw.w.WriteString(sep); err != nil {
+			return err
+		}
+		if _, err := rw.w.Write(data); err != nil {
+			return err
+		}
+	}
+	return rw.w.Flush()
+}
+
+// Close ends output, closing json array
+func (rw *recordWriter) Close() error {
+	if rw.format == OUTPUT_JSON {
+		end := "\n]\n"
+		if rw.count == 0 {
+			end = "[]\n"
+		}
+		if _, err := rw.w.WriteString(end); err != nil {
+			return err
+		}
+	}
+	return rw.w.Flush()
+}
+
+// field formats record value as csv field
+func field(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func sortedKeys(r localstorage.JSONMapper) []string {
+	keys := make([]string, 0, len(r))
+	for k := range r {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -3,17 +3,32 @@ package localstorage
 import "github.com/comfforts/errors"
 
 const (
-	ERROR_NO_FILE           string = "%s doesn't exist"
-	ERROR_FILE_INACCESSIBLE string = "%s inaccessible"
-	ERROR_NOT_A_FILE        string = "%s not a file"
-	ERROR_OPENING_FILE      string = "opening file %s"
-	ERROR_READING_FILE      string = "reading file %s"
-	ERROR_DECODING_RESULT   string = "error decoding result json"
-	ERROR_START_TOKEN       string = "error reading start token"
-	ERROR_END_TOKEN         string = "error reading end token"
-	ERROR_CLOSING_FILE      string = "closing file %s"
-	ERROR_CREATING_FILE     string = "creating file %s"
-	ERROR_WRITING_FILE      string = "writing file %s"
+	ERROR_NO_FILE            string = "%s doesn't exist"
+	ERROR_FILE_INACCESSIBLE  string = "%s inaccessible"
+	ERROR_NOT_A_FILE         string = "%s not a file"
+	ERROR_OPENING_FILE       string = "opening file %s"
+	ERROR_READING_FILE       string = "reading file %s"
+	ERROR_DECODING_RESULT    string = "error decoding result json"
+	ERROR_START_TOKEN        string = "error reading start token"
+	ERROR_END_TOKEN          string = "error reading end token"
+	ERROR_CLOSING_FILE       string = "closing file %s"
+	ERROR_CREATING_FILE      string = "creating file %s"
+	ERROR_WRITING_FILE       string = "writing file %s"
+	ERROR_UNSUPPORTED_FORMAT string = "unsupported file format %s"
+	ERROR_NOT_A_DIR          string = "%s not a directory"
+	ERROR_LISTING_DIR        string = "listing directory %s"
+	ERROR_BAD_PATTERN        string = "bad pattern %s"
+	ERROR_OUTSIDE_ROOT       string = "%s outside root %s"
+	ERROR_ROOT_PATH          string = "%s is root directory"
+	ERROR_CONTAINS_TRASH     string = "%s contains trash directory"
+	ERROR_FILE_EXISTS        string = "%s already exists"
+	ERROR_BAD_NAME           string = "bad file name %s"
+	ERROR_DELETING_FILE      string = "deleting %s"
+	ERROR_MOVING_FILE        string = "moving %s to %s"
+	ERROR_ENCRYPTED_FILE     string = "%s is encrypted, no decryption keys configured"
+	ERROR_ENCRYPTED_INDEX    string = "%s is encrypted, encrypted files can't be indexed"
+	ERROR_UNKNOWN_COLUMN     string = "record key %s not in csv columns of %s"
+	ERROR_RECORDING_AUDIT    string = "recording audit entry of %s %s"
 )
 
 var (
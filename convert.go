@@ -0,0 +1,127 @@
+package localstorage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/comfforts/errors"
+
+	"github.com/comfforts/localstorage/pkg/convert"
+)
+
+type ConvertOptions struct {
+	// CSV configures reading csv source files
+	CSV CSVConfig
+	// InferTypes converts csv fields to numbers, booleans & nulls when writing json
+	InferTypes bool
+	// Nest expands csv column names joined by separator into nested objects when writing json
+	Nest bool
+	// Separator joins nested object keys into csv column names, defaults to "."
+	Separator string
+	// Headers are csv columns written, in order,
+	// defaults to csv source headers, or sorted column names of all records
+	Headers []string
+}
+
+// Convert converts records of json array, ndjson or csv file at srcPath
+// to format of dstPath, determined by file extension,
+// nested json objects are flattened into csv columns with keys joined by separator
+func (lc *localStorageClient) Convert(ctx context.Context, srcPath, dstPath string, opts ConvertOptions) (err error) {
+	defer lc.observe("Convert", time.Now(), &err)
+	defer lc.recordResult(ctx, AuditEntry{Op: "Convert", Path: srcPath, Dest: dstPath}, &err)
+
+	srcFormat, dstFormat := fileFormat(srcPath), fileFormat(dstPath)
+	if srcFormat == FORMAT_UNKNOWN {
+		return errors.NewAppError(ERROR_UNSUPPORTED_FORMAT, srcPath)
+	}
+	if dstFormat == FORMAT_UNKNOWN {
+		return errors.NewAppError(ERROR_UNSUPPORTED_FORMAT, dstPath)
+	}
+
+	headers := opts.Headers
+	if dstFormat == FORMAT_CSV && srcFormat != FORMAT_CSV && len(headers) == 0 {
+		// json records may have different keys, columns are their union
+		if headers, err = lc.recordColumns(ctx, srcPath, opts.Separator); err != nil {
+			return err
+		}
+	}
+
+	var fn func(r JSONMapper) (JSONMapper, error)
+	switch {
+	case dstFormat == FORMAT_CSV:
+		fn = func(r JSONMapper) (JSONMapper, error) {
+			return convert.Flatten(r, opts.Separator), nil
+		}
+	case srcFormat == FORMAT_CSV:
+		fn = func(r JSONMapper) (JSONMapper, error) {
+			if opts.InferTypes {
+				r = convert.Infer(r)
+			}
+			if opts.Nest {
+				return convert.Unflatten(r, opts.Separator)
+			}
+			return r, nil
+		}
+	}
+	return lc.transform(ctx, "Convert", srcPath, dstPath, opts.CSV, headers, mapStage(fn))
+}
+
+// recordColumns reads records of json file at srcPath & returns sorted union of their flattened keys
+func (lc *localStorageClient) recordColumns(ctx context.Context, srcPath, separator string) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rs, _, err := lc.readRecords(ctx, "Convert", srcPath)
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]bool{}
+	for r := range rs {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		for k := range convert.Flatten(r.Result, separator) {
+			columns[k] = true
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	headers := make([]string, 0, len(columns))
+	for k := range columns {
+		headers = append(headers, k)
+	}
+	sort.Strings(headers)
+	return headers, nil
+}
+
+// mapStage returns a stage applying fn to each record, records pass through unchanged for nil fn,
+// fn errors end the stage
+func mapStage(fn func(r JSONMapper) (JSONMapper, error)) stage {
+	return func(ctx context.Context, inCh <-chan JSONMapper, outCh chan JSONMapper, errCh chan error) {
+		defer func() {
+			close(outCh)
+			close(errCh)
+		}()
+
+		for r := range inCh {
+			if fn != nil {
+				var err error
+				if r, err = fn(r); err != nil {
+					select {
+					case <-ctx.Done():
+					case errCh <- err:
+					}
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case outCh <- r:
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package localstorage
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/comfforts/localstorage/pkg/dedupe"
+)
+
+type DedupeOptions struct {
+	// Fields make up the dedupe key, e.g. entity_num & LastName
+	Fields []string
+	Policy dedupe.Policy
+	// MemoryBudget, if set, dedupes large inputs by spilling sorted runs to disk,
+	// output is then ordered by key fields
+	MemoryBudget int64
+	// TempDir is directory for spilled runs, defaults to os temp dir
+	TempDir string
+}
+
+// DedupeFile drops records of json array or csv file at srcPath with repeated key fields,
+// keeping first or last per policy, & writes remaining records to fileName in data directory
+func (lc *localStorageClient) DedupeFile(ctx context.Context, srcPath, fileName string, opts DedupeOptions) (err error) {
+	defer lc.observe("DedupeFile", time.Now(), &err)
+	defer lc.recordResult(ctx, AuditEntry{Op: "DedupeFile", Path: srcPath, Dest: filepath.Join("data", fileName)}, &err)
+
+	wrapRun, unwrapRun := lc.spillRuns(ctx)
+	deduper, err := dedupe.NewDeduper(dedupe.Config{
+		Fields:       opts.Fields,
+		Policy:       opts.Policy,
+		MemoryBudget: opts.MemoryBudget,
+		TempDir:      opts.TempDir,
+		WrapRun:      wrapRun,
+		UnwrapRun:    unwrapRun,
+	}, lc.logger)
+	if err != nil {
+		return err
+	}
+
+	return lc.transformFile(ctx, "DedupeFile", srcPath, fileName, deduper.Dedupe)
+}
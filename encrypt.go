@@ -0,0 +1,96 @@
+package localstorage
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/comfforts/errors"
+
+	"github.com/comfforts/localstorage/pkg/encrypt"
+)
+
+type EncryptionConfig = encrypt.Config
+
+type KeyProvider = encrypt.KeyProvider
+
+type StaticKeys = encrypt.StaticKeys
+
+func noClose() error {
+	return nil
+}
+
+// encryptWriter returns writer encrypting into w if encryption is configured, w otherwise,
+// returned close writes final encrypted chunk
+func (lc *localStorageClient) encryptWriter(ctx context.Context, w io.Writer) (io.Writer, func() error, error) {
+	cfg := lc.config.Encryption
+	if cfg.Keys == nil {
+		return w, noClose, nil
+	}
+	ew, err := encrypt.NewWriter(ctx, w, cfg.Keys, cfg.ChunkSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ew, ew.Close, nil
+}
+
+// copyWriter returns copy destination writer, encrypting unless src is already encrypted,
+// encrypted files are copied as is
+func (lc *localStorageClient) copyWriter(ctx context.Context, src *os.File, w io.Writer) (io.Writer, func() error, error) {
+	if lc.config.Encryption.Keys == nil {
+		return w, noClose, nil
+	}
+	encrypted, err := encrypt.Detect(src)
+	if err != nil {
+		return nil, nil, errors.WrapError(err, ERROR_READING_FILE, src.Name())
+	}
+	if encrypted {
+		return w, noClose, nil
+	}
+	return lc.encryptWriter(ctx, w)
+}
+
+// isEncrypted checks if file at filePath is encrypted
+func isEncrypted(filePath string) (bool, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false, errors.WrapError(err, ERROR_OPENING_FILE, filePath)
+	}
+	defer f.Close()
+	encrypted, err := encrypt.Detect(f)
+	if err != nil {
+		return false, errors.WrapError(err, ERROR_READING_FILE, filePath)
+	}
+	return encrypted, nil
+}
+
+// decryptReader returns decrypting reader of f if it's encrypted, f otherwise
+func (lc *localStorageClient) decryptReader(ctx context.Context, f *os.File) (io.Reader, error) {
+	encrypted, err := encrypt.Detect(f)
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_READING_FILE, f.Name())
+	}
+	if !encrypted {
+		return f, nil
+	}
+	if lc.config.Encryption.Keys == nil {
+		return nil, errors.NewAppError(ERROR_ENCRYPTED_FILE, f.Name())
+	}
+	return encrypt.NewReader(ctx, f, lc.config.Encryption.Keys)
+}
+
+// spillRuns returns wrappers encrypting & decrypting spilled sort runs if encryption is configured,
+// so decrypted records aren't written to temp files as plain text, nil wrappers otherwise
+func (lc *localStorageClient) spillRuns(ctx context.Context) (func(io.Writer) (io.Writer, func() error, error), func(io.Reader) (io.Reader, error)) {
+	keys := lc.config.Encryption.Keys
+	if keys == nil {
+		return nil, nil
+	}
+	wrap := func(w io.Writer) (io.Writer, func() error, error) {
+		return lc.encryptWriter(ctx, w)
+	}
+	unwrap := func(r io.Reader) (io.Reader, error) {
+		return encrypt.NewReader(ctx, r, keys)
+	}
+	return wrap, unwrap
+}
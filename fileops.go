@@ -0,0 +1,456 @@
+package localstorage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/comfforts/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	DEFAULT_TRASH_DIR       string        = ".trash"
+	DEFAULT_TRASH_RETENTION time.Duration = 7 * 24 * time.Hour
+	// TRASH_TIME_FORMAT names trash entries by deletion time, sorting in time order
+	TRASH_TIME_FORMAT string = "20060102T150405.000000000Z"
+)
+
+type DeleteOptions struct {
+	// DryRun checks & reports affected paths without deleting
+	DryRun bool
+	// Soft moves deleted paths into trash directory, purged after retention
+	Soft bool
+}
+
+type MoveOptions struct {
+	// DryRun checks & reports affected paths without moving
+	DryRun bool
+	// Overwrite replaces an existing destination file, directories are never replaced
+	Overwrite bool
+}
+
+// OpResult reports paths affected by delete, move & rename operations
+type OpResult struct {
+	// Paths are affected paths, for directory trees every file & directory, deepest first
+	Paths []string
+	// Target is destination path, or trash path of soft deleted paths
+	Target string
+	// Bytes is total size of affected files
+	Bytes  int64
+	DryRun bool
+}
+
+// Delete deletes file at path, or moves it to trash for soft delete, under exclusive lock of path
+func (lc *localStorageClient) Delete(ctx context.Context, path string, opts DeleteOptions) (res OpResult, err error) {
+	defer lc.observe("Delete", time.Now(), &err)
+	defer lc.recordOp(ctx, "Delete", path, &res, &err)
+
+	res = OpResult{DryRun: opts.DryRun}
+	p, err := lc.confine(path)
+	if err != nil {
+		return res, err
+	}
+	info, err := lstat(p)
+	if err != nil {
+		return res, err
+	}
+	if info.IsDir() {
+		return res, errors.NewAppError(ERROR_NOT_A_FILE, path)
+	}
+	res.Paths = []string{path}
+	res.Bytes = info.Size()
+
+	if !opts.DryRun {
+		lk, err := lc.locker.Lock(ctx, p)
+		if err != nil {
+			return res, err
+		}
+		defer lc.unlock(lk)
+	}
+	if opts.Soft {
+		return lc.trash(ctx, p, res)
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+	if err := os.Remove(p); err != nil {
+		return res, errors.WrapError(err, ERROR_DELETING_FILE, path)
+	}
+	lc.logger.Info("deleted file", zap.String("path", path))
+	return res, nil
+}
+
+// DeleteTree deletes directory dir & its contents, or moves it to trash for soft delete,
+// context is checked between deleted paths
+func (lc *localStorageClient) DeleteTree(ctx context.Context, dir string, opts DeleteOptions) (res OpResult, err error) {
+	defer lc.observe("DeleteTree", time.Now(), &err)
+	defer lc.recordOp(ctx, "DeleteTree", dir, &res, &err)
+
+	res = OpResult{DryRun: opts.DryRun}
+	p, err := lc.confine(dir)
+	if err != nil {
+		return res, err
+	}
+	info, err := lstat(p)
+	if err != nil {
+		return res, err
+	}
+	if !info.IsDir() {
+		return res, errors.NewAppError(ERROR_NOT_A_DIR, dir)
+	}
+	// trash can't be moved into itself
+	if opts.Soft && within(lc.config.TrashDir, p) {
+		return res, errors.NewAppError(ERROR_CONTAINS_TRASH, dir)
+	}
+
+	paths, size, err := treePaths(ctx, dir)
+	if err != nil {
+		return res, err
+	}
+	res.Paths, res.Bytes = paths, size
+
+	if opts.Soft {
+		return lc.trash(ctx, p, res)
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+	for i, path := range paths {
+		if err := ctx.Err(); err != nil {
+			res.Paths = paths[:i]
+			return res, err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			res.Paths = paths[:i]
+			return res, errors.WrapError(err, ERROR_DELETING_FILE, path)
+		}
+	}
+	lc.logger.Info("deleted directory", zap.String("dir", dir), zap.Int("paths", len(paths)))
+	return res, nil
+}
+
+// Move moves file or directory at srcPath to destPath, creating destination directory,
+// falls back to copy & delete across devices, file moves lock source & destination
+func (lc *localStorageClient) Move(ctx context.Context, srcPath, destPath string, opts MoveOptions) (res OpResult, err error) {
+	defer lc.observe("Move", time.Now(), &err)
+	defer lc.recordOp(ctx, "Move", srcPath, &res, &err)
+
+	res = OpResult{DryRun: opts.DryRun, Target: destPath}
+	src, err := lc.confine(srcPath)
+	if err != nil {
+		return res, err
+	}
+	dest, err := lc.confine(destPath)
+	if err != nil {
+		return res, err
+	}
+	info, err := lstat(src)
+	if err != nil {
+		return res, err
+	}
+	if info.IsDir() && within(dest, src) {
+		return res, errors.NewAppError(ERROR_MOVING_FILE, srcPath, destPath)
+	}
+
+	if destInfo, err := os.Lstat(dest); err == nil {
+		if !opts.Overwrite || destInfo.IsDir() || info.IsDir() {
+			return res, errors.NewAppError(ERROR_FILE_EXISTS, destPath)
+		}
+	} else if !os.IsNotExist(err) {
+		return res, errors.WrapError(err, ERROR_FILE_INACCESSIBLE, destPath)
+	}
+
+	res.Paths, res.Bytes = []string{srcPath}, info.Size()
+	if info.IsDir() {
+		if _, res.Bytes, err = treePaths(ctx, srcPath); err != nil {
+			return res, err
+		}
+	}
+	if opts.DryRun {
+		return res, nil
+	}
+
+	if err := createDirectory(dest); err != nil {
+		return res, errors.WrapError(err, ERROR_CREATING_FILE, destPath)
+	}
+	// file moves are locked, directories aren't lockable
+	if !info.IsDir() {
+		unlock, err := lc.lockMove(ctx, src, dest)
+		if err != nil {
+			return res, err
+		}
+		defer unlock()
+	}
+	if err := lc.move(ctx, src, dest); err != nil {
+		return res, errors.WrapError(err, ERROR_MOVING_FILE, srcPath, destPath)
+	}
+	lc.logger.Info("moved", zap.String("src", srcPath), zap.String("dest", destPath))
+	return res, nil
+}
+
+// Rename renames file or directory at path to newName, within the same directory
+func (lc *localStorageClient) Rename(ctx context.Context, path, newName string, opts MoveOptions) (OpResult, error) {
+	if newName == "" || newName == "." || newName == ".." || strings.ContainsRune(newName, filepath.Separator) || strings.ContainsRune(newName, '/') {
+		return OpResult{DryRun: opts.DryRun}, errors.NewAppError(ERROR_BAD_NAME, newName)
+	}
+	return lc.Move(ctx, path, filepath.Join(filepath.Dir(path), newName), opts)
+}
+
+// PurgeTrash deletes trash entries older than retention
+func (lc *localStorageClient) PurgeTrash(ctx context.Context) (res OpResult, err error) {
+	defer lc.observe("PurgeTrash", time.Now(), &err)
+	defer lc.recordOp(ctx, "PurgeTrash", lc.config.TrashDir, &res, &err)
+
+	res = OpResult{}
+	entries, err := os.ReadDir(lc.config.TrashDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return res, errors.WrapError(err, ERROR_LISTING_DIR, lc.config.TrashDir)
+	}
+
+	cutoff := time.Now().UTC().Add(-lc.config.TrashRetention)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		deleted, err := time.Parse(TRASH_TIME_FORMAT, entry.Name())
+		if err != nil || !deleted.Before(cutoff) {
+			// not a trash entry, or within retention
+			continue
+		}
+		path := filepath.Join(lc.config.TrashDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			return res, errors.WrapError(err, ERROR_DELETING_FILE, path)
+		}
+		res.Paths = append(res.Paths, path)
+	}
+	if len(res.Paths) > 0 {
+		lc.logger.Info("purged trash", zap.Int("entries", len(res.Paths)))
+	}
+	return res, nil
+}
+
+// trash moves resolved path p into a trash entry named by deletion time,
+// keeping path relative to root, & purges expired entries
+func (lc *localStorageClient) trash(ctx context.Context, p string, res OpResult) (OpResult, error) {
+	rel := filepath.Base(p)
+	if lc.config.Root != "" {
+		if r, err := filepath.Rel(lc.config.Root, p); err == nil {
+			rel = r
+		}
+	}
+	entry := time.Now().UTC().Format(TRASH_TIME_FORMAT)
+	res.Target = filepath.Join(lc.config.TrashDir, entry, rel)
+	if res.DryRun {
+		return res, nil
+	}
+
+	if err := createDirectory(res.Target); err != nil {
+		return res, errors.WrapError(err, ERROR_CREATING_FILE, res.Target)
+	}
+	if err := lc.move(ctx, p, res.Target); err != nil {
+		return res, errors.WrapError(err, ERROR_MOVING_FILE, p, res.Target)
+	}
+	lc.logger.Info("moved to trash", zap.String("path", p), zap.String("trash", res.Target))
+
+	if _, err := lc.PurgeTrash(ctx); err != nil {
+		lc.logger.Error("error purging trash", zap.Error(err))
+	}
+	return res, nil
+}
+
+// move renames src to dest, copying & deleting src across devices
+func (lc *localStorageClient) move(ctx context.Context, src, dest string) error {
+	err := os.Rename(src, dest)
+	if err == nil {
+		return nil
+	}
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return err
+	}
+
+	lc.logger.Info("cross device move, copying", zap.String("src", src), zap.String("dest", dest))
+	if err := copyReplace(ctx, src, dest); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyReplace copies src into a temp directory next to dest & renames the copy over dest,
+// failed copies remove only the temp directory, leaving existing dest in place
+func copyReplace(ctx context.Context, src, dest string) error {
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".move-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	tmp := filepath.Join(tmpDir, filepath.Base(dest))
+	if err := copyTree(ctx, src, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// copyTree copies file, symlink or directory tree at src to dest, keeping modes & mod times
+func copyTree(ctx context.Context, src, dest string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		default:
+			if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(target, info.ModTime(), info.ModTime())
+		}
+	})
+}
+
+func copyFile(src, dest string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// treePaths returns paths of directory tree, deepest first, & total file size
+func treePaths(ctx context.Context, dir string) ([]string, int64, error) {
+	paths := []string{}
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return errors.WrapError(err, ERROR_FILE_INACCESSIBLE, path)
+		}
+		if !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	// walk lists parents before children
+	for i, j := 0, len(paths)-1; i < j; i, j = i+1, j-1 {
+		paths[i], paths[j] = paths[j], paths[i]
+	}
+	return paths, size, nil
+}
+
+// confine returns absolute path, with parent symlinks resolved,
+// erroring for the root itself & paths outside configured root
+func (lc *localStorageClient) confine(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.WrapError(err, ERROR_FILE_INACCESSIBLE, path)
+	}
+	// the path itself isn't resolved, so links are operated on, not their targets
+	p := filepath.Join(resolvePath(filepath.Dir(abs)), filepath.Base(abs))
+
+	if lc.config.Root == "" {
+		return p, nil
+	}
+	if p == lc.config.Root {
+		return "", errors.NewAppError(ERROR_ROOT_PATH, path)
+	}
+	if !within(p, lc.config.Root) {
+		return "", errors.NewAppError(ERROR_OUTSIDE_ROOT, path, lc.config.Root)
+	}
+	return p, nil
+}
+
+// confineDir checks listed directory, with symlinks resolved, is root or under configured root
+func (lc *localStorageClient) confineDir(dir string) error {
+	if lc.config.Root == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return errors.WrapError(err, ERROR_FILE_INACCESSIBLE, dir)
+	}
+	if !within(resolvePath(abs), lc.config.Root) {
+		return errors.NewAppError(ERROR_OUTSIDE_ROOT, dir, lc.config.Root)
+	}
+	return nil
+}
+
+// resolvePath resolves symlinks of the longest existing ancestor of absolute path
+func resolvePath(path string) string {
+	rest := ""
+	for p := path; ; p = filepath.Dir(p) {
+		if resolved, err := filepath.EvalSymlinks(p); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		if filepath.Dir(p) == p {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+	}
+}
+
+// within checks if path is dir or under dir
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func lstat(path string) (fs.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.WrapError(err, ERROR_NO_FILE, path)
+		}
+		return nil, errors.WrapError(err, ERROR_FILE_INACCESSIBLE, path)
+	}
+	return info, nil
+}
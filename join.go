@@ -0,0 +1,194 @@
+package localstorage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/comfforts/errors"
+
+	"github.com/comfforts/localstorage/pkg/join"
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	JOIN_AGENTS     string = "agents"
+	JOIN_PRINCIPALS string = "principals"
+)
+
+type JoinOptions struct {
+	FilingsPath    string
+	AgentsPath     string
+	PrincipalsPath string
+	Type           join.JoinType
+	// KeyField is the record field joined on, defaults to entity_num
+	KeyField string
+	// Sorted inputs, ascending by key field, are merge joined in a single pass,
+	// otherwise agents & principals are indexed in memory
+	Sorted bool
+}
+
+type EntityResponse struct {
+	Result models.BusinessEntity
+	Error  error
+}
+
+// JoinEntities joins filings with their agents & principals by entity number
+// and returns combined entity records through returned channel,
+// agents or principals path may be left empty to skip that input
+func (lc *localStorageClient) JoinEntities(ctx context.Context, opts JoinOptions) (es <-chan EntityResponse, err error) {
+	defer lc.observeSetup("JoinEntities", time.Now(), &err)
+
+	if opts.FilingsPath == "" {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	keyField := opts.KeyField
+	if keyField == "" {
+		keyField = join.DEFAULT_KEY_FIELD
+	}
+
+	// join stream is cancelled once done, returned stream is tracked on caller context
+	callerCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	ers := make(chan EntityResponse)
+
+	// read errors of every input are reported on the response stream
+	readErrs := make(chan error)
+	open := func(path string) (chan models.JSONMapper, error) {
+		if path == "" {
+			return nil, nil
+		}
+		rs, _, err := lc.readRecords(ctx, "JoinEntities", path)
+		if err != nil {
+			return nil, err
+		}
+		recCh := make(chan models.JSONMapper)
+		go splitReadStream(ctx, rs, recCh, readErrs)
+		return recCh, nil
+	}
+
+	filings, err := open(opts.FilingsPath)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	related := []join.Input{}
+	for name, path := range map[string]string{
+		JOIN_AGENTS:     opts.AgentsPath,
+		JOIN_PRINCIPALS: opts.PrincipalsPath,
+	} {
+		recCh, err := open(path)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		if recCh != nil {
+			related = append(related, join.Input{Name: name, Records: recCh})
+		}
+	}
+
+	cfg := join.Config{
+		Type: opts.Type,
+		Key:  join.FieldKey(keyField),
+	}
+	outCh := make(chan join.Joined)
+	errCh := make(chan error)
+	if opts.Sorted {
+		go join.MergeJoin(ctx, cfg, filings, related, outCh, errCh)
+	} else {
+		go join.HashJoin(ctx, cfg, filings, related, outCh, errCh)
+	}
+
+	go func() {
+		defer close(ers)
+		defer cancel()
+
+		for outCh != nil || errCh != nil {
+			var resp EntityResponse
+			select {
+			case <-ctx.Done():
+				return
+			case j, ok := <-outCh:
+				if !ok {
+					outCh = nil
+					continue
+				}
+				entity, err := toBusinessEntity(j)
+				resp.Result, resp.Error = entity, err
+			case err, ok := <-errCh:
+				if !ok {
+					errCh = nil
+					continue
+				}
+				resp.Error = err
+			case err := <-readErrs:
+				resp.Error = err
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case ers <- resp:
+			}
+		}
+	}()
+
+	return trackStream(callerCtx, lc.metrics, "JoinEntities", ers, func(er EntityResponse) error { return er.Error }), nil
+}
+
+// splitReadStream forwards read results to record chan & read errors to err chan,
+// closes record chan on done
+func splitReadStream(ctx context.Context, rs <-chan ReadResponse, recCh chan models.JSONMapper, errCh chan error) {
+	defer close(recCh)
+	for r := range rs {
+		if r.Error != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case errCh <- r.Error:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case recCh <- r.Result:
+		}
+	}
+}
+
+func toBusinessEntity(j join.Joined) (models.BusinessEntity, error) {
+	entity := models.BusinessEntity{
+		Agents:     []models.BusinessAgent{},
+		Principals: []models.BusinessPrincipal{},
+	}
+	if err := decodeRecord(j.Primary, &entity.Filing); err != nil {
+		return entity, err
+	}
+	for _, r := range j.Related[JOIN_AGENTS] {
+		var agent models.BusinessAgent
+		if err := decodeRecord(r, &agent); err != nil {
+			return entity, err
+		}
+		entity.Agents = append(entity.Agents, agent)
+	}
+	for _, r := range j.Related[JOIN_PRINCIPALS] {
+		var principal models.BusinessPrincipal
+		if err := decodeRecord(r, &principal); err != nil {
+			return entity, err
+		}
+		entity.Principals = append(entity.Principals, principal)
+	}
+	return entity, nil
+}
+
+// decodeRecord decodes record into model value, by json field names
+func decodeRecord(r JSONMapper, v interface{}) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return errors.WrapError(err, ERROR_DECODING_RESULT)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.WrapError(err, ERROR_DECODING_RESULT)
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package localstorage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/comfforts/errors"
+)
+
+const (
+	SORT_NAME     string = "name"
+	SORT_SIZE     string = "size"
+	SORT_MOD_TIME string = "mod_time"
+)
+
+// LIST_BATCH_SIZE is number of directory entries read between context checks
+const LIST_BATCH_SIZE = 1000
+
+type FileInfo struct {
+	Path    string      `json:"path"`
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mod_time"`
+	Mode    fs.FileMode `json:"mode"`
+	IsDir   bool        `json:"is_dir"`
+	// Format is record file format detected from extension, empty for directories
+	Format string `json:"format,omitempty"`
+}
+
+type ListOptions struct {
+	// Pattern, if set, filters by file name, as per filepath.Match
+	Pattern string
+	// Formats, if set, filters files by detected format, e.g. FORMAT_CSV
+	Formats []string
+	// MinSize & MaxSize, if set, filter files by size in bytes
+	MinSize int64
+	MaxSize int64
+	// ModifiedAfter & ModifiedBefore, if set, filter by modification time
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// IncludeDirs includes directories, which are otherwise only traversed
+	IncludeDirs bool
+	// Filter, if set, is applied after other filters
+	Filter func(fi FileInfo) bool
+	// SortBy is SORT_NAME, SORT_SIZE or SORT_MOD_TIME, defaults to name
+	SortBy     string
+	Descending bool
+	// Offset skips matched files & Limit, if set, caps number returned
+	Offset int
+	Limit  int
+}
+
+// match checks file info against filters
+func (o ListOptions) match(fi FileInfo) bool {
+	if fi.IsDir && !o.IncludeDirs {
+		return false
+	}
+	if o.Pattern != "" {
+		if ok, _ := filepath.Match(o.Pattern, fi.Name); !ok {
+			return false
+		}
+	}
+	if len(o.Formats) > 0 && !fi.IsDir {
+		found := false
+		for _, f := range o.Formats {
+			if f == fi.Format {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if o.MinSize > 0 && fi.Size < o.MinSize {
+		return false
+	}
+	if o.MaxSize > 0 && fi.Size > o.MaxSize {
+		return false
+	}
+	if !o.ModifiedAfter.IsZero() && !fi.ModTime.After(o.ModifiedAfter) {
+		return false
+	}
+	if !o.ModifiedBefore.IsZero() && !fi.ModTime.Before(o.ModifiedBefore) {
+		return false
+	}
+	if o.Filter != nil && !o.Filter(fi) {
+		return false
+	}
+	return true
+}
+
+// page sorts files & returns requested page
+func (o ListOptions) page(files []FileInfo) []FileInfo {
+	less := func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	}
+	switch o.SortBy {
+	case SORT_SIZE:
+		less = func(i, j int) bool {
+			if files[i].Size != files[j].Size {
+				return files[i].Size < files[j].Size
+			}
+			return files[i].Path < files[j].Path
+		}
+	case SORT_MOD_TIME:
+		less = func(i, j int) bool {
+			if !files[i].ModTime.Equal(files[j].ModTime) {
+				return files[i].ModTime.Before(files[j].ModTime)
+			}
+			return files[i].Path < files[j].Path
+		}
+	}
+	if o.Descending {
+		asc := less
+		less = func(i, j int) bool {
+			return asc(j, i)
+		}
+	}
+	sort.Slice(files, less)
+
+	if o.Offset > 0 {
+		if o.Offset >= len(files) {
+			return []FileInfo{}
+		}
+		files = files[o.Offset:]
+	}
+	if o.Limit > 0 && o.Limit < len(files) {
+		files = files[:o.Limit]
+	}
+	return files
+}
+
+// List returns matching entries of directory dir, sorted & paginated as per options,
+// dir must be under configured root, if any,
+// entries are read in batches, checking context between batches
+func (lc *localStorageClient) List(ctx context.Context, dir string, opts ListOptions) (fis []FileInfo, err error) {
+	defer lc.observe("List", time.Now(), &err)
+
+	if err := lc.confineDir(dir); err != nil {
+		return nil, err
+	}
+	if err := checkDirectory(dir); err != nil {
+		return nil, err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_OPENING_FILE, dir)
+	}
+	defer d.Close()
+
+	files := []FileInfo{}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entries, err := d.ReadDir(LIST_BATCH_SIZE)
+		for _, entry := range entries {
+			fi, err := toFileInfo(filepath.Join(dir, entry.Name()), entry)
+			if err != nil {
+				if os.IsNotExist(err) {
+					// removed while listing
+					continue
+				}
+				return nil, err
+			}
+			if opts.match(fi) {
+				files = append(files, fi)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.WrapError(err, ERROR_LISTING_DIR, dir)
+		}
+	}
+	return opts.page(files), nil
+}
+
+// Walk calls fn with matching files under root, recursively, in lexical order,
+// root must be under configured root, if any, symlinked directories aren't followed,
+// Offset & Limit apply in walk order, SortBy is ignored,
+// fn may return filepath.SkipDir to skip a directory's contents,
+// other fn errors stop the walk & are returned
+func (lc *localStorageClient) Walk(ctx context.Context, root string, opts ListOptions, fn func(fi FileInfo) error) (err error) {
+	defer lc.observe("Walk", time.Now(), &err)
+
+	if err := lc.confineDir(root); err != nil {
+		return err
+	}
+	if err := checkDirectory(root); err != nil {
+		return err
+	}
+
+	skipped, count := 0, 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return errors.WrapError(err, ERROR_FILE_INACCESSIBLE, path)
+		}
+		if path == root {
+			return nil
+		}
+
+		fi, err := toFileInfo(path, d)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !opts.match(fi) {
+			return nil
+		}
+		if skipped < opts.Offset {
+			skipped++
+			return nil
+		}
+		if opts.Limit > 0 && count >= opts.Limit {
+			return errWalkDone
+		}
+		count++
+		return fn(fi)
+	})
+	if err == errWalkDone {
+		return nil
+	}
+	return err
+}
+
+// errWalkDone stops walk once limit is reached
+var errWalkDone = errors.NewAppError("walk done")
+
+// Glob returns files matching path pattern, as per filepath.Match for each path element,
+// a "**" element matches any number of directories,
+// pattern's directory must be under configured root, if any,
+// matches are filtered, sorted & paginated as per options
+func (lc *localStorageClient) Glob(ctx context.Context, pattern string, opts ListOptions) (fis []FileInfo, err error) {
+	defer lc.observe("Glob", time.Now(), &err)
+
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, errors.WrapError(err, ERROR_BAD_PATTERN, pattern)
+	}
+
+	root, segments := globRoot(pattern)
+	if err := lc.confineDir(root); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(root); err != nil {
+		if os.IsNotExist(err) {
+			return []FileInfo{}, nil
+		}
+		return nil, errors.WrapError(err, ERROR_FILE_INACCESSIBLE, root)
+	}
+
+	files := []FileInfo{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return errors.WrapError(err, ERROR_FILE_INACCESSIBLE, path)
+		}
+		if path == root {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		elems := strings.Split(filepath.ToSlash(rel), "/")
+		if globMatch(segments, elems) {
+			fi, err := toFileInfo(path, d)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err == nil && opts.match(fi) {
+				files = append(files, fi)
+			}
+		}
+		if d.IsDir() && !globPrefix(segments, elems) {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return opts.page(files), nil
+}
+
+// globRoot splits pattern into directory without pattern characters to walk from
+// & remaining pattern elements
+func globRoot(pattern string) (string, []string) {
+	elems := strings.Split(filepath.ToSlash(pattern), "/")
+	i := 0
+	for ; i < len(elems)-1; i++ {
+		if strings.ContainsAny(elems[i], `*?[\`) {
+			break
+		}
+	}
+	root := strings.Join(elems[:i], "/")
+	if root == "" && i > 0 {
+		// absolute pattern
+		root = "/"
+	}
+	if root == "" {
+		root = "."
+	}
+	return filepath.FromSlash(root), elems[i:]
+}
+
+// globMatch matches path elements against pattern elements
+func globMatch(pattern, elems []string) bool {
+	if len(pattern) == 0 {
+		return len(elems) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(elems); i++ {
+			if globMatch(pattern[1:], elems[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(elems) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], elems[0]); !ok {
+		return false
+	}
+	return globMatch(pattern[1:], elems[1:])
+}
+
+// globPrefix checks if contents of directory with given elements may match
+func globPrefix(pattern, elems []string) bool {
+	for i, e := range elems {
+		if i >= len(pattern) {
+			return false
+		}
+		if pattern[i] == "**" {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern[i], e); !ok {
+			return false
+		}
+	}
+	return len(elems) < len(pattern)
+}
+
+func toFileInfo(path string, d fs.DirEntry) (FileInfo, error) {
+	info, err := d.Info()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	fi := FileInfo{
+		Path:    path,
+		Name:    info.Name(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+		IsDir:   info.IsDir(),
+	}
+	if !fi.IsDir {
+		fi.Format = fileFormat(path)
+	}
+	return fi, nil
+}
+
+func checkDirectory(dir string) error {
+	info, err := fileStats(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.NewAppError(ERROR_NOT_A_DIR, dir)
+	}
+	return nil
+}
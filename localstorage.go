@@ -8,18 +8,35 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sync/atomic"
+	"time"
 
 	"github.com/comfforts/errors"
 	"github.com/comfforts/logger"
 
+	"github.com/comfforts/localstorage/pkg/audit"
+	"github.com/comfforts/localstorage/pkg/charset"
 	csvFiler "github.com/comfforts/localstorage/pkg/csv"
+	"github.com/comfforts/localstorage/pkg/index"
 	jsonFiler "github.com/comfforts/localstorage/pkg/json"
+	"github.com/comfforts/localstorage/pkg/lock"
+	"github.com/comfforts/localstorage/pkg/metrics"
+	"github.com/comfforts/localstorage/pkg/profile"
+	"github.com/comfforts/localstorage/pkg/trace"
 )
 
 const DEFAULT_BUFFER_SIZE = 1000
 
 type JSONMapper = map[string]interface{}
 
+type KeyValue = jsonFiler.KeyValue
+
+type CSVConfig = csvFiler.CSVConfig
+
+type JSONConfig = jsonFiler.JSONConfig
+
+type LockConfig = lock.Config
+
 type ReadResponse struct {
 	Result JSONMapper
 	Error  error
@@ -31,63 +48,307 @@ type WriteResponse struct {
 
 type LocalStorage interface {
 	ReadJSONFile(ctx context.Context, filePath string, resCh chan JSONMapper, errCh chan error) error
+	ReadJSONFileWithConfig(ctx context.Context, filePath string, cfg JSONConfig, resCh chan JSONMapper, errCh chan error) error
 	ReadCSVFile(ctx context.Context, filePath string, resCh chan []string, errCh chan error) error
+	ReadCSVFileWithConfig(ctx context.Context, filePath string, cfg CSVConfig, resCh chan []string, errCh chan error) error
+	ReadCSVRecords(ctx context.Context, filePath string, cfg CSVConfig, resCh chan JSONMapper, errCh chan error) error
+	ReadRecords(ctx context.Context, filePath string, cfg CSVConfig) (<-chan ReadResponse, []string, error)
+	ReadFiles(ctx context.Context, source string, opts ReadFilesOptions) (<-chan FileResponse, []FileInfo, error)
+	ReadJSONObject(ctx context.Context, filePath string, resCh chan KeyValue, errCh chan error) error
 	ReadFileArray(ctx context.Context, cancel func(), filePath string) (<-chan ReadResponse, error)
 	WriteFile(ctx context.Context, cancel func(), fileName string, reqStream chan JSONMapper) <-chan WriteResponse
+	WriteJSONObject(ctx context.Context, cancel func(), fileName string, reqStream chan KeyValue) <-chan WriteResponse
+	Process(ctx context.Context, rs <-chan ReadResponse, cfg ProcessConfig, fn ProcessFunc) (<-chan ReadResponse, error)
+	ProcessWriteStream(ctx context.Context, reqStream chan JSONMapper, cfg ProcessConfig, fn ProcessFunc, errCh chan error) (chan JSONMapper, error)
 	Copy(srcPath, destPath string) (int64, error)
 	CopyBuf(srcPath, destPath string) (int64, error)
+	SortFile(ctx context.Context, srcPath, fileName string, opts SortOptions) error
+	DedupeFile(ctx context.Context, srcPath, fileName string, opts DedupeOptions) error
+	OpenIndex(filePath, keyField string) (*index.Index, error)
+	JoinEntities(ctx context.Context, opts JoinOptions) (<-chan EntityResponse, error)
+	Convert(ctx context.Context, srcPath, dstPath string, opts ConvertOptions) error
+	ProfileFile(ctx context.Context, filePath string, opts ProfileOptions) (*profile.Profile, error)
+	List(ctx context.Context, dir string, opts ListOptions) ([]FileInfo, error)
+	Glob(ctx context.Context, pattern string, opts ListOptions) ([]FileInfo, error)
+	Walk(ctx context.Context, root string, opts ListOptions, fn func(fi FileInfo) error) error
+	Delete(ctx context.Context, path string, opts DeleteOptions) (OpResult, error)
+	DeleteTree(ctx context.Context, dir string, opts DeleteOptions) (OpResult, error)
+	Move(ctx context.Context, srcPath, destPath string, opts MoveOptions) (OpResult, error)
+	Rename(ctx context.Context, path, newName string, opts MoveOptions) (OpResult, error)
+	PurgeTrash(ctx context.Context) (OpResult, error)
+	Watch(ctx context.Context, dir string, cfg WatchConfig, evCh chan WatchEvent, errCh chan error) error
+	LockFile(ctx context.Context, path string) (*lock.Lock, error)
+	RLockFile(ctx context.Context, path string) (*lock.Lock, error)
+	WriteShards(ctx context.Context, filePath string, opts ShardOptions, recCh chan JSONMapper) ([]Shard, error)
+	WritePartitions(ctx context.Context, dir string, opts PartitionOptions, recCh chan JSONMapper) ([]Shard, error)
+	AuditHead() AuditHead
+}
+
+type Config struct {
+	// Root, if set, confines delete, move & rename operations, & listing, to paths under it
+	Root string
+	// TrashDir holds soft deleted paths, defaults to DEFAULT_TRASH_DIR under root,
+	// or under working directory without root
+	TrashDir string
+	// TrashRetention is how long soft deleted paths are kept, defaults to DEFAULT_TRASH_RETENTION
+	TrashRetention time.Duration
+	// Lock configures locks taken around writes & copies, and around reads with LockReads
+	Lock      LockConfig
+	LockReads bool
+	// Metrics, if set, records I/O metrics of client methods
+	Metrics Metrics
+	// Tracer, if set, opens spans around reads, writes & copies
+	Tracer Tracer
+	// Audit, if Path is set, appends mutating operations to an audit log, chained by keyed hashes
+	Audit AuditConfig
+	// Encryption, if Keys are set, encrypts files written by WriteFile & Copy,
+	// ReadFileArray & ReadCSVFile decrypt encrypted files
+	Encryption EncryptionConfig
 }
 
 type localStorageClient struct {
-	logger logger.AppLogger
+	config  Config
+	locker  *lock.Locker
+	metrics Metrics
+	tracer  Tracer
+	audit   *audit.Log
+	logger  logger.AppLogger
 }
 
 func NewLocalStorageClient(logger logger.AppLogger) (*localStorageClient, error) {
+	return NewLocalStorageClientWithConfig(Config{}, logger)
+}
+
+func NewLocalStorageClientWithConfig(cfg Config, logger logger.AppLogger) (*localStorageClient, error) {
 	if logger == nil {
 		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
 	}
+
+	if cfg.Root != "" {
+		if err := checkDirectory(cfg.Root); err != nil {
+			return nil, err
+		}
+		root, err := filepath.Abs(cfg.Root)
+		if err != nil {
+			return nil, errors.WrapError(err, ERROR_FILE_INACCESSIBLE, cfg.Root)
+		}
+		// symlinks resolved, so confined paths compare to the real root
+		root, err = filepath.EvalSymlinks(root)
+		if err != nil {
+			return nil, errors.WrapError(err, ERROR_FILE_INACCESSIBLE, cfg.Root)
+		}
+		cfg.Root = root
+	}
+	if cfg.TrashDir == "" {
+		cfg.TrashDir = filepath.Join(cfg.Root, DEFAULT_TRASH_DIR)
+	}
+	trashDir, err := filepath.Abs(cfg.TrashDir)
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_FILE_INACCESSIBLE, cfg.TrashDir)
+	}
+	cfg.TrashDir = resolvePath(trashDir)
+	if cfg.TrashRetention <= 0 {
+		cfg.TrashRetention = DEFAULT_TRASH_RETENTION
+	}
+
+	locker, err := lock.NewLocker(cfg.Lock, logger)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Metrics == nil {
+		cfg.Metrics = metrics.Noop{}
+	}
+	if cfg.Tracer == nil {
+		cfg.Tracer = trace.Noop{}
+	}
+	var auditLog *audit.Log
+	if cfg.Audit.Path != "" {
+		auditLog, err = audit.NewLog(cfg.Audit, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	loaderClient := &localStorageClient{
-		logger: logger,
+		config:  cfg,
+		locker:  locker,
+		metrics: cfg.Metrics,
+		tracer:  cfg.Tracer,
+		audit:   auditLog,
+		logger:  logger,
 	}
 
 	return loaderClient, nil
 }
 
 func (lc *localStorageClient) ReadJSONFile(ctx context.Context, filePath string, resCh chan JSONMapper, errCh chan error) error {
+	return lc.ReadJSONFileWithConfig(ctx, filePath, JSONConfig{}, resCh, errCh)
+}
+
+// ReadJSONFileWithConfig reads json array file, decoded from configured encoding
+func (lc *localStorageClient) ReadJSONFileWithConfig(ctx context.Context, filePath string, cfg JSONConfig, resCh chan JSONMapper, errCh chan error) (err error) {
+	defer lc.observeSetup("ReadJSONFile", time.Now(), &err)
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
 
-	jsonFile, err := jsonFiler.NewJSONFiler(file, lc.logger)
+	src, err := lc.decryptReader(ctx, file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	jsonFile, err := jsonFiler.NewJSONFilerWithReader(file, src, cfg, lc.logger)
 	if err != nil {
+		file.Close()
 		return err
 	}
 
-	go jsonFile.ReadJSONFile(ctx, resCh, errCh)
+	lk, err := lc.lockRead(ctx, filePath)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	go func() {
+		defer lc.unlock(lk)
+		defer file.Close()
+		fResCh, fErrCh := make(chan JSONMapper), make(chan error)
+		go jsonFile.ReadJSONFile(ctx, fResCh, fErrCh)
+		forward(ctx, lc.metrics, "ReadJSONFile", file, fResCh, fErrCh, resCh, errCh)
+	}()
+	return nil
+}
+
+// ReadJSONObject reads a top-level json object from existing file, one key at a time,
+// and sends key/value pairs on res chan
+func (lc *localStorageClient) ReadJSONObject(ctx context.Context, filePath string, resCh chan KeyValue, errCh chan error) (err error) {
+	defer lc.observeSetup("ReadJSONObject", time.Now(), &err)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+
+	src, err := lc.decryptReader(ctx, file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	jsonFile, err := jsonFiler.NewJSONFilerWithReader(file, src, JSONConfig{}, lc.logger)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	lk, err := lc.lockRead(ctx, filePath)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	go func() {
+		defer lc.unlock(lk)
+		defer file.Close()
+		fResCh, fErrCh := make(chan KeyValue), make(chan error)
+		go jsonFile.ReadJSONObject(ctx, fResCh, fErrCh)
+		forward(ctx, lc.metrics, "ReadJSONObject", file, fResCh, fErrCh, resCh, errCh)
+	}()
 	return nil
 }
 
 func (lc *localStorageClient) ReadCSVFile(ctx context.Context, filePath string, resCh chan []string, errCh chan error) error {
+	return lc.ReadCSVFileWithConfig(ctx, filePath, CSVConfig{}, resCh, errCh)
+}
+
+// ReadCSVFileWithConfig reads csv file, decoded from configured encoding
+func (lc *localStorageClient) ReadCSVFileWithConfig(ctx context.Context, filePath string, cfg CSVConfig, resCh chan []string, errCh chan error) (err error) {
+	defer lc.observeSetup("ReadCSVFile", time.Now(), &err)
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
 	}
+	src, err := lc.decryptReader(ctx, file)
+	if err != nil {
+		file.Close()
+		return err
+	}
 
-	csvFile, err := csvFiler.NewCSVFiler(file, lc.logger)
+	csvFile, err := csvFiler.NewCSVFilerWithReader(file, src, cfg, lc.logger)
 	if err != nil {
+		file.Close()
 		return err
 	}
 
-	go csvFile.ReadCSVFile(ctx, resCh, errCh)
+	lk, err := lc.lockRead(ctx, filePath)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	go func() {
+		defer lc.unlock(lk)
+		defer file.Close()
+		fResCh, fErrCh := make(chan []string), make(chan error)
+		go csvFile.ReadCSVFile(ctx, fResCh, fErrCh)
+		forward(ctx, lc.metrics, "ReadCSVFile", file, fResCh, fErrCh, resCh, errCh)
+	}()
+	return nil
+}
+
+// ReadCSVRecords reads csv file rows as records keyed by header,
+// short & long rows are handled as per configured row policy
+func (lc *localStorageClient) ReadCSVRecords(ctx context.Context, filePath string, cfg CSVConfig, resCh chan JSONMapper, errCh chan error) (err error) {
+	defer lc.observeSetup("ReadCSVRecords", time.Now(), &err)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+
+	src, err := lc.decryptReader(ctx, file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	csvFile, err := csvFiler.NewCSVFilerWithReader(file, src, cfg, lc.logger)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	lk, err := lc.lockRead(ctx, filePath)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	go func() {
+		defer lc.unlock(lk)
+		defer file.Close()
+		fResCh, fErrCh := make(chan JSONMapper), make(chan error)
+		go csvFile.ReadCSVRecords(ctx, fResCh, fErrCh)
+		forward(ctx, lc.metrics, "ReadCSVRecords", file, fResCh, fErrCh, resCh, errCh)
+	}()
 	return nil
 }
 
 // ReadFileArray reads an array of json data from existing file, one by one,
 // and returns individual result at defined rate through returned channel
-func (lc *localStorageClient) ReadFileArray(ctx context.Context, cancel func(), filePath string) (<-chan ReadResponse, error) {
+func (lc *localStorageClient) ReadFileArray(ctx context.Context, cancel func(), filePath string) (rs <-chan ReadResponse, err error) {
+	defer lc.observeSetup("ReadFileArray", time.Now(), &err)
+	ctx, span := lc.startSpan(ctx, "ReadFileArray", trace.String(trace.FILE_PATH, filePath))
+	defer func() {
+		if err != nil {
+			endSpan(span, err)
+		}
+	}()
+
 	// checks if file exists
-	_, err := fileStats(filePath)
+	fi, err := fileStats(filePath)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(trace.Int64(trace.FILE_SIZE, fi.Size()))
+
+	lk, err := lc.lockRead(ctx, filePath)
 	if err != nil {
 		return nil, err
 	}
@@ -95,11 +356,22 @@ func (lc *localStorageClient) ReadFileArray(ctx context.Context, cancel func(),
 	// Open file
 	f, err := os.Open(filePath)
 	if err != nil {
+		lc.unlock(lk)
 		return nil, errors.WrapError(err, ERROR_OPENING_FILE, filePath)
 	}
+	src, err := lc.decryptReader(ctx, f)
+	if err != nil {
+		f.Close()
+		lc.unlock(lk)
+		return nil, err
+	}
 
 	resultStream := make(chan ReadResponse)
-	go lc.readFile(ctx, cancel, filePath, f, resultStream)
+	lc.metrics.StreamStarted("ReadFileArray")
+	go func() {
+		defer lc.unlock(lk)
+		lc.readFile(ctx, cancel, span, filePath, f, src, resultStream)
+	}()
 
 	return resultStream, nil
 }
@@ -107,13 +379,47 @@ func (lc *localStorageClient) ReadFileArray(ctx context.Context, cancel func(),
 func (lc *localStorageClient) WriteFile(ctx context.Context, cancel func(), fileName string, reqStream chan JSONMapper) <-chan WriteResponse {
 	filePath := filepath.Join("data", fileName)
 
+	ctx, span := lc.startSpan(ctx, "WriteFile", trace.String(trace.FILE_PATH, filePath))
 	resultStream := make(chan WriteResponse)
-	go lc.writeFile(ctx, cancel, filePath, reqStream, resultStream)
+	go lc.writeFile(ctx, cancel, span, filePath, reqStream, resultStream)
 
-	return resultStream
+	rs := lc.recordStream(ctx, AuditEntry{Op: "WriteFile", Path: filePath}, resultStream)
+	return trackStream(ctx, lc.metrics, "WriteFile", rs, writeError)
 }
 
-func (lc *localStorageClient) Copy(srcPath, destPath string) (int64, error) {
+// WriteJSONObject streams key/value pairs from request stream
+// into a top-level json object file
+func (lc *localStorageClient) WriteJSONObject(ctx context.Context, cancel func(), fileName string, reqStream chan KeyValue) <-chan WriteResponse {
+	filePath := filepath.Join("data", fileName)
+
+	resultStream := make(chan WriteResponse)
+	go lc.writeJSONObject(ctx, cancel, filePath, reqStream, resultStream)
+
+	rs := lc.recordStream(ctx, AuditEntry{Op: "WriteJSONObject", Path: filePath}, resultStream)
+	return trackStream(ctx, lc.metrics, "WriteJSONObject", rs, writeError)
+}
+
+// Copy copies srcPath to destPath, encrypting it if encryption is configured,
+// returns bytes written to destPath
+func (lc *localStorageClient) Copy(srcPath, destPath string) (nBytes int64, err error) {
+	start := time.Now()
+	// copies take no context, their spans are root spans
+	_, span := lc.startSpan(context.Background(), "Copy",
+		trace.String(trace.FILE_PATH, srcPath),
+		trace.String(trace.DEST_PATH, destPath),
+	)
+	// bytes read are of src, bytes written of dest, they differ for encrypted copies
+	var nRead int64
+	defer func() {
+		// audit failures are observed as copy's error
+		lc.recordResult(context.Background(), AuditEntry{Op: "Copy", Path: srcPath, Dest: destPath, Bytes: nBytes}, &err)
+		lc.metrics.AddBytesRead("Copy", nRead)
+		lc.metrics.AddBytesWritten("Copy", nBytes)
+		lc.metrics.ObserveLatency("Copy", time.Since(start), err)
+		span.SetAttributes(trace.Int64(trace.IO_BYTES, nBytes))
+		endSpan(span, err)
+	}()
+
 	srcStat, err := os.Stat(srcPath)
 	if err != nil {
 		return 0, errors.WrapError(err, ERROR_NO_FILE, srcPath)
@@ -133,17 +439,50 @@ func (lc *localStorageClient) Copy(srcPath, destPath string) (int64, error) {
 		return 0, err
 	}
 
+	unlock, err := lc.lockCopy(srcPath, destPath)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
 	dest, err := os.Create(destPath)
 	if err != nil {
 		return 0, errors.WrapError(err, ERROR_CREATING_FILE, destPath)
 	}
 	defer dest.Close()
 
-	nBytes, err := io.Copy(dest, src)
-	return nBytes, err
+	cw := &byteCounter{w: dest}
+	w, closeEnc, err := lc.copyWriter(context.Background(), src, cw)
+	if err != nil {
+		return 0, err
+	}
+	nRead, err = io.Copy(w, src)
+	if err == nil {
+		err = closeEnc()
+	}
+	return cw.n, err
 }
 
-func (lc *localStorageClient) CopyBuf(srcPath, destPath string) (int64, error) {
+// CopyBuf copies srcPath to destPath through a DEFAULT_BUFFER_SIZE buffer,
+// encrypting it if encryption is configured, returns bytes written to destPath
+func (lc *localStorageClient) CopyBuf(srcPath, destPath string) (nBytes int64, err error) {
+	start := time.Now()
+	_, span := lc.startSpan(context.Background(), "CopyBuf",
+		trace.String(trace.FILE_PATH, srcPath),
+		trace.String(trace.DEST_PATH, destPath),
+	)
+	// bytes read are of src, bytes written of dest, they differ for encrypted copies
+	var nRead int64
+	defer func() {
+		// audit failures are observed as copy's error
+		lc.recordResult(context.Background(), AuditEntry{Op: "CopyBuf", Path: srcPath, Dest: destPath, Bytes: nBytes}, &err)
+		lc.metrics.AddBytesRead("CopyBuf", nRead)
+		lc.metrics.AddBytesWritten("CopyBuf", nBytes)
+		lc.metrics.ObserveLatency("CopyBuf", time.Since(start), err)
+		span.SetAttributes(trace.Int64(trace.IO_BYTES, nBytes))
+		endSpan(span, err)
+	}()
+
 	srcStat, err := os.Stat(srcPath)
 	if err != nil {
 		return 0, errors.WrapError(err, ERROR_NO_FILE, srcPath)
@@ -163,35 +502,90 @@ func (lc *localStorageClient) CopyBuf(srcPath, destPath string) (int64, error) {
 		return 0, err
 	}
 
+	unlock, err := lc.lockCopy(srcPath, destPath)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
 	dest, err := os.Create(destPath)
 	if err != nil {
 		return 0, errors.WrapError(err, ERROR_CREATING_FILE, destPath)
 	}
 	defer dest.Close()
 
+	cw := &byteCounter{w: dest}
+	w, closeEnc, err := lc.copyWriter(context.Background(), src, cw)
+	if err != nil {
+		return 0, err
+	}
+
 	buf := make([]byte, DEFAULT_BUFFER_SIZE)
-	var nBytes int64 = 0
 	for {
 		nr, err := src.Read(buf)
 		if err != nil && err != io.EOF {
-			return nBytes, errors.WrapError(err, ERROR_READING_FILE, srcPath)
+			return cw.n, errors.WrapError(err, ERROR_READING_FILE, srcPath)
 		}
 		if nr == 0 {
 			break
 		}
-		nw, err := dest.Write(buf[:nr])
-		if err != nil {
-			return nBytes, errors.WrapError(err, ERROR_WRITING_FILE, srcPath)
+		nRead = nRead + int64(nr)
+		if _, err := w.Write(buf[:nr]); err != nil {
+			return cw.n, errors.WrapError(err, ERROR_WRITING_FILE, srcPath)
 		}
-		nBytes = nBytes + int64(nw)
+	}
+	if err := closeEnc(); err != nil {
+		return cw.n, errors.WrapError(err, ERROR_WRITING_FILE, destPath)
+	}
+
+	return cw.n, err
+}
+
+// OpenIndex opens sidecar key index of json array, ndjson or csv file,
+// building it if missing or stale, for direct lookups by key field,
+// encrypted files can't be indexed, their offsets are of ciphertext
+func (lc *localStorageClient) OpenIndex(filePath, keyField string) (idx *index.Index, err error) {
+	defer lc.observe("OpenIndex", time.Now(), &err)
+
+	if _, err := fileStats(filePath); err != nil {
+		return nil, err
+	}
+	encrypted, err := isEncrypted(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted {
+		return nil, errors.NewAppError(ERROR_ENCRYPTED_INDEX, filePath)
 	}
 
-	return nBytes, err
+	var format string
+	switch fileFormat(filePath) {
+	case FORMAT_JSON:
+		format = index.FORMAT_JSON
+	case FORMAT_NDJSON:
+		format = index.FORMAT_NDJSON
+	case FORMAT_CSV:
+		format = index.FORMAT_CSV
+	default:
+		return nil, errors.NewAppError(ERROR_UNSUPPORTED_FORMAT, filePath)
+	}
+	return index.Open(filePath, keyField, format, lc.logger)
 }
 
-func (lc *localStorageClient) readFile(ctx context.Context, cancel func(), filePath string, file io.ReadCloser, rrs chan ReadResponse) {
+// readFile reads json array from src, file's content, possibly decrypted
+func (lc *localStorageClient) readFile(ctx context.Context, cancel func(), span trace.Span, filePath string, file io.ReadSeekCloser, src io.Reader, rrs chan ReadResponse) {
+	// response stream is sent to directly, as caller stops reading once it's cancelled
+	start, records, errs := time.Now(), 0, 0
+	var first error
 	defer close(rrs)
 	defer func() {
+		lc.metrics.AddRecordsRead("ReadFileArray", records)
+		lc.metrics.AddDecodeErrors("ReadFileArray", errs)
+		lc.metrics.AddBytesRead("ReadFileArray", offset(file))
+		lc.metrics.StreamEnded("ReadFileArray")
+		lc.metrics.ObserveLatency("ReadFileArray", time.Since(start), first)
+		span.SetAttributes(trace.Int64(trace.RECORD_COUNT, int64(records)))
+		endSpan(span, first)
 		lc.logger.Info("closing result stream and file")
 		if err := file.Close(); err != nil {
 			rrs <- ReadResponse{
@@ -200,12 +594,23 @@ func (lc *localStorageClient) readFile(ctx context.Context, cancel func(), fileP
 		}
 	}()
 
-	r := bufio.NewReader(file)
+	// strips byte order mark & decodes non utf-8 content
+	cr, _, err := charset.NewReader(src, charset.AUTO)
+	if err != nil {
+		first = errors.WrapError(err, ERROR_READING_FILE, filePath)
+		rrs <- ReadResponse{
+			Error: first,
+		}
+		cancel()
+		return
+	}
+	r := bufio.NewReader(cr)
 	dec := json.NewDecoder(r)
 
 	// read open bracket
 	t, err := dec.Token()
 	if err != nil || t != json.Delim('[') {
+		first, errs = ErrStartToken, errs+1
 		rrs <- ReadResponse{
 			Error: ErrStartToken,
 		}
@@ -220,8 +625,13 @@ func (lc *localStorageClient) readFile(ctx context.Context, cancel func(), fileP
 		var response = ReadResponse{}
 		if err != nil {
 			response.Error = errors.WrapError(err, ERROR_DECODING_RESULT)
+			if first == nil {
+				first = response.Error
+			}
+			errs++
 		} else {
 			response.Result = result
+			records++
 		}
 		select {
 		case <-ctx.Done():
@@ -233,6 +643,10 @@ func (lc *localStorageClient) readFile(ctx context.Context, cancel func(), fileP
 	// read closing bracket
 	t, err = dec.Token()
 	if err != nil || t != json.Delim(']') {
+		if first == nil {
+			first = ErrEndToken
+		}
+		errs++
 		rrs <- ReadResponse{
 			Error: ErrEndToken,
 		}
@@ -241,12 +655,96 @@ func (lc *localStorageClient) readFile(ctx context.Context, cancel func(), fileP
 	}
 }
 
-func (lc *localStorageClient) writeFile(ctx context.Context, cancel func(), filePath string, reqStream chan JSONMapper, wrs chan WriteResponse) {
+func (lc *localStorageClient) writeFile(ctx context.Context, cancel func(), span trace.Span, filePath string, reqStream chan JSONMapper, wrs chan WriteResponse) {
 	defer func() {
 		lc.logger.Info("closing write response stream")
+		span.End()
 		close(wrs)
 	}()
-	file, err := os.OpenFile(filePath, os.O_CREATE, os.ModePerm)
+	if err := createDirectory(filePath); err != nil {
+		wrs <- failed(span, errors.WrapError(err, ERROR_CREATING_FILE, filePath))
+		cancel()
+		return
+	}
+
+	lk, err := lc.locker.Lock(ctx, filePath)
+	if err != nil {
+		wrs <- failed(span, err)
+		cancel()
+		return
+	}
+	defer lc.unlock(lk)
+
+	file, err := createTemp(filePath)
+	if err != nil {
+		wrs <- failed(span, errors.WrapError(err, ERROR_CREATING_FILE, filePath))
+		cancel()
+		return
+	}
+	defer func() {
+		// complete file replaces existing one, failed & cancelled writes leave it as is
+		if err != nil {
+			discardTemp(file)
+			return
+		}
+		if err := commitTemp(file, filePath); err != nil {
+			wrs <- failed(span, errors.WrapError(err, ERROR_CLOSING_FILE, filePath))
+		}
+	}()
+	var records int64
+	defer func() {
+		lc.metrics.AddRecordsWritten("WriteFile", int(atomic.LoadInt64(&records)))
+		lc.metrics.AddBytesWritten("WriteFile", offset(file))
+		span.SetAttributes(
+			trace.Int64(trace.RECORD_COUNT, atomic.LoadInt64(&records)),
+			trace.Int64(trace.FILE_SIZE, offset(file)),
+		)
+	}()
+
+	w, closeEnc, err := lc.encryptWriter(ctx, file)
+	if err != nil {
+		wrs <- failed(span, errors.WrapError(err, ERROR_CREATING_FILE, filePath))
+		cancel()
+		return
+	}
+
+	// streams records into file instead of buffering the whole array
+	err = jsonFiler.WriteJSONArray(ctx, w, countRecords(ctx, reqStream, &records))
+	if err == nil {
+		err = closeEnc()
+	}
+	if err != nil {
+		wrs <- failed(span, errors.WrapError(err, ERROR_WRITING_FILE, filePath))
+		cancel()
+		return
+	}
+}
+
+func (lc *localStorageClient) writeJSONObject(ctx context.Context, cancel func(), filePath string, reqStream chan KeyValue, wrs chan WriteResponse) {
+	defer func() {
+		lc.logger.Info("closing write response stream")
+		close(wrs)
+	}()
+
+	if err := createDirectory(filePath); err != nil {
+		wrs <- WriteResponse{
+			Error: errors.WrapError(err, ERROR_CREATING_FILE, filePath),
+		}
+		cancel()
+		return
+	}
+
+	lk, err := lc.locker.Lock(ctx, filePath)
+	if err != nil {
+		wrs <- WriteResponse{
+			Error: err,
+		}
+		cancel()
+		return
+	}
+	defer lc.unlock(lk)
+
+	file, err := createTemp(filePath)
 	if err != nil {
 		wrs <- WriteResponse{
 			Error: errors.WrapError(err, ERROR_CREATING_FILE, filePath),
@@ -255,29 +753,81 @@ func (lc *localStorageClient) writeFile(ctx context.Context, cancel func(), file
 		return
 	}
 	defer func() {
-		if err := file.Close(); err != nil {
+		// complete file replaces existing one, failed & cancelled writes leave it as is
+		if err != nil {
+			discardTemp(file)
+			return
+		}
+		if err := commitTemp(file, filePath); err != nil {
 			wrs <- WriteResponse{
 				Error: errors.WrapError(err, ERROR_CLOSING_FILE, filePath),
 			}
 		}
 	}()
+	var records int64
+	defer func() {
+		lc.metrics.AddRecordsWritten("WriteJSONObject", int(atomic.LoadInt64(&records)))
+		lc.metrics.AddBytesWritten("WriteJSONObject", offset(file))
+	}()
 
-	jsonData := []JSONMapper{}
-	for req := range reqStream {
-		jsonData = append(jsonData, req)
+	w, closeEnc, err := lc.encryptWriter(ctx, file)
+	if err != nil {
+		wrs <- WriteResponse{
+			Error: err,
+		}
+		cancel()
+		return
+	}
+	err = jsonFiler.WriteJSONObject(ctx, w, countRecords(ctx, reqStream, &records))
+	if err == nil {
+		err = closeEnc()
 	}
-
-	enc := json.NewEncoder(file)
-	err = enc.Encode(jsonData)
 	if err != nil {
 		wrs <- WriteResponse{
-			Error: errors.WrapError(err, ERROR_CREATING_FILE, filePath),
+			Error: errors.WrapError(err, ERROR_WRITING_FILE, filePath),
 		}
 		cancel()
 		return
 	}
 }
 
+// createTemp creates temp file in filePath's directory, with existing file's mode,
+// it's renamed over filePath once written, so failed writes leave existing file in place
+func createTemp(filePath string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := f.Chmod(mode); err != nil {
+		discardTemp(f)
+		return nil, err
+	}
+	return f, nil
+}
+
+// commitTemp closes written temp file & renames it over filePath
+func commitTemp(f *os.File, filePath string) error {
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filePath); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// discardTemp closes & removes temp file of failed write
+func discardTemp(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
 func createDirectory(path string) error {
 	_, err := os.Stat(filepath.Dir(path))
 	if err != nil {
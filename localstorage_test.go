@@ -2,14 +2,34 @@ package localstorage
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf16"
 
 	"github.com/comfforts/logger"
 	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/charset"
+	"github.com/comfforts/localstorage/pkg/dedupe"
+	"github.com/comfforts/localstorage/pkg/join"
+	"github.com/comfforts/localstorage/pkg/metrics"
+	"github.com/comfforts/localstorage/pkg/process"
+	"github.com/comfforts/localstorage/pkg/profile"
+	"github.com/comfforts/localstorage/pkg/schema"
+	"github.com/comfforts/localstorage/pkg/trace"
+	"github.com/comfforts/localstorage/pkg/watch"
 )
 
 const TEST_DIR = "data"
@@ -141,6 +161,29 @@ func TestLocalFileStorage(t *testing.T) {
 		"local storage copy json file succeeds":              testCopy,
 		"local storage copy json file buffered succeeds":     testCopyBuffer,
 		"file stats test succeeds":                           testFileStats,
+		"local storage write read json object succeeds":      testWriteReadJSONObject,
+		"local storage schema validated read write succeeds": testValidateReadWriteStream,
+		"local storage sort file succeeds":                   testSortFile,
+		"local storage join entities succeeds":               testJoinEntities,
+		"local storage dedupe file succeeds":                 testDedupeFile,
+		"local storage open index succeeds":                  testOpenIndex,
+		"local storage read encoded csv file succeeds":       testReadCSVFileEncoding,
+		"local storage convert csv json succeeds":            testConvert,
+		"local storage profile file succeeds":                testProfileFile,
+		"local storage list glob walk succeeds":              testListGlobWalk,
+		"local storage delete move rename succeeds":          testDeleteMoveRename,
+		"local storage cross device move replaces dest":      testCopyReplace,
+		"local storage copy tree succeeds":                   testCopyTree,
+		"local storage watch sends complete files":           testWatch,
+		"local storage locked writes & reads succeed":        testLockedWrites,
+		"local storage write shards succeeds":                testWriteShards,
+		"local storage write partitions succeeds":            testWritePartitions,
+		"local storage read files succeeds":                  testReadFiles,
+		"local storage process records succeeds":             testProcess,
+		"local storage metrics succeeds":                     testMetrics,
+		"local storage tracing succeeds":                     testTracing,
+		"local storage audit log succeeds":                   testAudit,
+		"local storage encryption succeeds":                  testEncryption,
 		// "read write file array succeeds":                     testReadWriteFileArray,
 	} {
 		testDir := fmt.Sprintf("%s/", TEST_DIR)
@@ -284,6 +327,346 @@ func testFileStats(t *testing.T, client LocalStorage, testDir string) {
 	require.NoError(t, err)
 }
 
+func testWriteReadJSONObject(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	name := "data-object.json"
+	reqStream := make(chan KeyValue)
+	respStream := client.WriteJSONObject(ctx, cancel, name, reqStream)
+
+	go func() {
+		defer close(reqStream)
+		for _, item := range createStoreJSONList() {
+			reqStream <- KeyValue{Key: fmt.Sprintf("S%d", item["store_id"]), Value: item}
+		}
+	}()
+	for r := range respStream {
+		require.NoError(t, r.Error)
+	}
+
+	resCh := make(chan KeyValue)
+	errCh := make(chan error)
+	err := client.ReadJSONObject(ctx, filepath.Join(testDir, name), resCh, errCh)
+	require.NoError(t, err)
+
+	keys := map[string]bool{}
+	for resCh != nil || errCh != nil {
+		select {
+		case kv, ok := <-resCh:
+			if !ok {
+				resCh = nil
+				continue
+			}
+			t.Logf(" testWriteReadJSONObject: key: %s, value: %v", kv.Key, kv.Value)
+			keys[kv.Key] = true
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, map[string]bool{"S1": true, "S6": true, "S8": true}, keys)
+}
+
+func testValidateReadWriteStream(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, err := schema.Parse([]byte(`{
+		"type": "object",
+		"required": ["name", "store_id"],
+		"properties": {"store_id": {"type": "integer", "maximum": 6}}
+	}`))
+	require.NoError(t, err)
+
+	fPath, err := createJSONFile(testDir, "data")
+	require.NoError(t, err)
+
+	resultStream, err := client.ReadFileArray(ctx, cancel, fPath)
+	require.NoError(t, err)
+
+	reqStream := make(chan JSONMapper)
+	errCh := make(chan error)
+	respStream := client.WriteFile(ctx, cancel, "data-valid.json", ValidateWriteStream(ctx, s, reqStream, errCh))
+
+	violations := []error{}
+	violationsDone := make(chan struct{})
+	go func() {
+		defer close(violationsDone)
+		for err := range errCh {
+			violations = append(violations, err)
+		}
+	}()
+
+	readViolations := 0
+	for r := range ValidateReadStream(ctx, s, resultStream) {
+		if r.Error != nil {
+			t.Logf(" testValidateReadWriteStream: read violation: %v", r.Error)
+			v, ok := r.Error.(schema.Violation)
+			require.Equal(t, true, ok)
+			require.Equal(t, 2, v.Index)
+			require.Equal(t, "/store_id", v.Pointer)
+			readViolations++
+			continue
+		}
+		reqStream <- r.Result
+	}
+	reqStream <- JSONMapper{"name": "Missing Id"}
+	close(reqStream)
+
+	for r := range respStream {
+		require.NoError(t, r.Error)
+	}
+	<-violationsDone
+	require.Equal(t, 1, readViolations)
+	require.Equal(t, 1, len(violations))
+
+	f, err := os.Open(filepath.Join(testDir, "data-valid.json"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	items := []JSONMapper{}
+	err = json.NewDecoder(f).Decode(&items)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(items))
+}
+
+func testSortFile(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fPath, err := createJSONFile(testDir, "data")
+	require.NoError(t, err)
+
+	// json to csv, spilling every record into its own run
+	err = client.SortFile(ctx, fPath, "data-sorted.csv", SortOptions{
+		Keys:         []string{"name"},
+		MemoryBudget: 1,
+		TempDir:      testDir,
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(filepath.Join(testDir, "data-sorted.csv"))
+	require.NoError(t, err)
+	t.Logf(" testSortFile: sorted csv:\n%s", data)
+
+	// csv back to json, by city then name
+	err = client.SortFile(ctx, filepath.Join(testDir, "data-sorted.csv"), "data-sorted.json", SortOptions{
+		Keys: []string{"city", "name"},
+	})
+	require.NoError(t, err)
+
+	f, err := os.Open(filepath.Join(testDir, "data-sorted.json"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	items := []JSONMapper{}
+	err = json.NewDecoder(f).Decode(&items)
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, item := range items {
+		names = append(names, item["name"].(string))
+	}
+	require.Equal(t, []string{"Exchange Square", "Plaza Hollywood", "Telford Plaza"}, names)
+
+	err = client.SortFile(ctx, fPath, "data-sorted.xml", SortOptions{Keys: []string{"name"}})
+	require.Error(t, err)
+
+	// csv fields sort numerically
+	csvPath := filepath.Join(testDir, "counts.csv")
+	err = os.WriteFile(csvPath, []byte("name|count\nten|10\nnine|9\nhundred|100\nminus|-1\n"), os.ModePerm)
+	require.NoError(t, err)
+	err = client.SortFile(ctx, csvPath, "counts-sorted.csv", SortOptions{Keys: []string{"count"}})
+	require.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(testDir, "counts-sorted.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "name|count\nminus|-1\nnine|9\nten|10\nhundred|100\n", string(data))
+
+	// cancelled sorts fail & leave no partial output
+	cancelled, cancelSort := context.WithCancel(ctx)
+	cancelSort()
+	err = client.SortFile(cancelled, csvPath, "counts-cancelled.json", SortOptions{Keys: []string{"count"}})
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = os.Stat(filepath.Join(testDir, "counts-cancelled.json"))
+	require.True(t, os.IsNotExist(err))
+
+	// failed & cancelled sorts keep existing output & leave no temp files
+	err = client.SortFile(cancelled, csvPath, "counts-sorted.csv", SortOptions{Keys: []string{"count"}})
+	require.ErrorIs(t, err, context.Canceled)
+	mixedPath := filepath.Join(testDir, "counts-mixed.json")
+	err = os.WriteFile(mixedPath, []byte(`[{"name": "ten", "count": 10}, {"name": "one", "extra": 1}]`), os.ModePerm)
+	require.NoError(t, err)
+	err = client.SortFile(ctx, mixedPath, "counts-sorted.csv", SortOptions{Keys: []string{"name"}})
+	require.Error(t, err)
+	kept, err := os.ReadFile(filepath.Join(testDir, "counts-sorted.csv"))
+	require.NoError(t, err)
+	require.Equal(t, data, kept)
+	temps, err := filepath.Glob(filepath.Join(testDir, ".counts-sorted.csv.tmp-*"))
+	require.NoError(t, err)
+	require.Empty(t, temps)
+}
+
+func testJoinEntities(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filingsPath, err := createRecordsFile(testDir, "filings", []JSONMapper{
+		{"EntityName": "Plaza Hollywood LLC", "entity_num": "C0001", "Jurisdiction": "CA"},
+		{"EntityName": "Exchange Square Inc", "entity_num": "C0006", "Jurisdiction": "NV"},
+		{"EntityName": "Telford Plaza Corp", "entity_num": "C0008", "Jurisdiction": "CA"},
+	})
+	require.NoError(t, err)
+	agentsPath, err := createRecordsFile(testDir, "agents", []JSONMapper{
+		{"entity_num": "C0001", "LastName": "Wong", "AgentType": "Individual"},
+		{"entity_num": "C0001", "LastName": "Chan", "AgentType": "Individual"},
+		{"entity_num": "C0008", "OrgName": "Registered Agents Inc", "AgentType": "Corporation"},
+	})
+	require.NoError(t, err)
+
+	principalsPath := filepath.Join(testDir, "principals.csv")
+	err = os.WriteFile(principalsPath, []byte("entity_num|FirstName|LastName|PositionType\nC0001|Mei|Wong|CEO\nC0006|Tak|Lee|Secretary\n"), os.ModePerm)
+	require.NoError(t, err)
+
+	for _, sorted := range []bool{true, false} {
+		for joinType, count := range map[join.JoinType]int{join.LEFT: 3, join.INNER: 1} {
+			ers, err := client.JoinEntities(ctx, JoinOptions{
+				FilingsPath:    filingsPath,
+				AgentsPath:     agentsPath,
+				PrincipalsPath: principalsPath,
+				Type:           joinType,
+				Sorted:         sorted,
+			})
+			require.NoError(t, err)
+
+			entities := []JSONMapper{}
+			for r := range ers {
+				require.NoError(t, r.Error)
+				t.Logf(" testJoinEntities: entity: %v", r.Result)
+				entities = append(entities, JSONMapper{
+					"entity_num": r.Result.Filing.EntityNum,
+					"agents":     len(r.Result.Agents),
+					"principals": len(r.Result.Principals),
+				})
+			}
+			require.Equal(t, count, len(entities))
+			require.Equal(t, JSONMapper{"entity_num": "C0001", "agents": 2, "principals": 1}, entities[0])
+		}
+	}
+}
+
+func testDedupeFile(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fPath := filepath.Join(testDir, "agents-dupes.csv")
+	err := os.WriteFile(fPath, []byte("entity_num|LastName|AgentType\nC0001|Wong|Individual\nC0001|Wong|Corporation\nC0002|Chan|Individual\nC0001|Lee|Individual\n"), os.ModePerm)
+	require.NoError(t, err)
+
+	for _, budget := range []int64{0, 1} {
+		err = client.DedupeFile(ctx, fPath, "agents-deduped.csv", DedupeOptions{
+			Fields:       []string{"entity_num", "LastName"},
+			Policy:       dedupe.LAST_WINS,
+			MemoryBudget: budget,
+			TempDir:      testDir,
+		})
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(filepath.Join(testDir, "agents-deduped.csv"))
+		require.NoError(t, err)
+		t.Logf(" testDedupeFile: deduped:\n%s", data)
+		require.Equal(t, 4, len(strings.Split(strings.TrimSpace(string(data)), "\n")))
+		require.Equal(t, true, strings.Contains(string(data), "C0001|Wong|Corporation"))
+		require.Equal(t, false, strings.Contains(string(data), "C0001|Wong|Individual"))
+	}
+}
+
+func testOpenIndex(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fPath, err := createJSONFile(testDir, "data")
+	require.NoError(t, err)
+
+	err = client.SortFile(ctx, fPath, "data-sorted.ndjson", SortOptions{Keys: []string{"name"}})
+	require.NoError(t, err)
+
+	for _, p := range []string{fPath, filepath.Join(testDir, "data-sorted.ndjson")} {
+		idx, err := client.OpenIndex(p, "store_id")
+		require.NoError(t, err)
+		require.Equal(t, 3, idx.Len())
+
+		rs, err := idx.Get("6")
+		require.NoError(t, err)
+		require.Equal(t, 1, len(rs))
+		require.Equal(t, "Exchange Square", rs[0]["name"])
+	}
+
+	_, err = client.OpenIndex(filepath.Join(testDir, "missing.json"), "store_id")
+	require.Error(t, err)
+}
+
+func testReadCSVFileEncoding(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	text := "entity_num|EntityName\nC0001|Café Déjà Vu\n"
+	utf16LE := []byte{0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(text)) {
+		utf16LE = append(utf16LE, byte(u), byte(u>>8))
+	}
+
+	for name, data := range map[string][]byte{
+		"agents-utf8-bom.csv": append([]byte{0xEF, 0xBB, 0xBF}, []byte(text)...),
+		"agents-utf16le.csv":  utf16LE,
+	} {
+		fPath := filepath.Join(testDir, name)
+		err := os.WriteFile(fPath, data, os.ModePerm)
+		require.NoError(t, err)
+
+		resCh := make(chan []string)
+		errCh := make(chan error)
+		err = client.ReadCSVFileWithConfig(ctx, fPath, CSVConfig{Encoding: charset.AUTO}, resCh, errCh)
+		require.NoError(t, err)
+
+		rows := [][]string{}
+		for resCh != nil || errCh != nil {
+			select {
+			case r, ok := <-resCh:
+				if !ok {
+					resCh = nil
+					continue
+				}
+				rows = append(rows, r)
+			case err, ok := <-errCh:
+				if !ok {
+					errCh = nil
+					continue
+				}
+				require.NoError(t, err)
+			}
+		}
+		require.Equal(t, [][]string{{"entity_num", "EntityName"}, {"C0001", "Café Déjà Vu"}}, rows)
+	}
+}
+
+func createRecordsFile(dir, name string, items []JSONMapper) (string, error) {
+	fPath := filepath.Join(dir, fmt.Sprintf("%s.json", name))
+	err := os.MkdirAll(filepath.Dir(fPath), os.ModePerm)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return "", err
+	}
+	return fPath, os.WriteFile(fPath, data, os.ModePerm)
+}
+
 func createJSONFile(dir, name string) (string, error) {
 	fPath := fmt.Sprintf("%s.json", name)
 	if dir != "" {
@@ -442,3 +825,1400 @@ func createStoreJSON(storeId uint64, name, org, city, country string) JSONMapper
 // 		}
 // 	}()
 // }
+
+func testConvert(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	csvPath := filepath.Join(testDir, "filings.csv")
+	err := os.WriteFile(csvPath, []byte("entity_num|employees|active|address.city|address.zip\nC0001|12|true|Los Angeles|02134\nC0008||false|San Diego|92101\n"), os.ModePerm)
+	require.NoError(t, err)
+
+	// csv to json, with inferred types & nested address
+	jsonPath := filepath.Join(testDir, "filings.json")
+	err = client.Convert(ctx, csvPath, jsonPath, ConvertOptions{
+		InferTypes: true,
+		Nest:       true,
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(jsonPath)
+	require.NoError(t, err)
+	items := []JSONMapper{}
+	err = json.Unmarshal(data, &items)
+	require.NoError(t, err)
+	require.Equal(t, []JSONMapper{
+		{"entity_num": "C0001", "employees": float64(12), "active": true, "address": map[string]interface{}{"city": "Los Angeles", "zip": "02134"}},
+		{"entity_num": "C0008", "employees": nil, "active": false, "address": map[string]interface{}{"city": "San Diego", "zip": float64(92101)}},
+	}, items)
+
+	// json back to csv, flattening nested address
+	err = client.Convert(ctx, jsonPath, filepath.Join(testDir, "filings-flat.csv"), ConvertOptions{})
+	require.NoError(t, err)
+
+	data, err = os.ReadFile(filepath.Join(testDir, "filings-flat.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "active|address.city|address.zip|employees|entity_num\ntrue|Los Angeles|02134|12|C0001\nfalse|San Diego|92101||C0008\n", string(data))
+
+	err = client.Convert(ctx, jsonPath, filepath.Join(testDir, "filings.xml"), ConvertOptions{})
+	require.Error(t, err)
+
+	// nesting conflicting columns fails, instead of dropping one
+	conflictPath := filepath.Join(testDir, "conflict.csv")
+	err = os.WriteFile(conflictPath, []byte("entity_num|address|address.city\nC0001|123 Main St|Los Angeles\n"), os.ModePerm)
+	require.NoError(t, err)
+	err = client.Convert(ctx, conflictPath, filepath.Join(testDir, "conflict.json"), ConvertOptions{Nest: true})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "key address.city conflicts with value at address")
+	_, err = os.Stat(filepath.Join(testDir, "conflict.json"))
+	require.True(t, os.IsNotExist(err))
+
+	// csv columns are union of all records' keys
+	mixedPath, err := createRecordsFile(testDir, "mixed", []JSONMapper{
+		{"entity_num": "C0001"},
+		{"entity_num": "C0006", "agent": map[string]interface{}{"name": "Wong"}},
+	})
+	require.NoError(t, err)
+	err = client.Convert(ctx, mixedPath, filepath.Join(testDir, "mixed.csv"), ConvertOptions{})
+	require.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(testDir, "mixed.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "agent.name|entity_num\n|C0001\nWong|C0006\n", string(data))
+
+	// sorts infer columns from first record, later keys fail the write
+	err = client.SortFile(ctx, mixedPath, "mixed-sorted.csv", SortOptions{Keys: []string{"entity_num"}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "record key agent not in csv columns")
+	_, err = os.Stat(filepath.Join(testDir, "mixed-sorted.csv"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func testProfileFile(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	csvPath := filepath.Join(testDir, "filings.csv")
+	err := os.WriteFile(csvPath, []byte("entity_num|employees|state\nC0001|12|CA\nC0002||NV\nC0003|3.5|CA\n"), os.ModePerm)
+	require.NoError(t, err)
+
+	prof, err := client.ProfileFile(ctx, csvPath, ProfileOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 3, prof.Records)
+	require.Equal(t, []string{"entity_num", "employees", "state"}, []string{prof.Columns[0].Name, prof.Columns[1].Name, prof.Columns[2].Name})
+
+	employees := prof.Column("employees")
+	require.Equal(t, profile.TYPE_NUMBER, employees.Type)
+	require.Equal(t, 1, employees.Empty)
+	require.Equal(t, 3.5, employees.Min)
+	require.Equal(t, 12.0, employees.Max)
+	require.Equal(t, uint64(2), prof.Column("state").Distinct)
+
+	fPath, err := createJSONFile(testDir, "data")
+	require.NoError(t, err)
+	prof, err = client.ProfileFile(ctx, fPath, ProfileOptions{Columns: []string{"name"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(prof.Columns))
+	require.Equal(t, profile.TYPE_STRING, prof.Columns[0].Type)
+	require.Equal(t, 0, prof.Columns[0].Nulls)
+}
+
+func testListGlobWalk(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root := filepath.Join(testDir, "extracts")
+	for path, size := range map[string]int{
+		"ca/filings.csv":        30,
+		"ca/agents.csv":         10,
+		"ca/2023/filings.json":  20,
+		"nv/filings.ndjson":     40,
+		"nv/readme.md":          5,
+		"filings.json":          50,
+		"ca/2023/q1/agents.csv": 15,
+	} {
+		fPath := filepath.Join(root, path)
+		err := os.MkdirAll(filepath.Dir(fPath), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(fPath, []byte(strings.Repeat("x", size)), os.ModePerm)
+		require.NoError(t, err)
+	}
+	paths := func(files []FileInfo) []string {
+		ps := []string{}
+		for _, f := range files {
+			rel, err := filepath.Rel(root, f.Path)
+			require.NoError(t, err)
+			ps = append(ps, filepath.ToSlash(rel))
+		}
+		return ps
+	}
+
+	files, err := client.List(ctx, filepath.Join(root, "ca"), ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ca/agents.csv", "ca/filings.csv"}, paths(files))
+	require.Equal(t, FORMAT_CSV, files[0].Format)
+	require.Equal(t, int64(10), files[0].Size)
+
+	files, err = client.List(ctx, filepath.Join(root, "ca"), ListOptions{IncludeDirs: true, Descending: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ca/filings.csv", "ca/agents.csv", "ca/2023"}, paths(files))
+	require.True(t, files[2].IsDir)
+	require.Equal(t, "", files[2].Format)
+
+	files, err = client.Glob(ctx, filepath.Join(root, "**", "*.csv"), ListOptions{SortBy: SORT_SIZE})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ca/agents.csv", "ca/2023/q1/agents.csv", "ca/filings.csv"}, paths(files))
+
+	files, err = client.Glob(ctx, filepath.Join(root, "*", "filings.*"), ListOptions{Formats: []string{FORMAT_CSV, FORMAT_NDJSON}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ca/filings.csv", "nv/filings.ndjson"}, paths(files))
+
+	files, err = client.Glob(ctx, filepath.Join(root, "missing", "*.csv"), ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 0, len(files))
+
+	_, err = client.Glob(ctx, filepath.Join(root, "[.csv"), ListOptions{})
+	require.Error(t, err)
+
+	walked := []FileInfo{}
+	err = client.Walk(ctx, root, ListOptions{Pattern: "*.*json", Offset: 1, Limit: 2}, func(fi FileInfo) error {
+		walked = append(walked, fi)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"filings.json", "nv/filings.ndjson"}, paths(walked))
+
+	walked = walked[:0]
+	err = client.Walk(ctx, root, ListOptions{MinSize: 20}, func(fi FileInfo) error {
+		walked = append(walked, fi)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"ca/2023/filings.json", "ca/filings.csv", "filings.json", "nv/filings.ndjson"}, paths(walked))
+
+	_, err = client.List(ctx, filepath.Join(root, "filings.json"), ListOptions{})
+	require.Error(t, err)
+
+	cancelled, cancelList := context.WithCancel(ctx)
+	cancelList()
+	_, err = client.List(cancelled, root, ListOptions{})
+	require.ErrorIs(t, err, context.Canceled)
+	err = client.Walk(cancelled, root, ListOptions{}, func(fi FileInfo) error { return nil })
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func testCopyReplace(t *testing.T, client LocalStorage, testDir string) {
+	src, dest := filepath.Join(testDir, "src.json"), filepath.Join(testDir, "dest.json")
+	require.NoError(t, os.WriteFile(src, []byte("new"), os.ModePerm))
+	require.NoError(t, os.WriteFile(dest, []byte("old"), os.ModePerm))
+
+	// cancelled copy keeps existing dest & leaves no temp files
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := copyReplace(ctx, src, dest)
+	require.ErrorIs(t, err, context.Canceled)
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, "old", string(data))
+
+	err = copyReplace(context.Background(), src, dest)
+	require.NoError(t, err)
+	data, err = os.ReadFile(dest)
+	require.NoError(t, err)
+	require.Equal(t, "new", string(data))
+
+	entries, err := os.ReadDir(testDir)
+	require.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.ElementsMatch(t, []string{"src.json", "dest.json"}, names)
+}
+
+func testDeleteMoveRename(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root := filepath.Join(testDir, "root")
+	for _, path := range []string{"out/filings.json", "out/agents.csv", "out/tmp/part-1.json", "out/tmp/part-2.json"} {
+		fPath := filepath.Join(root, path)
+		err := os.MkdirAll(filepath.Dir(fPath), os.ModePerm)
+		require.NoError(t, err)
+		err = os.WriteFile(fPath, []byte("[]"), os.ModePerm)
+		require.NoError(t, err)
+	}
+	outside := filepath.Join(testDir, "outside.json")
+	err := os.WriteFile(outside, []byte("[]"), os.ModePerm)
+	require.NoError(t, err)
+
+	rooted, err := NewLocalStorageClientWithConfig(Config{
+		Root:           root,
+		TrashRetention: time.Hour,
+	}, logger.NewTestAppLogger(TEST_DIR))
+	require.NoError(t, err)
+
+	// confined to root
+	_, err = rooted.Delete(ctx, outside, DeleteOptions{})
+	require.Error(t, err)
+	_, err = rooted.Delete(ctx, filepath.Join(root, "..", "outside.json"), DeleteOptions{})
+	require.Error(t, err)
+	_, err = rooted.DeleteTree(ctx, root, DeleteOptions{})
+	require.Error(t, err)
+	_, err = rooted.Move(ctx, filepath.Join(root, "out", "agents.csv"), outside, MoveOptions{Overwrite: true})
+	require.Error(t, err)
+	require.FileExists(t, outside)
+
+	// listing is confined to root too
+	_, err = rooted.List(ctx, testDir, ListOptions{})
+	require.Error(t, err)
+	err = rooted.Walk(ctx, filepath.Join(root, ".."), ListOptions{}, func(fi FileInfo) error { return nil })
+	require.Error(t, err)
+	_, err = rooted.Glob(ctx, filepath.Join(testDir, "*.json"), ListOptions{})
+	require.Error(t, err)
+	_, _, err = rooted.ReadFiles(ctx, testDir, ReadFilesOptions{})
+	require.Error(t, err)
+	if err := os.Symlink(filepath.Join(testDir), filepath.Join(root, "linked")); err == nil {
+		_, err = rooted.List(ctx, filepath.Join(root, "linked"), ListOptions{})
+		require.Error(t, err)
+		require.NoError(t, os.Remove(filepath.Join(root, "linked")))
+	}
+	fis, err := rooted.List(ctx, root, ListOptions{IncludeDirs: true})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(fis))
+	fis, err = rooted.Glob(ctx, filepath.Join(root, "**", "*.json"), ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(fis))
+
+	// dry run reports without deleting
+	res, err := rooted.DeleteTree(ctx, filepath.Join(root, "out", "tmp"), DeleteOptions{DryRun: true})
+	require.NoError(t, err)
+	require.True(t, res.DryRun)
+	require.Equal(t, 3, len(res.Paths))
+	require.Equal(t, int64(4), res.Bytes)
+	require.Equal(t, filepath.Join(root, "out", "tmp"), res.Paths[2])
+	require.DirExists(t, filepath.Join(root, "out", "tmp"))
+
+	res, err = rooted.DeleteTree(ctx, filepath.Join(root, "out", "tmp"), DeleteOptions{})
+	require.NoError(t, err)
+	require.NoDirExists(t, filepath.Join(root, "out", "tmp"))
+
+	// rename & move, refusing to overwrite unless asked
+	_, err = rooted.Rename(ctx, filepath.Join(root, "out", "agents.csv"), "filings.json", MoveOptions{})
+	require.Error(t, err)
+	_, err = rooted.Rename(ctx, filepath.Join(root, "out", "agents.csv"), "../agents.csv", MoveOptions{})
+	require.Error(t, err)
+	res, err = rooted.Rename(ctx, filepath.Join(root, "out", "agents.csv"), "agents-2023.csv", MoveOptions{})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(root, "out", "agents-2023.csv"), res.Target)
+	require.FileExists(t, filepath.Join(root, "out", "agents-2023.csv"))
+
+	_, err = rooted.Move(ctx, filepath.Join(root, "out", "agents-2023.csv"), filepath.Join(root, "out", "filings.json"), MoveOptions{DryRun: true, Overwrite: true})
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(root, "out", "agents-2023.csv"))
+
+	res, err = rooted.Move(ctx, filepath.Join(root, "out"), filepath.Join(root, "published", "2023"), MoveOptions{})
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(root, "published", "2023", "filings.json"))
+	require.NoDirExists(t, filepath.Join(root, "out"))
+
+	// soft delete keeps path under trash entry
+	res, err = rooted.Delete(ctx, filepath.Join(root, "published", "2023", "filings.json"), DeleteOptions{Soft: true})
+	require.NoError(t, err)
+	require.NoFileExists(t, filepath.Join(root, "published", "2023", "filings.json"))
+	require.FileExists(t, res.Target)
+	require.True(t, strings.HasSuffix(res.Target, filepath.Join("published", "2023", "filings.json")))
+
+	// expired trash entries are purged
+	expired := filepath.Join(root, DEFAULT_TRASH_DIR, time.Now().UTC().Add(-2*time.Hour).Format(TRASH_TIME_FORMAT))
+	err = os.MkdirAll(expired, os.ModePerm)
+	require.NoError(t, err)
+	res, err = rooted.PurgeTrash(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.Paths))
+	require.NoDirExists(t, expired)
+	require.DirExists(t, filepath.Join(root, DEFAULT_TRASH_DIR))
+
+	_, err = rooted.DeleteTree(ctx, filepath.Join(root, DEFAULT_TRASH_DIR), DeleteOptions{Soft: true})
+	require.Error(t, err)
+}
+
+func testCopyTree(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src := filepath.Join(testDir, "src")
+	err := os.MkdirAll(filepath.Join(src, "nested"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(src, "nested", "filings.json"), []byte("[{}]"), 0640)
+	require.NoError(t, err)
+	err = os.Symlink("nested/filings.json", filepath.Join(src, "latest.json"))
+	require.NoError(t, err)
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	err = os.Chtimes(filepath.Join(src, "nested", "filings.json"), modTime, modTime)
+	require.NoError(t, err)
+
+	dest := filepath.Join(testDir, "dest")
+	err = copyTree(ctx, src, dest)
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(dest, "nested", "filings.json"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	require.True(t, info.ModTime().Equal(modTime))
+	link, err := os.Readlink(filepath.Join(dest, "latest.json"))
+	require.NoError(t, err)
+	require.Equal(t, "nested/filings.json", link)
+}
+
+func testWatch(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := filepath.Join(testDir, "drops")
+	err := os.MkdirAll(dir, os.ModePerm)
+	require.NoError(t, err)
+
+	evCh, errCh := make(chan WatchEvent), make(chan error)
+	err = client.Watch(ctx, dir, WatchConfig{Debounce: 20 * time.Millisecond, Stable: 50 * time.Millisecond, Pattern: "*.csv"}, evCh, errCh)
+	require.NoError(t, err)
+
+	drop := filepath.Join(dir, "filings.csv")
+	err = os.WriteFile(drop, []byte("entity_num|state\nC0001|CA\n"), os.ModePerm)
+	require.NoError(t, err)
+
+	var ready string
+	for ready == "" {
+		select {
+		case ev := <-evCh:
+			if ev.Op == watch.COMPLETE {
+				ready = ev.Path
+			}
+		case err := <-errCh:
+			require.NoError(t, err)
+		case <-ctx.Done():
+			require.FailNow(t, "timed out waiting for complete file")
+		}
+	}
+	cancel()
+	require.Equal(t, drop, ready)
+
+	resCh, readErrCh := make(chan []string), make(chan error)
+	err = client.ReadCSVFile(context.Background(), ready, resCh, readErrCh)
+	require.NoError(t, err)
+	rows := 0
+	for range resCh {
+		rows++
+	}
+	require.Equal(t, 2, rows)
+
+	err = client.Watch(ctx, drop, WatchConfig{}, evCh, errCh)
+	require.Error(t, err)
+}
+
+func testLockedWrites(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// write waits for held lock
+	fPath := filepath.Join("data", "locked.json")
+	lk, err := client.LockFile(ctx, fPath)
+	require.NoError(t, err)
+
+	reqStream := make(chan JSONMapper)
+	respStream := client.WriteFile(ctx, cancel, "locked.json", reqStream)
+	go func() {
+		defer close(reqStream)
+		reqStream <- JSONMapper{"entity_num": "C0001"}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// locks don't create missing files, waiting write hasn't started
+	require.NoFileExists(t, fPath)
+
+	require.NoError(t, lk.Unlock())
+	for resp := range respStream {
+		require.NoError(t, resp.Error)
+	}
+	content, err := os.ReadFile(fPath)
+	require.NoError(t, err)
+	require.JSONEq(t, `[{"entity_num": "C0001"}]`, string(content))
+
+	// cancelled writes keep existing file
+	for _, write := range []func(ctx context.Context, cancel func()) <-chan WriteResponse{
+		func(ctx context.Context, cancel func()) <-chan WriteResponse {
+			reqStream := make(chan JSONMapper)
+			go func() {
+				reqStream <- JSONMapper{"entity_num": "C0006"}
+				cancel()
+			}()
+			return client.WriteFile(ctx, cancel, "locked.json", reqStream)
+		},
+		func(ctx context.Context, cancel func()) <-chan WriteResponse {
+			reqStream := make(chan KeyValue)
+			go func() {
+				reqStream <- KeyValue{Key: "C0006", Value: "Lee"}
+				cancel()
+			}()
+			return client.WriteJSONObject(ctx, cancel, "locked.json", reqStream)
+		},
+	} {
+		writeCtx, writeCancel := context.WithCancel(ctx)
+		for range write(writeCtx, writeCancel) {
+		}
+		kept, err := os.ReadFile(fPath)
+		require.NoError(t, err)
+		require.Equal(t, content, kept)
+	}
+	temps, err := filepath.Glob(filepath.Join("data", ".locked.json.tmp-*"))
+	require.NoError(t, err)
+	require.Empty(t, temps)
+
+	// concurrent copies into same destination don't interleave
+	srcPath, err := createJSONFile(testDir, "src")
+	require.NoError(t, err)
+	src, err := os.ReadFile(srcPath)
+	require.NoError(t, err)
+	destPath := filepath.Join(testDir, "copies", "dest.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.CopyBuf(srcPath, destPath)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	dest, err := os.ReadFile(destPath)
+	require.NoError(t, err)
+	require.Equal(t, src, dest)
+
+	// locked reads time out while write lock is held
+	appLogger := logger.NewTestAppLogger(TEST_DIR)
+	lockingClient, err := NewLocalStorageClientWithConfig(Config{
+		Lock:      LockConfig{Timeout: 50 * time.Millisecond},
+		LockReads: true,
+	}, appLogger)
+	require.NoError(t, err)
+	lk, err = lockingClient.LockFile(ctx, fPath)
+	require.NoError(t, err)
+	_, err = lockingClient.ReadFileArray(ctx, cancel, fPath)
+	require.Error(t, err)
+	// failed reads close opened file
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		err = lockingClient.ReadJSONFile(ctx, fPath, make(chan JSONMapper), make(chan error))
+		require.Error(t, err)
+		err = lockingClient.ReadCSVRecords(ctx, fPath, CSVConfig{}, make(chan JSONMapper), make(chan error))
+		require.Error(t, err)
+		after, err := os.ReadDir("/proc/self/fd")
+		require.NoError(t, err)
+		require.Equal(t, len(fds), len(after))
+	}
+	require.NoError(t, lk.Unlock())
+
+	resultStream, err := lockingClient.ReadFileArray(ctx, cancel, fPath)
+	require.NoError(t, err)
+	count := 0
+	for r := range resultStream {
+		require.NoError(t, r.Error)
+		count++
+	}
+	require.Equal(t, 1, count)
+
+	// released once read is done
+	lk, err = lockingClient.LockFile(ctx, fPath)
+	require.NoError(t, err)
+	require.NoError(t, lk.Unlock())
+
+	// copies time out on held locks
+	lk, err = lockingClient.LockFile(ctx, destPath)
+	require.NoError(t, err)
+	_, err = lockingClient.Copy(srcPath, destPath)
+	require.Error(t, err)
+	require.NoError(t, lk.Unlock())
+
+	// copies take locks in path order, so opposite copies don't deadlock,
+	// copy into lower path holds its lock while waiting for source
+	copyingClient, err := NewLocalStorageClientWithConfig(Config{
+		Lock:      LockConfig{Timeout: 500 * time.Millisecond},
+		LockReads: true,
+	}, appLogger)
+	require.NoError(t, err)
+	require.Less(t, lockOrder(destPath), lockOrder(srcPath))
+	lk, err = lockingClient.LockFile(ctx, srcPath)
+	require.NoError(t, err)
+	copied := make(chan error)
+	go func() {
+		_, err := copyingClient.Copy(srcPath, destPath)
+		copied <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	_, err = lockingClient.LockFile(ctx, destPath)
+	require.Error(t, err)
+	require.NoError(t, lk.Unlock())
+	require.NoError(t, <-copied)
+
+	// cancelled locked reads release their lock
+	readCtx, readCancel := context.WithCancel(ctx)
+	jsonResCh := make(chan JSONMapper)
+	err = lockingClient.ReadJSONFile(readCtx, srcPath, jsonResCh, make(chan error))
+	require.NoError(t, err)
+	<-jsonResCh
+	readCancel()
+	lk, err = copyingClient.LockFile(ctx, srcPath)
+	require.NoError(t, err)
+	require.NoError(t, lk.Unlock())
+
+	// transform outputs, deletes & moves wait for held locks
+	sortedPath := filepath.Join("data", "locked-sorted.json")
+	lk, err = lockingClient.LockFile(ctx, sortedPath)
+	require.NoError(t, err)
+	err = lockingClient.SortFile(ctx, srcPath, "locked-sorted.json", SortOptions{Keys: []string{"entity_num"}})
+	require.Error(t, err)
+	require.NoFileExists(t, sortedPath)
+	require.NoError(t, lk.Unlock())
+	err = lockingClient.SortFile(ctx, srcPath, "locked-sorted.json", SortOptions{Keys: []string{"entity_num"}})
+	require.NoError(t, err)
+
+	movedPath := filepath.Join(testDir, "copies", "moved.json")
+	lk, err = lockingClient.LockFile(ctx, movedPath)
+	require.NoError(t, err)
+	_, err = lockingClient.Move(ctx, destPath, movedPath, MoveOptions{})
+	require.Error(t, err)
+	require.FileExists(t, destPath)
+	require.NoError(t, lk.Unlock())
+	_, err = lockingClient.Move(ctx, destPath, movedPath, MoveOptions{})
+	require.NoError(t, err)
+
+	lk, err = lockingClient.LockFile(ctx, movedPath)
+	require.NoError(t, err)
+	_, err = lockingClient.Delete(ctx, movedPath, DeleteOptions{})
+	require.Error(t, err)
+	require.FileExists(t, movedPath)
+	require.NoError(t, lk.Unlock())
+	_, err = lockingClient.Delete(ctx, movedPath, DeleteOptions{})
+	require.NoError(t, err)
+	require.NoFileExists(t, movedPath)
+}
+
+func testWriteShards(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	send := func(records []JSONMapper, pause time.Duration) chan JSONMapper {
+		recCh := make(chan JSONMapper)
+		go func() {
+			defer close(recCh)
+			for i, r := range records {
+				if i == len(records)-1 {
+					time.Sleep(pause)
+				}
+				recCh <- r
+			}
+		}()
+		return recCh
+	}
+	records := []JSONMapper{}
+	for i := 1; i <= 10; i++ {
+		records = append(records, JSONMapper{"entity_num": fmt.Sprintf("C%04d", i), "state": "CA"})
+	}
+
+	// rolls by record count, each shard a valid json array
+	shards, err := client.WriteShards(ctx, filepath.Join(testDir, "shards", "filings.json"), ShardOptions{MaxRecords: 4}, send(records, 0))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(shards))
+	require.Equal(t, filepath.Join(testDir, "shards", "filings-00001.json"), shards[0].Path)
+	require.Equal(t, []int{4, 4, 2}, []int{shards[0].Records, shards[1].Records, shards[2].Records})
+	for _, shard := range shards {
+		info, err := os.Stat(shard.Path)
+		require.NoError(t, err)
+		require.Equal(t, info.Size(), shard.Bytes)
+		content, err := os.ReadFile(shard.Path)
+		require.NoError(t, err)
+		var arr []JSONMapper
+		require.NoError(t, json.Unmarshal(content, &arr))
+		require.Equal(t, shard.Records, len(arr))
+	}
+
+	// rolls by size, each csv shard with headers
+	shards, err = client.WriteShards(ctx, filepath.Join(testDir, "shards", "filings.csv"), ShardOptions{MaxBytes: 40}, send(records, 0))
+	require.NoError(t, err)
+	// 17 bytes header & 9 bytes rows, 3 rows per shard
+	require.Equal(t, 4, len(shards))
+	total := 0
+	for _, shard := range shards {
+		content, err := os.ReadFile(shard.Path)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+		require.Equal(t, "entity_num|state", lines[0])
+		require.Equal(t, shard.Records, len(lines)-1)
+		total += shard.Records
+	}
+	require.Equal(t, 10, total)
+
+	// rolls by time
+	shards, err = client.WriteShards(ctx, filepath.Join(testDir, "shards", "filings.ndjson"), ShardOptions{MaxAge: 50 * time.Millisecond}, send(records[:3], 150*time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(shards))
+	require.Equal(t, []int{2, 1}, []int{shards[0].Records, shards[1].Records})
+
+	shards, err = client.WriteShards(ctx, filepath.Join(testDir, "shards", "empty.json"), ShardOptions{MaxRecords: 4}, send(nil, 0))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(shards))
+
+	_, err = client.WriteShards(ctx, filepath.Join(testDir, "shards", "filings.xml"), ShardOptions{}, send(nil, 0))
+	require.Error(t, err)
+}
+
+func testWritePartitions(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	send := func(records []JSONMapper) chan JSONMapper {
+		recCh := make(chan JSONMapper)
+		go func() {
+			defer close(recCh)
+			for _, r := range records {
+				recCh <- r
+			}
+		}()
+		return recCh
+	}
+	records := []JSONMapper{
+		{"EntityNum": "C0001", "Jurisdiction": "CA", "EntityStatus": "Active"},
+		{"EntityNum": "C0002", "Jurisdiction": "NV", "EntityStatus": "Active"},
+		{"EntityNum": "C0003", "Jurisdiction": "NY", "EntityStatus": "Dissolved"},
+		{"EntityNum": "C0004", "Jurisdiction": "CA", "EntityStatus": "Active"},
+		{"EntityNum": "C0005", "Jurisdiction": "CA", "EntityStatus": "Active"},
+		{"EntityNum": "C0006", "Jurisdiction": "", "EntityStatus": "Active/Pending"},
+	}
+
+	// least recently written partition is closed & continued in next part
+	dir := filepath.Join(testDir, "partitions")
+	parts, err := client.WritePartitions(ctx, dir, PartitionOptions{
+		Keys: []string{"jurisdiction", "entity_status"},
+		KeyFunc: func(r JSONMapper) ([]string, error) {
+			return []string{r["Jurisdiction"].(string), r["EntityStatus"].(string)}, nil
+		},
+		MaxOpen: 2,
+	}, send(records))
+	require.NoError(t, err)
+
+	paths := []string{}
+	count := 0
+	for _, part := range parts {
+		rel, err := filepath.Rel(dir, part.Path)
+		require.NoError(t, err)
+		paths = append(paths, filepath.ToSlash(rel))
+		content, err := os.ReadFile(part.Path)
+		require.NoError(t, err)
+		var arr []JSONMapper
+		require.NoError(t, json.Unmarshal(content, &arr))
+		require.Equal(t, part.Records, len(arr))
+		count += part.Records
+	}
+	require.Equal(t, 6, count)
+	require.Equal(t, []string{
+		"jurisdiction=CA/entity_status=Active/part-0.json",
+		"jurisdiction=CA/entity_status=Active/part-1.json",
+		"jurisdiction=NV/entity_status=Active/part-0.json",
+		"jurisdiction=NY/entity_status=Dissolved/part-0.json",
+		"jurisdiction=__HIVE_DEFAULT_PARTITION__/entity_status=Active%2FPending/part-0.json",
+	}, paths)
+
+	// fields matched case insensitively, csv parts with headers
+	parts, err = client.WritePartitions(ctx, filepath.Join(testDir, "by-state"), PartitionOptions{
+		Keys:   []string{"jurisdiction"},
+		Format: FORMAT_CSV,
+	}, send(records[:4]))
+	require.NoError(t, err)
+	require.Equal(t, 3, len(parts))
+	content, err := os.ReadFile(parts[0].Path)
+	require.NoError(t, err)
+	require.Equal(t, "EntityNum|EntityStatus|Jurisdiction\nC0001|Active|CA\nC0004|Active|CA\n", string(content))
+
+	_, err = client.WritePartitions(ctx, dir, PartitionOptions{}, send(nil))
+	require.Error(t, err)
+}
+
+func testReadFiles(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := filepath.Join(testDir, "parts")
+	for path, content := range map[string]string{
+		"part-0.json":     `[{"entity_num": "C0001"}, {"entity_num": "C0002"}]`,
+		"part-1.ndjson":   "{\"entity_num\": \"C0003\"}\n",
+		"part-2.csv":      "entity_num|state\nC0004|CA\nC0005|NV\n",
+		"readme.md":       "parts",
+		"nested/part.csv": "entity_num\nC0006\n",
+	} {
+		fPath := filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fPath), os.ModePerm))
+		require.NoError(t, os.WriteFile(fPath, []byte(content), os.ModePerm))
+	}
+	collect := func(frs <-chan FileResponse) []string {
+		got := []string{}
+		for fr := range frs {
+			require.NoError(t, fr.Error)
+			got = append(got, fmt.Sprintf("%s:%d:%s", filepath.Base(fr.Source), fr.Position, fr.Result["entity_num"]))
+		}
+		return got
+	}
+
+	// sequential reads in path order
+	frs, files, err := client.ReadFiles(ctx, dir, ReadFilesOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(files))
+	require.Equal(t, []string{
+		"part-0.json:1:C0001",
+		"part-0.json:2:C0002",
+		"part-1.ndjson:1:C0003",
+		"part-2.csv:1:C0004",
+		"part-2.csv:2:C0005",
+	}, collect(frs))
+
+	frs, files, err = client.ReadFiles(ctx, dir, ReadFilesOptions{Recursive: true, List: ListOptions{Formats: []string{FORMAT_CSV}}})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(files))
+	require.Equal(t, []string{"part.csv:1:C0006", "part-2.csv:1:C0004", "part-2.csv:2:C0005"}, collect(frs))
+
+	// concurrent reads of glob matches
+	frs, files, err = client.ReadFiles(ctx, filepath.Join(dir, "**", "*.csv"), ReadFilesOptions{Concurrency: 2})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(files))
+	got := collect(frs)
+	sort.Strings(got)
+	require.Equal(t, []string{"part-2.csv:1:C0004", "part-2.csv:2:C0005", "part.csv:1:C0006"}, got)
+
+	// bad file is reported & skipped
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "part-3.json"), []byte(`{"entity_num": "C0007"}`), os.ModePerm))
+	frs, _, err = client.ReadFiles(ctx, filepath.Join(dir, "part-*"), ReadFilesOptions{})
+	require.NoError(t, err)
+	records, errs := 0, 0
+	for fr := range frs {
+		if fr.Error != nil {
+			require.Equal(t, filepath.Join(dir, "part-3.json"), fr.Source)
+			errs++
+			continue
+		}
+		records++
+	}
+	require.Equal(t, 5, records)
+	require.Equal(t, 1, errs)
+}
+
+func testProcess(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fPath, err := createJSONFile(testDir, "stores")
+	require.NoError(t, err)
+
+	resultStream, err := client.ReadFileArray(ctx, cancel, fPath)
+	require.NoError(t, err)
+	processed, err := client.Process(ctx, resultStream, ProcessConfig{Workers: 2, Ordered: true}, func(ctx context.Context, r JSONMapper) (JSONMapper, error) {
+		if r["store_id"].(float64) > 5 {
+			return nil, fmt.Errorf("store %v out of range", r["store_id"])
+		}
+		r["name"] = strings.ToUpper(r["name"].(string))
+		return r, nil
+	})
+	require.NoError(t, err)
+
+	// processed results piped into write through write stage
+	reqStream := make(chan JSONMapper)
+	errCh := make(chan error)
+	pReqStream, err := client.ProcessWriteStream(ctx, reqStream, ProcessConfig{Workers: 2}, func(ctx context.Context, r JSONMapper) (JSONMapper, error) {
+		r["processed"] = true
+		return r, nil
+	}, errCh)
+	require.NoError(t, err)
+	respStream := client.WriteFile(ctx, cancel, "stores-processed.json", pReqStream)
+
+	failed := []int{}
+	go func() {
+		defer close(reqStream)
+		for r := range processed {
+			var re process.RecordError
+			if errors.As(r.Error, &re) {
+				failed = append(failed, re.Index)
+				continue
+			}
+			reqStream <- r.Result
+		}
+	}()
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+	for resp := range respStream {
+		require.NoError(t, resp.Error)
+	}
+	require.Equal(t, []int{1, 2}, failed)
+
+	content, err := os.ReadFile(filepath.Join("data", "stores-processed.json"))
+	require.NoError(t, err)
+	var stores []JSONMapper
+	require.NoError(t, json.Unmarshal(content, &stores))
+	require.Equal(t, 1, len(stores))
+	require.Equal(t, true, stores[0]["processed"])
+	require.Equal(t, "PLAZA HOLLYWOOD", stores[0]["name"])
+}
+
+func testMetrics(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	prom := metrics.NewPrometheus(nil)
+	appLogger := logger.NewTestAppLogger(TEST_DIR)
+	metricsClient, err := NewLocalStorageClientWithConfig(Config{Metrics: prom}, appLogger)
+	require.NoError(t, err)
+
+	reqStream := make(chan JSONMapper)
+	respStream := metricsClient.WriteFile(ctx, cancel, "metrics.json", reqStream)
+	go func() {
+		defer close(reqStream)
+		for _, id := range []string{"C0001", "C0006", "C0008"} {
+			reqStream <- JSONMapper{"entity_num": id}
+		}
+	}()
+	for resp := range respStream {
+		require.NoError(t, resp.Error)
+	}
+	fPath := filepath.Join("data", "metrics.json")
+	info, err := os.Stat(fPath)
+	require.NoError(t, err)
+
+	resultStream, err := metricsClient.ReadFileArray(ctx, cancel, fPath)
+	require.NoError(t, err)
+	for r := range resultStream {
+		require.NoError(t, r.Error)
+	}
+
+	// cancelled record reads end their streams
+	readCtx, readCancel := context.WithCancel(ctx)
+	rs, _, err := metricsClient.ReadRecords(readCtx, fPath, CSVConfig{})
+	require.NoError(t, err)
+	<-rs
+	readCancel()
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for range rs {
+		}
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "cancelled read stream not closed")
+	}
+
+	_, err = metricsClient.Copy(filepath.Join(testDir, "missing.json"), filepath.Join(testDir, "copy.json"))
+	require.Error(t, err)
+
+	rec := httptest.NewRecorder()
+	prom.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`localstorage_records_written_total{method="WriteFile"} 3`,
+		fmt.Sprintf(`localstorage_bytes_written_total{method="WriteFile"} %d`, info.Size()),
+		`localstorage_records_read_total{method="ReadFileArray"} 3`,
+		`localstorage_decode_errors_total{method="ReadFileArray"} 0`,
+		fmt.Sprintf(`localstorage_bytes_read_total{method="ReadFileArray"} %d`, info.Size()),
+		`localstorage_operations_total{method="WriteFile",result="ok"} 1`,
+		`localstorage_operations_total{method="ReadFileArray",result="ok"} 1`,
+		`localstorage_operations_total{method="Copy",result="error"} 1`,
+		`localstorage_streams_in_flight{method="WriteFile"} 0`,
+		`localstorage_streams_in_flight{method="ReadFileArray"} 0`,
+		`localstorage_streams_in_flight{method="ReadRecords"} 0`,
+		`localstorage_operation_duration_seconds_count{method="ReadFileArray"} 1`,
+	} {
+		require.Contains(t, body, line+"\n")
+	}
+}
+
+func testTracing(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recorder := trace.NewRecorder()
+	appLogger := logger.NewTestAppLogger(TEST_DIR)
+	tracingClient, err := NewLocalStorageClientWithConfig(Config{Tracer: recorder}, appLogger)
+	require.NoError(t, err)
+
+	// spans are children of caller's span
+	parentCtx, parent := recorder.Start(ctx, "import")
+	reqStream := make(chan JSONMapper)
+	respStream := tracingClient.WriteFile(parentCtx, cancel, "traced.json", reqStream)
+	go func() {
+		defer close(reqStream)
+		for _, id := range []string{"C0001", "C0006"} {
+			reqStream <- JSONMapper{"entity_num": id}
+		}
+	}()
+	for resp := range respStream {
+		require.NoError(t, resp.Error)
+	}
+	parent.End()
+
+	fPath := filepath.Join("data", "traced.json")
+	info, err := os.Stat(fPath)
+	require.NoError(t, err)
+
+	resultStream, err := tracingClient.ReadFileArray(ctx, cancel, fPath)
+	require.NoError(t, err)
+	for r := range resultStream {
+		require.NoError(t, r.Error)
+	}
+
+	copyPath := filepath.Join(testDir, "traced-copy.json")
+	_, err = tracingClient.Copy(fPath, copyPath)
+	require.NoError(t, err)
+
+	_, err = tracingClient.ReadFileArray(ctx, cancel, filepath.Join(testDir, "missing.json"))
+	require.Error(t, err)
+
+	spans := recorder.Spans()
+	require.Equal(t, 5, len(spans))
+
+	write := spans[0]
+	require.Equal(t, "localstorage.WriteFile", write.Name)
+	require.Equal(t, spans[1].ID, write.ParentID)
+	require.Equal(t, fPath, write.Attributes[trace.FILE_PATH])
+	require.Equal(t, int64(2), write.Attributes[trace.RECORD_COUNT])
+	require.Equal(t, info.Size(), write.Attributes[trace.FILE_SIZE])
+	require.Empty(t, write.Errors)
+
+	read := spans[2]
+	require.Equal(t, "localstorage.ReadFileArray", read.Name)
+	require.Equal(t, 0, read.ParentID)
+	require.Equal(t, info.Size(), read.Attributes[trace.FILE_SIZE])
+	require.Equal(t, int64(2), read.Attributes[trace.RECORD_COUNT])
+	require.Empty(t, read.Errors)
+
+	cp := spans[3]
+	require.Equal(t, "localstorage.Copy", cp.Name)
+	require.Equal(t, copyPath, cp.Attributes[trace.DEST_PATH])
+	require.Equal(t, info.Size(), cp.Attributes[trace.IO_BYTES])
+
+	missing := spans[4]
+	require.Equal(t, "localstorage.ReadFileArray", missing.Name)
+	require.Equal(t, 1, len(missing.Errors))
+}
+
+func testAudit(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logPath := filepath.Join(testDir, "audit", "audit.jsonl")
+	auditKey := []byte("audit-key")
+	appLogger := logger.NewTestAppLogger(TEST_DIR)
+	auditClient, err := NewLocalStorageClientWithConfig(Config{
+		Audit: AuditConfig{Path: logPath, Principal: "system", Key: auditKey},
+	}, appLogger)
+	require.NoError(t, err)
+
+	aliceCtx := WithPrincipal(ctx, "alice")
+	reqStream := make(chan JSONMapper)
+	respStream := auditClient.WriteFile(aliceCtx, cancel, "audited.json", reqStream)
+	go func() {
+		defer close(reqStream)
+		reqStream <- JSONMapper{"entity_num": "C0001"}
+	}()
+	for resp := range respStream {
+		require.NoError(t, resp.Error)
+	}
+	fPath := filepath.Join("data", "audited.json")
+	info, err := os.Stat(fPath)
+	require.NoError(t, err)
+
+	copyPath := filepath.Join(testDir, "audited-copy.json")
+	_, err = auditClient.Copy(fPath, copyPath)
+	require.NoError(t, err)
+
+	// dry runs aren't recorded
+	_, err = auditClient.Delete(aliceCtx, copyPath, DeleteOptions{DryRun: true})
+	require.NoError(t, err)
+	_, err = auditClient.Delete(aliceCtx, copyPath, DeleteOptions{})
+	require.NoError(t, err)
+	_, err = auditClient.Delete(aliceCtx, copyPath, DeleteOptions{})
+	require.Error(t, err)
+
+	head, err := VerifyAuditLog(logPath, auditKey)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), head.Seq)
+	require.Equal(t, auditClient.AuditHead(), head)
+	require.Equal(t, AuditHead{}, client.AuditHead())
+
+	logContent, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	entries := []AuditEntry{}
+	for _, line := range strings.Split(strings.TrimSpace(string(logContent)), "\n") {
+		var e AuditEntry
+		require.NoError(t, json.Unmarshal([]byte(line), &e))
+		entries = append(entries, e)
+	}
+
+	write := entries[0]
+	require.Equal(t, "WriteFile", write.Op)
+	require.Equal(t, fPath, write.Path)
+	require.Equal(t, "alice", write.Principal)
+	require.Equal(t, "ok", write.Outcome)
+	require.Equal(t, info.Size(), write.Bytes)
+	content, err := os.ReadFile(fPath)
+	require.NoError(t, err)
+	sum := sha256.Sum256(content)
+	require.Equal(t, hex.EncodeToString(sum[:]), write.Checksum)
+
+	cp := entries[1]
+	require.Equal(t, "Copy", cp.Op)
+	require.Equal(t, copyPath, cp.Dest)
+	require.Equal(t, "system", cp.Principal)
+	require.Equal(t, write.Checksum, cp.Checksum)
+
+	del := entries[2]
+	require.Equal(t, "Delete", del.Op)
+	require.Equal(t, info.Size(), del.Bytes)
+	require.Empty(t, del.Checksum)
+
+	failed := entries[3]
+	require.Equal(t, "Delete", failed.Op)
+	require.Equal(t, "error", failed.Outcome)
+	require.NotEmpty(t, failed.Error)
+	require.Equal(t, del.Hash, failed.PrevHash)
+
+	// tampered log fails verification
+	tampered := strings.Replace(string(logContent), `"principal":"alice"`, `"principal":"mallory"`, 1)
+	require.NoError(t, os.WriteFile(logPath, []byte(tampered), os.ModePerm))
+	_, err = VerifyAuditLog(logPath, auditKey)
+	require.Error(t, err)
+
+	// entries deleted from log's end are detected against kept head
+	lines := strings.SplitAfter(string(logContent), "\n")
+	require.NoError(t, os.WriteFile(logPath, []byte(strings.Join(lines[:3], "")), os.ModePerm))
+	_, err = VerifyAuditLog(logPath, auditKey)
+	require.NoError(t, err)
+	_, err = VerifyAuditHead(logPath, auditKey, head)
+	require.Error(t, err)
+
+	// audit failures are logged, or fail operations if configured so
+	strictPath := filepath.Join(testDir, "audit", "strict.jsonl")
+	strictClient, err := NewLocalStorageClientWithConfig(Config{
+		Audit: AuditConfig{Path: strictPath, Key: auditKey, FailOnError: true},
+	}, appLogger)
+	require.NoError(t, err)
+	logClient, err := NewLocalStorageClientWithConfig(Config{
+		Audit: AuditConfig{Path: strictPath, Key: auditKey},
+	}, appLogger)
+	require.NoError(t, err)
+	f, err := os.OpenFile(strictPath, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("{\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = logClient.Copy(fPath, copyPath)
+	require.NoError(t, err)
+	_, err = strictClient.Copy(fPath, copyPath)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "recording audit entry of Copy")
+	require.FileExists(t, copyPath)
+	_, err = strictClient.Delete(ctx, copyPath, DeleteOptions{})
+	require.Error(t, err)
+	require.NoFileExists(t, copyPath)
+
+	reqStream = make(chan JSONMapper)
+	respStream = strictClient.WriteFile(ctx, cancel, "audited.json", reqStream)
+	go func() {
+		defer close(reqStream)
+		reqStream <- JSONMapper{"entity_num": "C0001"}
+	}()
+	errs := []error{}
+	for resp := range respStream {
+		if resp.Error != nil {
+			errs = append(errs, resp.Error)
+		}
+	}
+	require.Equal(t, 1, len(errs))
+	require.Contains(t, errs[0].Error(), "recording audit entry of WriteFile")
+}
+
+func testEncryption(t *testing.T, client LocalStorage, testDir string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	appLogger := logger.NewTestAppLogger(TEST_DIR)
+	encClient, err := NewLocalStorageClientWithConfig(Config{
+		Encryption: EncryptionConfig{
+			Keys:      StaticKeys{ID: "k1", Keys: map[string][]byte{"k1": key}},
+			ChunkSize: 32,
+		},
+	}, appLogger)
+	require.NoError(t, err)
+
+	principals := []JSONMapper{
+		{"entity_num": "C0001", "FirstName": "Mei", "LastName": "Wong", "Address": "1 Harbour Road"},
+		{"entity_num": "C0006", "FirstName": "Tak", "LastName": "Lee", "Address": "8 Finance Street"},
+	}
+	reqStream := make(chan JSONMapper)
+	respStream := encClient.WriteFile(ctx, cancel, "principals-enc.json", reqStream)
+	go func() {
+		defer close(reqStream)
+		for _, p := range principals {
+			reqStream <- p
+		}
+	}()
+	for resp := range respStream {
+		require.NoError(t, resp.Error)
+	}
+
+	fPath := filepath.Join("data", "principals-enc.json")
+	content, err := os.ReadFile(fPath)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(content), "LSENC"))
+	require.NotContains(t, string(content), "Wong")
+	require.NotContains(t, string(content), "Harbour")
+
+	readAll := func(c LocalStorage, path string) ([]JSONMapper, error) {
+		resultStream, err := c.ReadFileArray(ctx, cancel, path)
+		if err != nil {
+			return nil, err
+		}
+		results := []JSONMapper{}
+		for r := range resultStream {
+			require.NoError(t, r.Error)
+			results = append(results, r.Result)
+		}
+		return results, nil
+	}
+	results, err := readAll(encClient, fPath)
+	require.NoError(t, err)
+	require.Equal(t, principals, results)
+
+	// encrypted files can't be read without keys
+	_, err = readAll(client, fPath)
+	require.Error(t, err)
+
+	// plain files are still read & copied encrypted
+	csvPath := filepath.Join(testDir, "principals.csv")
+	csvText := "entity_num|FirstName|LastName|Address\nC0001|Mei|Wong|1 Harbour Road\nC0006|Tak|Lee|8 Finance Street\n"
+	err = os.WriteFile(csvPath, []byte(csvText), os.ModePerm)
+	require.NoError(t, err)
+	encCSVPath := filepath.Join(testDir, "principals-enc.csv")
+	// copies count written ciphertext bytes
+	n, err := encClient.Copy(csvPath, encCSVPath)
+	require.NoError(t, err)
+	info, err := os.Stat(encCSVPath)
+	require.NoError(t, err)
+	require.Equal(t, info.Size(), n)
+	require.Greater(t, n, int64(len(csvText)))
+	bufCSVPath := filepath.Join(testDir, "principals-buf.csv")
+	n, err = encClient.CopyBuf(csvPath, bufCSVPath)
+	require.NoError(t, err)
+	info, err = os.Stat(bufCSVPath)
+	require.NoError(t, err)
+	require.Equal(t, info.Size(), n)
+
+	for _, path := range []string{csvPath, encCSVPath, bufCSVPath} {
+		if path != csvPath {
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NotContains(t, string(content), "Wong")
+		}
+		resCh, errCh := make(chan []string), make(chan error)
+		err = encClient.ReadCSVFile(ctx, path, resCh, errCh)
+		require.NoError(t, err)
+		rows := [][]string{}
+		for resCh != nil || errCh != nil {
+			select {
+			case r, ok := <-resCh:
+				if !ok {
+					resCh = nil
+					continue
+				}
+				rows = append(rows, r)
+			case err, ok := <-errCh:
+				if !ok {
+					errCh = nil
+					continue
+				}
+				require.NoError(t, err)
+			}
+		}
+		require.Equal(t, 3, len(rows))
+		require.Equal(t, []string{"C0001", "Mei", "Wong", "1 Harbour Road"}, rows[1])
+	}
+
+	// truncated encrypted files fail reads, which end
+	var sb strings.Builder
+	sb.WriteString("entity_num|LastName\n")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&sb, "C%04d|Wong\n", i)
+	}
+	longPath := filepath.Join(testDir, "long.csv")
+	require.NoError(t, os.WriteFile(longPath, []byte(sb.String()), os.ModePerm))
+	truncPath := filepath.Join(testDir, "long-enc.csv")
+	_, err = encClient.Copy(longPath, truncPath)
+	require.NoError(t, err)
+	info, err = os.Stat(truncPath)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(truncPath, info.Size()/2))
+
+	readCtx, readCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer readCancel()
+	resCh, errCh := make(chan []string), make(chan error)
+	err = encClient.ReadCSVFile(readCtx, truncPath, resCh, errCh)
+	require.NoError(t, err)
+	rows, errs := 0, []error{}
+	for resCh != nil || errCh != nil {
+		select {
+		case _, ok := <-resCh:
+			if !ok {
+				resCh = nil
+				continue
+			}
+			rows++
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	require.NoError(t, readCtx.Err())
+	require.Less(t, rows, 2001)
+	require.Equal(t, 1, len(errs))
+
+	// encrypted files are copied as is
+	copyPath := filepath.Join(testDir, "principals-copy.json")
+	_, err = encClient.Copy(fPath, copyPath)
+	require.NoError(t, err)
+	copied, err := os.ReadFile(copyPath)
+	require.NoError(t, err)
+	require.Equal(t, content, copied)
+
+	// record reads decrypt, record writes encrypt
+	requireEncrypted := func(path string) {
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(content), "LSENC"), path)
+		require.NotContains(t, string(content), "Wong", path)
+	}
+	readRecords := func(path string) []JSONMapper {
+		rs, _, err := encClient.ReadRecords(ctx, path, CSVConfig{})
+		require.NoError(t, err)
+		results := []JSONMapper{}
+		for r := range rs {
+			require.NoError(t, r.Error)
+			results = append(results, r.Result)
+		}
+		return results
+	}
+	require.Equal(t, principals, readRecords(fPath))
+	require.Equal(t, 2, len(readRecords(encCSVPath)))
+
+	err = encClient.SortFile(ctx, encCSVPath, "principals-sorted.json", SortOptions{Keys: []string{"entity_num"}})
+	require.NoError(t, err)
+	sortedPath := filepath.Join("data", "principals-sorted.json")
+	requireEncrypted(sortedPath)
+	sorted := readRecords(sortedPath)
+	require.Equal(t, 2, len(sorted))
+	require.Equal(t, "Wong", sorted[0]["LastName"])
+
+	// spilled sort & dedupe runs are encrypted, & decrypted for merging
+	runsDir := filepath.Join(testDir, "runs")
+	require.NoError(t, os.MkdirAll(runsDir, os.ModePerm))
+	err = encClient.SortFile(ctx, encCSVPath, "principals-spilled.json", SortOptions{Keys: []string{"LastName"}, MemoryBudget: 1, TempDir: runsDir})
+	require.NoError(t, err)
+	spilledPath := filepath.Join("data", "principals-spilled.json")
+	requireEncrypted(spilledPath)
+	sorted = readRecords(spilledPath)
+	require.Equal(t, 2, len(sorted))
+	require.Equal(t, "Lee", sorted[0]["LastName"])
+	err = encClient.DedupeFile(ctx, encCSVPath, "principals-deduped.json", DedupeOptions{Fields: []string{"LastName"}, MemoryBudget: 1, TempDir: runsDir})
+	require.NoError(t, err)
+	dedupedPath := filepath.Join("data", "principals-deduped.json")
+	requireEncrypted(dedupedPath)
+	require.Equal(t, 2, len(readRecords(dedupedPath)))
+	runs, err := os.ReadDir(runsDir)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(runs))
+
+	recCh := make(chan JSONMapper)
+	go func() {
+		defer close(recCh)
+		for _, p := range principals {
+			recCh <- p
+		}
+	}()
+	shards, err := encClient.WriteShards(ctx, filepath.Join(testDir, "shards", "principals.csv"), ShardOptions{MaxRecords: 1}, recCh)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(shards))
+	for _, sh := range shards {
+		requireEncrypted(sh.Path)
+		require.Equal(t, 1, len(readRecords(sh.Path)))
+	}
+
+	jsonResCh, jsonErrCh := make(chan JSONMapper), make(chan error)
+	err = encClient.ReadJSONFile(ctx, fPath, jsonResCh, jsonErrCh)
+	require.NoError(t, err)
+	results = []JSONMapper{}
+	for jsonResCh != nil || jsonErrCh != nil {
+		select {
+		case r, ok := <-jsonResCh:
+			if !ok {
+				jsonResCh = nil
+				continue
+			}
+			results = append(results, r)
+		case err, ok := <-jsonErrCh:
+			if !ok {
+				jsonErrCh = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, principals, results)
+
+	kvStream := make(chan KeyValue)
+	kvResps := encClient.WriteJSONObject(ctx, cancel, "principal-enc.json", kvStream)
+	go func() {
+		defer close(kvStream)
+		kvStream <- KeyValue{Key: "LastName", Value: "Wong"}
+	}()
+	for resp := range kvResps {
+		require.NoError(t, resp.Error)
+	}
+	objPath := filepath.Join("data", "principal-enc.json")
+	requireEncrypted(objPath)
+	kvCh, kvErrCh := make(chan KeyValue), make(chan error)
+	err = encClient.ReadJSONObject(ctx, objPath, kvCh, kvErrCh)
+	require.NoError(t, err)
+	kvs := []KeyValue{}
+	for kvCh != nil || kvErrCh != nil {
+		select {
+		case kv, ok := <-kvCh:
+			if !ok {
+				kvCh = nil
+				continue
+			}
+			kvs = append(kvs, kv)
+		case err, ok := <-kvErrCh:
+			if !ok {
+				kvErrCh = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, []KeyValue{{Key: "LastName", Value: "Wong"}}, kvs)
+
+	// encrypted files can't be indexed
+	_, err = encClient.OpenIndex(encCSVPath, "entity_num")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "can't be indexed")
+}
@@ -0,0 +1,99 @@
+package localstorage
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/lock"
+)
+
+// DEFAULT_COPY_LOCK_TIMEOUT limits lock waits of copies, if lock config sets no timeout
+const DEFAULT_COPY_LOCK_TIMEOUT time.Duration = time.Minute
+
+// LockFile takes an exclusive lock on path, as per client lock config,
+// for coordinating writes with other workers, caller unlocks
+func (lc *localStorageClient) LockFile(ctx context.Context, path string) (*lock.Lock, error) {
+	return lc.locker.Lock(ctx, path)
+}
+
+// RLockFile takes a shared lock on existing path, as per client lock config, caller unlocks
+func (lc *localStorageClient) RLockFile(ctx context.Context, path string) (*lock.Lock, error) {
+	return lc.locker.RLock(ctx, path)
+}
+
+// lockRead takes shared lock on path if reads are locked, nil lock otherwise
+func (lc *localStorageClient) lockRead(ctx context.Context, path string) (*lock.Lock, error) {
+	if !lc.config.LockReads {
+		return nil, nil
+	}
+	return lc.locker.RLock(ctx, path)
+}
+
+// lockCopy takes exclusive lock on destination & shared lock on source if reads are locked,
+// in path order, so copies in opposite directions don't deadlock, returns func releasing them,
+// copies take no context, their locks wait up to lock config timeout, or DEFAULT_COPY_LOCK_TIMEOUT
+func (lc *localStorageClient) lockCopy(srcPath, destPath string) (func(), error) {
+	timeout := lc.config.Lock.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_COPY_LOCK_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return lc.lockPair(ctx, srcPath, lc.lockRead, destPath)
+}
+
+// lockMove takes exclusive locks on source & destination, in path order, returns func releasing them
+func (lc *localStorageClient) lockMove(ctx context.Context, srcPath, destPath string) (func(), error) {
+	return lc.lockPair(ctx, srcPath, lc.locker.Lock, destPath)
+}
+
+// lockPair takes source lock with lockSrc & exclusive lock on destination,
+// in path order, so opposite operations don't deadlock, returns func releasing them
+func (lc *localStorageClient) lockPair(ctx context.Context, srcPath string, lockSrc func(context.Context, string) (*lock.Lock, error), destPath string) (func(), error) {
+	var src, dest *lock.Lock
+	release := func() {
+		lc.unlock(dest)
+		lc.unlock(src)
+	}
+	steps := []func() error{
+		func() (err error) {
+			src, err = lockSrc(ctx, srcPath)
+			return err
+		},
+		func() (err error) {
+			dest, err = lc.locker.Lock(ctx, destPath)
+			return err
+		},
+	}
+	if lockOrder(destPath) < lockOrder(srcPath) {
+		steps[0], steps[1] = steps[1], steps[0]
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// lockOrder returns path's absolute form, ordering lock acquisition
+func lockOrder(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// unlock releases lock, if any, logging errors
+func (lc *localStorageClient) unlock(lk *lock.Lock) {
+	if lk == nil {
+		return
+	}
+	if err := lk.Unlock(); err != nil {
+		lc.logger.Error("error releasing lock", zap.String("path", lk.Path()), zap.Error(err))
+	}
+}
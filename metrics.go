@@ -0,0 +1,169 @@
+package localstorage
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/comfforts/localstorage/pkg/metrics"
+)
+
+type Metrics = metrics.Metrics
+
+// observe records method latency & result, deferred with a pointer to method's error result
+func (lc *localStorageClient) observe(method string, start time.Time, err *error) {
+	lc.metrics.ObserveLatency(method, time.Since(start), *err)
+}
+
+// observeSetup records method failure before its stream started, deferred with a pointer
+// to method's error result, started streams are observed once done
+func (lc *localStorageClient) observeSetup(method string, start time.Time, err *error) {
+	if *err != nil {
+		lc.metrics.ObserveLatency(method, time.Since(start), *err)
+	}
+}
+
+// observeShards records sharded write's bytes & records written, latency & result,
+// ends its in-flight stream, deferred with pointers to method's results
+func (lc *localStorageClient) observeShards(method string, start time.Time, shards *[]Shard, err *error) {
+	for _, s := range *shards {
+		lc.metrics.AddBytesWritten(method, s.Bytes)
+		lc.metrics.AddRecordsWritten(method, s.Records)
+	}
+	lc.metrics.StreamEnded(method)
+	lc.metrics.ObserveLatency(method, time.Since(start), *err)
+}
+
+func responseError(r ReadResponse) error {
+	return r.Error
+}
+
+// trackStream forwards method's response stream, tracking it as in-flight till it's closed,
+// then observes its latency & first response error
+func trackStream[T any](ctx context.Context, m Metrics, method string, in <-chan T, errOf func(T) error) <-chan T {
+	m.StreamStarted(method)
+	start := time.Now()
+
+	out := make(chan T)
+	go func() {
+		var first error
+		defer func() {
+			if first == nil {
+				first = ctx.Err()
+			}
+			m.StreamEnded(method)
+			m.ObserveLatency(method, time.Since(start), first)
+			close(out)
+		}()
+		for v := range in {
+			if err := errOf(v); err != nil && first == nil {
+				first = err
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- v:
+			}
+		}
+	}()
+	return out
+}
+
+// forward forwards filer results & errors to caller channels, tracking the stream,
+// counting records & decode errors & bytes read of file, closes caller channels once filer's are closed
+func forward[T any](ctx context.Context, m Metrics, method string, file io.Seeker, inRes chan T, inErr chan error, resCh chan T, errCh chan error) {
+	m.StreamStarted(method)
+	start := time.Now()
+	records, errs := 0, 0
+	var first error
+	defer func() {
+		m.AddRecordsRead(method, records)
+		m.AddDecodeErrors(method, errs)
+		m.AddBytesRead(method, offset(file))
+		if first == nil {
+			first = ctx.Err()
+		}
+		m.StreamEnded(method)
+		m.ObserveLatency(method, time.Since(start), first)
+		close(resCh)
+		close(errCh)
+	}()
+
+	for inRes != nil || inErr != nil {
+		select {
+		case r, ok := <-inRes:
+			if !ok {
+				inRes = nil
+				continue
+			}
+			records++
+			if ctx.Err() != nil {
+				// filer stops once context is done, drained till it closes
+				continue
+			}
+			select {
+			case <-ctx.Done():
+			case resCh <- r:
+			}
+		case err, ok := <-inErr:
+			if !ok {
+				inErr = nil
+				continue
+			}
+			errs++
+			if first == nil {
+				first = err
+			}
+			if ctx.Err() != nil {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+			case errCh <- err:
+			}
+		}
+	}
+}
+
+// countRecords forwards write requests, counting them in n, closes returned chan on done
+func countRecords[T any](ctx context.Context, in chan T, n *int64) chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for r := range in {
+			atomic.AddInt64(n, 1)
+			select {
+			case <-ctx.Done():
+				return
+			case out <- r:
+			}
+		}
+	}()
+	return out
+}
+
+// byteCounter counts bytes written into w
+type byteCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// offset returns current offset of file, bytes read or written so far
+func offset(s io.Seeker) int64 {
+	n, err := s.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+func writeError(r WriteResponse) error {
+	return r.Error
+}
@@ -0,0 +1,223 @@
+package localstorage
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/comfforts/errors"
+)
+
+const (
+	// DEFAULT_MAX_OPEN_PARTITIONS bounds open partition files
+	DEFAULT_MAX_OPEN_PARTITIONS = 64
+	// DEFAULT_PARTITION names partitions of missing or empty key values, as Hive does
+	DEFAULT_PARTITION = "__HIVE_DEFAULT_PARTITION__"
+	// PART_NAME_FORMAT names partition files, e.g. part-0.json
+	PART_NAME_FORMAT = "part-%d%s"
+)
+
+const ERROR_PARTITION_KEY string = "partition key %s"
+
+type PartitionOptions struct {
+	// Keys name partition directories, e.g. jurisdiction=CA/entity_status=Active/
+	Keys []string
+	// KeyFunc, if set, returns record's partition values in Keys order,
+	// defaults to values of record fields named by Keys, matched case insensitively if not found
+	KeyFunc func(r JSONMapper) ([]string, error)
+	// Format is FORMAT_JSON, FORMAT_NDJSON or FORMAT_CSV, defaults to json
+	Format string
+	// MaxOpen bounds open partition files, least recently written is closed first,
+	// & later records of its partition go to its next part file,
+	// defaults to DEFAULT_MAX_OPEN_PARTITIONS
+	MaxOpen int
+	// Headers are csv columns, defaults to sorted keys of first record
+	Headers []string
+}
+
+// partition is a partition's open part file
+type partition struct {
+	dir  string
+	part int
+	file *shardFile
+}
+
+// WritePartitions writes record stream into Hive style partition directories under dir,
+// e.g. dir/jurisdiction=CA/part-0.json, records are written whole,
+// returns written part files sorted by path,
+// on error or context done open part files are removed & completed ones returned
+func (lc *localStorageClient) WritePartitions(ctx context.Context, dir string, opts PartitionOptions, recCh chan JSONMapper) (shards []Shard, err error) {
+	lc.metrics.StreamStarted("WritePartitions")
+	defer lc.observeShards("WritePartitions", time.Now(), &shards, &err)
+	defer lc.recordShards(ctx, "WritePartitions", dir, &shards, &err)
+
+	if len(opts.Keys) == 0 {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	format := opts.Format
+	if format == "" {
+		format = FORMAT_JSON
+	}
+	var ext string
+	switch format {
+	case FORMAT_JSON:
+		ext = ".json"
+	case FORMAT_NDJSON:
+		ext = ".ndjson"
+	case FORMAT_CSV:
+		ext = ".csv"
+	default:
+		return nil, errors.NewAppError(ERROR_UNSUPPORTED_FORMAT, format)
+	}
+	maxOpen := opts.MaxOpen
+	if maxOpen <= 0 {
+		maxOpen = DEFAULT_MAX_OPEN_PARTITIONS
+	}
+	keyFunc := opts.KeyFunc
+	if keyFunc == nil {
+		keyFunc = func(r JSONMapper) ([]string, error) {
+			return partitionValues(opts.Keys, r), nil
+		}
+	}
+	headers := opts.Headers
+
+	shards = []Shard{}
+	partitions := map[string]*partition{}
+	// open partitions, most recently written first
+	open := list.New()
+	elems := map[string]*list.Element{}
+
+	closePart := func(p *partition) error {
+		shard, err := p.file.close()
+		p.file = nil
+		p.part++
+		if err != nil {
+			return err
+		}
+		shards = append(shards, shard)
+		return nil
+	}
+	finish := func(err error) ([]Shard, error) {
+		for e := open.Front(); e != nil; e = e.Next() {
+			p := e.Value.(*partition)
+			if err != nil {
+				p.file.abort()
+				continue
+			}
+			if cErr := closePart(p); cErr != nil {
+				err = cErr
+			}
+		}
+		sort.Slice(shards, func(i, j int) bool {
+			return shards[i].Path < shards[j].Path
+		})
+		return shards, err
+	}
+
+	for {
+		var r JSONMapper
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return finish(ctx.Err())
+		case r, ok = <-recCh:
+		}
+		if !ok {
+			return finish(nil)
+		}
+
+		values, err := keyFunc(r)
+		if err != nil {
+			return finish(err)
+		}
+		if len(values) != len(opts.Keys) {
+			return finish(errors.NewAppError(ERROR_PARTITION_KEY, fmt.Sprint(values)))
+		}
+		pDir := partitionDir(dir, opts.Keys, values)
+
+		p, found := partitions[pDir]
+		if !found {
+			p = &partition{dir: pDir}
+			partitions[pDir] = p
+		}
+		if p.file == nil {
+			if open.Len() >= maxOpen {
+				lru := open.Remove(open.Back()).(*partition)
+				delete(elems, lru.dir)
+				if err := closePart(lru); err != nil {
+					return finish(err)
+				}
+			}
+			if format == FORMAT_CSV && len(headers) == 0 {
+				headers = recordKeys(r)
+			}
+			path := filepath.Join(pDir, fmt.Sprintf(PART_NAME_FORMAT, p.part, ext))
+			if err := createDirectory(path); err != nil {
+				return finish(errors.WrapError(err, ERROR_CREATING_FILE, path))
+			}
+			f, err := lc.openShard(ctx, path, format, headers)
+			if err != nil {
+				return finish(err)
+			}
+			p.file = f
+			elems[pDir] = open.PushFront(p)
+		} else {
+			open.MoveToFront(elems[pDir])
+		}
+
+		if err := p.file.write(r); err != nil {
+			return finish(err)
+		}
+	}
+}
+
+// partitionValues returns values of record fields named by keys,
+// matching names case insensitively if not found
+func partitionValues(keys []string, r JSONMapper) []string {
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		v, ok := r[k]
+		if !ok {
+			for field, fv := range r {
+				if strings.EqualFold(field, k) {
+					v = fv
+					break
+				}
+			}
+		}
+		values[i] = toString(v)
+	}
+	return values
+}
+
+// partitionDir returns Hive style partition directory of given values
+func partitionDir(dir string, keys, values []string) string {
+	elems := make([]string, 0, len(keys)+1)
+	elems = append(elems, dir)
+	for i, k := range keys {
+		elems = append(elems, escapePartition(k)+"="+escapePartition(values[i]))
+	}
+	return filepath.Join(elems...)
+}
+
+// escapePartition percent encodes path & key/value separators & control characters,
+// as Hive does, empty values name the default partition
+func escapePartition(s string) string {
+	if s == "" {
+		return DEFAULT_PARTITION
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\[]^{}", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
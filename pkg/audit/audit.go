@@ -0,0 +1,393 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/lock"
+)
+
+const (
+	ERROR_OPENING_AUDIT_LOG string = "opening audit log %s"
+	ERROR_WRITING_AUDIT_LOG string = "writing audit log %s"
+	ERROR_READING_AUDIT_LOG string = "reading audit log %s"
+	ERROR_CHECKSUM_FILE     string = "checksumming %s"
+	ERROR_BROKEN_CHAIN      string = "audit log chain broken at line %d: %s"
+	ERROR_MISSING_KEY       string = "missing audit log key"
+	ERROR_HEAD_MISMATCH     string = "audit log %s doesn't reach head %d: %s"
+)
+
+const (
+	OUTCOME_OK    string = "ok"
+	OUTCOME_ERROR string = "error"
+	// GENESIS_HASH is previous hash of log's first entry
+	GENESIS_HASH string = "0000000000000000000000000000000000000000000000000000000000000000"
+	// MAX_ENTRY_SIZE limits size of a log line read while verifying
+	MAX_ENTRY_SIZE int = 1024 * 1024
+	// DEFAULT_LOCK_TIMEOUT limits wait for log's lock while appending
+	DEFAULT_LOCK_TIMEOUT time.Duration = 10 * time.Second
+)
+
+// Entry is an audit log line, hash is keyed hmac chaining entry's content to previous entry's hash
+type Entry struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Op        string    `json:"op"`
+	Path      string    `json:"path"`
+	Dest      string    `json:"dest,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	Checksum  string    `json:"checksum,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash"`
+}
+
+// hash returns hmac-sha256, keyed by key, of entry's json without its hash,
+// entries can't be edited & rechained without the key
+func (e Entry) hash(key []byte) (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Head is log's last entry's sequence & hash, kept outside the log, e.g. in a database,
+// it detects entries deleted from log's end, see VerifyHead
+type Head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+type Config struct {
+	// Path is audit log file path, appended to across client restarts
+	Path string
+	// Principal is recorded for operations without a principal in context
+	Principal string
+	// Key keys entry hashes, kept away from log's writers, e.g. in a secrets store
+	Key []byte
+	// Lock configures log's lock, taken while appending, lock timeout defaults to DEFAULT_LOCK_TIMEOUT
+	Lock lock.Config
+	// FailOnError fails client operations whose entry can't be appended, failures are only logged otherwise,
+	// the operation itself isn't undone
+	FailOnError bool
+}
+
+// Log appends hash chained entries to a json lines file, appends are locked,
+// logs of same path, in one or more processes, extend one chain
+type Log struct {
+	config Config
+	mu     sync.Mutex
+	locker *lock.Locker
+	head   Head
+	// size is log's size after last known entry
+	size   int64
+	logger logger.AppLogger
+}
+
+func NewLog(cfg Config, logger logger.AppLogger) (*Log, error) {
+	if logger == nil || cfg.Path == "" {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	if len(cfg.Key) == 0 {
+		return nil, errors.NewAppError(ERROR_MISSING_KEY)
+	}
+	if cfg.Lock.Timeout <= 0 {
+		cfg.Lock.Timeout = DEFAULT_LOCK_TIMEOUT
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), os.ModePerm); err != nil {
+		return nil, errors.WrapError(err, ERROR_OPENING_AUDIT_LOG, cfg.Path)
+	}
+	// log exists before it's locked, locks don't create it with their permissions
+	f, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_OPENING_AUDIT_LOG, cfg.Path)
+	}
+	f.Close()
+	locker, err := lock.NewLocker(cfg.Lock, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		config: cfg,
+		locker: locker,
+		head:   Head{Hash: GENESIS_HASH},
+		logger: logger,
+	}
+	// resumes chain from last entry of existing log
+	if err := l.resume(false); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// resume reads entries appended since last known entry, by this or other logs of same path,
+// a torn trailing entry, left by an append that failed or crashed mid write, is skipped & reported,
+// & truncated if repair is set, by appends holding log's lock
+func (l *Log) resume(repair bool) error {
+	size, err := scan(l.config.Path, l.size, func(line int, e Entry) error {
+		l.head = Head{Seq: e.Seq, Hash: e.Hash}
+		return nil
+	})
+	if err != nil {
+		torn, tornErr := tornTail(l.config.Path, size)
+		if tornErr != nil || !torn {
+			return err
+		}
+		l.logger.Error("skipping torn audit log entry", zap.String("path", l.config.Path), zap.Int64("offset", size), zap.Bool("truncating", repair))
+		if repair {
+			if err := os.Truncate(l.config.Path, size); err != nil {
+				return errors.WrapError(err, ERROR_WRITING_AUDIT_LOG, l.config.Path)
+			}
+		}
+	}
+	l.size = size
+	return nil
+}
+
+// tornTail checks if log at path ends, from offset, in an entry without its terminating newline
+func tornTail(path string, offset int64) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	rest, err := io.ReadAll(io.LimitReader(f, int64(MAX_ENTRY_SIZE)+1))
+	if err != nil {
+		return false, err
+	}
+	return len(rest) > 0 && len(rest) <= MAX_ENTRY_SIZE && !bytes.Contains(rest, []byte{'\n'}), nil
+}
+
+// Head returns log's last appended entry's sequence & hash
+func (l *Log) Head() Head {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// Append completes entry's sequence, time, principal, outcome & chain hashes,
+// appends it to log & returns it
+func (l *Log) Append(ctx context.Context, e Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// entries are recorded for cancelled operations too, lock waits up to lock timeout
+	lk, err := l.locker.Lock(context.Background(), l.config.Path)
+	if err != nil {
+		return e, errors.WrapError(err, ERROR_OPENING_AUDIT_LOG, l.config.Path)
+	}
+	defer func() {
+		if err := lk.Unlock(); err != nil {
+			l.logger.Error("error unlocking audit log", zap.Error(err), zap.String("path", l.config.Path))
+		}
+	}()
+	if err := l.resume(true); err != nil {
+		return e, err
+	}
+
+	e.Seq = l.head.Seq + 1
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	if e.Principal == "" {
+		e.Principal = PrincipalFrom(ctx)
+	}
+	if e.Principal == "" {
+		e.Principal = l.config.Principal
+	}
+	if e.Outcome == "" {
+		e.Outcome = OUTCOME_OK
+		if e.Error != "" {
+			e.Outcome = OUTCOME_ERROR
+		}
+	}
+	e.PrevHash = l.head.Hash
+	hash, err := e.hash(l.config.Key)
+	if err != nil {
+		return e, errors.WrapError(err, ERROR_WRITING_AUDIT_LOG, l.config.Path)
+	}
+	e.Hash = hash
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return e, errors.WrapError(err, ERROR_WRITING_AUDIT_LOG, l.config.Path)
+	}
+	f, err := os.OpenFile(l.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return e, errors.WrapError(err, ERROR_OPENING_AUDIT_LOG, l.config.Path)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		l.truncate(f)
+		return e, errors.WrapError(err, ERROR_WRITING_AUDIT_LOG, l.config.Path)
+	}
+	if err := f.Sync(); err != nil {
+		l.truncate(f)
+		return e, errors.WrapError(err, ERROR_WRITING_AUDIT_LOG, l.config.Path)
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return e, errors.WrapError(err, ERROR_WRITING_AUDIT_LOG, l.config.Path)
+	}
+
+	l.head, l.size = Head{Seq: e.Seq, Hash: e.Hash}, size
+	return e, nil
+}
+
+// truncate drops partially written entry of a failed append, torn lines break log's chain
+func (l *Log) truncate(f *os.File) {
+	if err := f.Truncate(l.size); err != nil {
+		l.logger.Error("error truncating audit log", zap.Error(err), zap.String("path", l.config.Path), zap.Int64("size", l.size))
+	}
+}
+
+// Verify verifies log's chain reaches log's last appended entry, see VerifyHead
+func (l *Log) Verify() (Head, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return VerifyHead(l.config.Path, l.config.Key, l.head)
+}
+
+// VerifyHead verifies audit log at path, see Verify, & that it reaches given head,
+// kept outside the log, so entries deleted from log's end are detected,
+// returns head of verified log, which may have grown past given head
+func VerifyHead(path string, key []byte, head Head) (Head, error) {
+	last, err := Verify(path, key)
+	if err != nil {
+		return last, err
+	}
+	if last.Seq < head.Seq {
+		return last, errors.NewAppError(ERROR_HEAD_MISMATCH, path, head.Seq, "missing entries")
+	}
+	if head.Seq == 0 {
+		return last, nil
+	}
+	found := false
+	_, err = scan(path, 0, func(line int, e Entry) error {
+		if e.Seq == head.Seq {
+			found = e.Hash == head.Hash
+		}
+		return nil
+	})
+	if err != nil {
+		return last, err
+	}
+	if !found {
+		return last, errors.NewAppError(ERROR_HEAD_MISMATCH, path, head.Seq, "hash mismatch")
+	}
+	return last, nil
+}
+
+// Verify checks every entry of audit log at path is in sequence, chained to previous entry's hash
+// & hashes, keyed by key, to its recorded hash, returns head of verified entries,
+// missing log verifies as empty, entries deleted from log's end are detected by VerifyHead
+func Verify(path string, key []byte) (Head, error) {
+	if len(key) == 0 {
+		return Head{Hash: GENESIS_HASH}, errors.NewAppError(ERROR_MISSING_KEY)
+	}
+	head := Head{Hash: GENESIS_HASH}
+	_, err := scan(path, 0, func(line int, e Entry) error {
+		if e.Seq != int64(line) {
+			return errors.NewAppError(ERROR_BROKEN_CHAIN, line, "out of sequence")
+		}
+		if e.PrevHash != head.Hash {
+			return errors.NewAppError(ERROR_BROKEN_CHAIN, line, "previous hash mismatch")
+		}
+		hash, err := e.hash(key)
+		if err != nil || !hmac.Equal([]byte(hash), []byte(e.Hash)) {
+			return errors.NewAppError(ERROR_BROKEN_CHAIN, line, "hash mismatch")
+		}
+		head = Head{Seq: e.Seq, Hash: e.Hash}
+		return nil
+	})
+	return head, err
+}
+
+// scan decodes entries of log at path from offset, in order, stops at first error,
+// returns offset after last scanned entry, line numbers count from offset
+func scan(path string, offset int64, fn func(line int, e Entry) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return offset, nil
+		}
+		return offset, errors.WrapError(err, ERROR_READING_AUDIT_LOG, path)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, errors.WrapError(err, ERROR_READING_AUDIT_LOG, path)
+	}
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), MAX_ENTRY_SIZE)
+	line := 0
+	for s.Scan() {
+		line++
+		var e Entry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return offset, errors.NewAppError(ERROR_BROKEN_CHAIN, line, "malformed entry")
+		}
+		if err := fn(line, e); err != nil {
+			return offset, err
+		}
+		// entries are newline terminated
+		offset += int64(len(s.Bytes())) + 1
+	}
+	if err := s.Err(); err != nil {
+		return offset, errors.WrapError(err, ERROR_READING_AUDIT_LOG, path)
+	}
+	return offset, nil
+}
+
+// Checksum returns hex encoded sha256 of file at path
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.WrapError(err, ERROR_CHECKSUM_FILE, path)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.WrapError(err, ERROR_CHECKSUM_FILE, path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns context carrying caller principal, recorded on audit entries
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns context's caller principal, if any
+func PrincipalFrom(ctx context.Context) string {
+	p, _ := ctx.Value(principalKey{}).(string)
+	return p
+}
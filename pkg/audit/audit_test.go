@@ -0,0 +1,251 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+)
+
+const TEST_DIR = "data"
+
+var testKey = []byte("audit-test-key")
+
+func TestAuditLog(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	logPath := filepath.Join(TEST_DIR, "audit", "audit.jsonl")
+	_, err := NewLog(Config{Path: logPath}, logger)
+	require.Error(t, err)
+	l, err := NewLog(Config{Path: logPath, Principal: "system", Key: testKey}, logger)
+	require.NoError(t, err)
+
+	ctx := WithPrincipal(context.Background(), "alice")
+	e, err := l.Append(ctx, Entry{Op: "WriteFile", Path: "data/a.json", Bytes: 12, Checksum: "abc"})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), e.Seq)
+	require.Equal(t, "alice", e.Principal)
+	require.Equal(t, OUTCOME_OK, e.Outcome)
+	require.Equal(t, GENESIS_HASH, e.PrevHash)
+	first := e.Hash
+
+	e, err = l.Append(context.Background(), Entry{Op: "Delete", Path: "data/a.json", Error: "missing"})
+	require.NoError(t, err)
+	require.Equal(t, "system", e.Principal)
+	require.Equal(t, OUTCOME_ERROR, e.Outcome)
+	require.Equal(t, first, e.PrevHash)
+	require.Equal(t, Head{Seq: 2, Hash: e.Hash}, l.Head())
+
+	head, err := l.Verify()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), head.Seq)
+
+	// reopened log resumes chain
+	l, err = NewLog(Config{Path: logPath, Key: testKey}, logger)
+	require.NoError(t, err)
+	e, err = l.Append(ctx, Entry{Op: "Copy", Path: "data/a.json", Dest: "data/b.json"})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), e.Seq)
+	head, err = Verify(logPath, testKey)
+	require.NoError(t, err)
+	require.Equal(t, l.Head(), head)
+
+	// other keys don't verify
+	_, err = Verify(logPath, []byte("other-key"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 1: hash mismatch")
+
+	// missing log verifies empty
+	head, err = Verify(filepath.Join(TEST_DIR, "missing.jsonl"), testKey)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), head.Seq)
+}
+
+func TestAuditLogSharedPath(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	// logs of same path extend one chain
+	logPath := filepath.Join(TEST_DIR, "audit.jsonl")
+	logs := []*Log{}
+	for i := 0; i < 2; i++ {
+		l, err := NewLog(Config{Path: logPath, Key: testKey}, logger)
+		require.NoError(t, err)
+		logs = append(logs, l)
+	}
+	var wg sync.WaitGroup
+	for _, l := range logs {
+		wg.Add(1)
+		go func(l *Log) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_, err := l.Append(context.Background(), Entry{Op: "WriteFile", Path: "data/a.json"})
+				require.NoError(t, err)
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	head, err := Verify(logPath, testKey)
+	require.NoError(t, err)
+	require.Equal(t, int64(20), head.Seq)
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	logPath := filepath.Join(TEST_DIR, "audit.jsonl")
+	l, err := NewLog(Config{Path: logPath, Key: testKey}, logger)
+	require.NoError(t, err)
+	for _, p := range []string{"data/a.json", "data/b.json", "data/c.json"} {
+		_, err := l.Append(context.Background(), Entry{Op: "WriteFile", Path: p, Bytes: 10})
+		require.NoError(t, err)
+	}
+	content, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(content), "\n")
+
+	for name, tc := range map[string]struct {
+		lines []string
+		err   string
+	}{
+		"edited entry": {
+			lines: []string{lines[0], strings.Replace(lines[1], `"bytes":10`, `"bytes":99`, 1), lines[2]},
+			err:   "line 2: hash mismatch",
+		},
+		"dropped entry": {
+			lines: []string{lines[0], lines[2]},
+			err:   "line 2: out of sequence",
+		},
+		"malformed entry": {
+			lines: []string{lines[0], "{\n"},
+			err:   "line 2: malformed entry",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tampered := filepath.Join(TEST_DIR, "tampered.jsonl")
+			err := os.WriteFile(tampered, []byte(strings.Join(tc.lines, "")), 0644)
+			require.NoError(t, err)
+			head, err := Verify(tampered, testKey)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+			require.Equal(t, int64(1), head.Seq)
+		})
+	}
+
+	// entries dropped from log's end are detected against head kept elsewhere
+	head := l.Head()
+	truncated := filepath.Join(TEST_DIR, "truncated.jsonl")
+	err = os.WriteFile(truncated, []byte(lines[0]+lines[1]), 0644)
+	require.NoError(t, err)
+	_, err = Verify(truncated, testKey)
+	require.NoError(t, err)
+	_, err = VerifyHead(truncated, testKey, head)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "missing entries")
+	last, err := VerifyHead(logPath, testKey, head)
+	require.NoError(t, err)
+	require.Equal(t, head, last)
+	_, err = VerifyHead(logPath, testKey, Head{Seq: 2, Hash: head.Hash})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "hash mismatch")
+
+	// edited & rechained entries don't verify without the key
+	var e Entry
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &e))
+	e.Bytes = 99
+	e.Hash, err = e.hash([]byte("guessed-key"))
+	require.NoError(t, err)
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+	err = os.WriteFile(truncated, append(data, '\n'), 0644)
+	require.NoError(t, err)
+	_, err = Verify(truncated, testKey)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 1: hash mismatch")
+}
+
+func TestAuditLogTornEntry(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	logPath := filepath.Join(TEST_DIR, "audit.jsonl")
+	l, err := NewLog(Config{Path: logPath, Key: testKey}, logger)
+	require.NoError(t, err)
+	for _, p := range []string{"data/a.json", "data/b.json"} {
+		_, err := l.Append(context.Background(), Entry{Op: "WriteFile", Path: p})
+		require.NoError(t, err)
+	}
+
+	// entry torn by a crashed append
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":3,"time":"2023-`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = Verify(logPath, testKey)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "line 3: malformed entry")
+
+	// reopened log skips torn entry, next append truncates it
+	l, err = NewLog(Config{Path: logPath, Key: testKey}, logger)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), l.Head().Seq)
+	e, err := l.Append(context.Background(), Entry{Op: "Delete", Path: "data/a.json"})
+	require.NoError(t, err)
+	require.Equal(t, int64(3), e.Seq)
+	head, err := Verify(logPath, testKey)
+	require.NoError(t, err)
+	require.Equal(t, e.Hash, head.Hash)
+
+	// torn entries followed by others aren't skipped
+	f, err = os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("{\"seq\":4,\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	_, err = NewLog(Config{Path: logPath, Key: testKey}, logger)
+	require.Error(t, err)
+	_, err = l.Append(context.Background(), Entry{Op: "Delete", Path: "data/b.json"})
+	require.Error(t, err)
+}
+
+func TestChecksum(t *testing.T) {
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	err := os.MkdirAll(TEST_DIR, os.ModePerm)
+	require.NoError(t, err)
+	fPath := filepath.Join(TEST_DIR, "sum.txt")
+	err = os.WriteFile(fPath, []byte("hello"), 0644)
+	require.NoError(t, err)
+
+	sum, err := Checksum(fPath)
+	require.NoError(t, err)
+	require.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", sum)
+
+	_, err = Checksum(filepath.Join(TEST_DIR, "missing.txt"))
+	require.Error(t, err)
+}
@@ -0,0 +1,263 @@
+package charset
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/comfforts/errors"
+)
+
+const (
+	ERROR_UNSUPPORTED_ENCODING string = "unsupported encoding %s"
+	ERROR_DETECTING_ENCODING   string = "error detecting encoding"
+)
+
+type Encoding string
+
+const (
+	// AUTO detects encoding from byte order mark or content
+	AUTO        Encoding = ""
+	UTF8        Encoding = "utf-8"
+	UTF16LE     Encoding = "utf-16le"
+	UTF16BE     Encoding = "utf-16be"
+	LATIN1      Encoding = "iso-8859-1"
+	WINDOWS1252 Encoding = "windows-1252"
+)
+
+// sniffSize is the number of leading bytes inspected to detect encoding
+const sniffSize = 4096
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// ParseEncoding returns encoding for a name, as used in flags & configs
+func ParseEncoding(name string) (Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "auto":
+		return AUTO, nil
+	case "utf-8", "utf8":
+		return UTF8, nil
+	case "utf-16le", "utf16le":
+		return UTF16LE, nil
+	case "utf-16be", "utf16be":
+		return UTF16BE, nil
+	case "iso-8859-1", "latin1", "latin-1":
+		return LATIN1, nil
+	case "windows-1252", "cp1252":
+		return WINDOWS1252, nil
+	}
+	return AUTO, errors.NewAppError(ERROR_UNSUPPORTED_ENCODING, name)
+}
+
+// NewReader returns a reader decoding r from given encoding into utf-8,
+// with any byte order mark stripped, & the encoding used,
+// AUTO picks encoding from byte order mark, else utf-16 zero byte patterns,
+// else utf-8 if content is valid utf-8, else windows-1252
+func NewReader(r io.Reader, enc Encoding) (io.Reader, Encoding, error) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	head, err := br.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, enc, errors.WrapError(err, ERROR_DETECTING_ENCODING)
+	}
+
+	bomEnc, bomLen := detectBOM(head)
+	if enc == AUTO {
+		enc = bomEnc
+		if enc == AUTO {
+			enc = sniff(head)
+		}
+	}
+	// only strip a byte order mark matching the encoding
+	if bomLen > 0 && bomEnc == enc {
+		if _, err := br.Discard(bomLen); err != nil {
+			return nil, enc, errors.WrapError(err, ERROR_DETECTING_ENCODING)
+		}
+	}
+
+	switch enc {
+	case UTF8:
+		return br, enc, nil
+	case UTF16LE:
+		return &utf16Reader{r: br, littleEndian: true}, enc, nil
+	case UTF16BE:
+		return &utf16Reader{r: br}, enc, nil
+	case LATIN1:
+		return &singleByteReader{r: br, table: nil}, enc, nil
+	case WINDOWS1252:
+		return &singleByteReader{r: br, table: &windows1252}, enc, nil
+	}
+	return nil, enc, errors.NewAppError(ERROR_UNSUPPORTED_ENCODING, string(enc))
+}
+
+// StripBOM returns s without a leading utf-8 byte order mark
+func StripBOM(s string) string {
+	return strings.TrimPrefix(s, string(bomUTF8))
+}
+
+func detectBOM(head []byte) (Encoding, int) {
+	switch {
+	case bytes.HasPrefix(head, bomUTF8):
+		return UTF8, len(bomUTF8)
+	case bytes.HasPrefix(head, bomUTF16LE):
+		return UTF16LE, len(bomUTF16LE)
+	case bytes.HasPrefix(head, bomUTF16BE):
+		return UTF16BE, len(bomUTF16BE)
+	}
+	return AUTO, 0
+}
+
+// sniff guesses encoding of content without byte order mark
+func sniff(head []byte) Encoding {
+	if len(head) >= 2 {
+		var evenZeros, oddZeros int
+		n := len(head) &^ 1
+		for i := 0; i < n; i += 2 {
+			if head[i] == 0 {
+				evenZeros++
+			}
+			if head[i+1] == 0 {
+				oddZeros++
+			}
+		}
+		pairs := n / 2
+		// mostly ascii text in utf-16 has a zero byte in every pair
+		if oddZeros*10 >= pairs*7 && evenZeros*10 < pairs {
+			return UTF16LE
+		}
+		if evenZeros*10 >= pairs*7 && oddZeros*10 < pairs {
+			return UTF16BE
+		}
+	}
+
+	// a multi byte sequence may be cut at the end of the sniffed bytes
+	valid := head
+	for i := 0; i < utf8.UTFMax && len(valid) > 0; i++ {
+		if utf8.Valid(valid) {
+			return UTF8
+		}
+		valid = valid[:len(valid)-1]
+	}
+	if len(valid) == 0 {
+		return UTF8
+	}
+	return WINDOWS1252
+}
+
+// utf16Reader decodes utf-16 bytes into utf-8
+type utf16Reader struct {
+	r            *bufio.Reader
+	littleEndian bool
+	out          []byte
+	err          error
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	for len(u.out) == 0 && u.err == nil {
+		u.fill()
+	}
+	if len(u.out) == 0 {
+		return 0, u.err
+	}
+	n := copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+// fill decodes up to a buffer of code units into out
+func (u *utf16Reader) fill() {
+	var buf [utf8.UTFMax]byte
+	for i := 0; i < 1024; i++ {
+		c, err := u.unit()
+		if err != nil {
+			u.err = err
+			return
+		}
+		r := rune(c)
+		if utf16.IsSurrogate(r) {
+			next, err := u.r.Peek(2)
+			if err == nil {
+				c2 := u.decode(next)
+				if dec := utf16.DecodeRune(r, rune(c2)); dec != utf8.RuneError {
+					u.r.Discard(2)
+					r = dec
+				} else {
+					r = utf8.RuneError
+				}
+			} else {
+				r = utf8.RuneError
+			}
+		}
+		n := utf8.EncodeRune(buf[:], r)
+		u.out = append(u.out, buf[:n]...)
+	}
+}
+
+func (u *utf16Reader) unit() (uint16, error) {
+	var b [2]byte
+	n, err := io.ReadFull(u.r, b[:])
+	if err == io.ErrUnexpectedEOF && n == 1 {
+		// dangling byte
+		return uint16(utf8.RuneError), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return u.decode(b[:]), nil
+}
+
+func (u *utf16Reader) decode(b []byte) uint16 {
+	if u.littleEndian {
+		return uint16(b[0]) | uint16(b[1])<<8
+	}
+	return uint16(b[0])<<8 | uint16(b[1])
+}
+
+// singleByteReader decodes single byte encodings into utf-8,
+// bytes map to the same code point unless table maps 0x80-0x9F
+type singleByteReader struct {
+	r     *bufio.Reader
+	table *[32]rune
+	out   []byte
+}
+
+func (s *singleByteReader) Read(p []byte) (int, error) {
+	if len(s.out) == 0 {
+		var in [1024]byte
+		n, err := s.r.Read(in[:])
+		var buf [utf8.UTFMax]byte
+		for _, b := range in[:n] {
+			if b < utf8.RuneSelf {
+				s.out = append(s.out, b)
+				continue
+			}
+			r := rune(b)
+			if s.table != nil && b >= 0x80 && b <= 0x9F {
+				r = s.table[b-0x80]
+			}
+			m := utf8.EncodeRune(buf[:], r)
+			s.out = append(s.out, buf[:m]...)
+		}
+		if len(s.out) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// windows1252 maps bytes 0x80-0x9F, the rest match latin-1,
+// undefined bytes map to the replacement character
+var windows1252 = [32]rune{
+	0x20AC, 0xFFFD, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0xFFFD, 0x017D, 0xFFFD,
+	0xFFFD, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0xFFFD, 0x017E, 0x0178,
+}
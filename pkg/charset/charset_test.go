@@ -0,0 +1,81 @@
+package charset
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/require"
+)
+
+const TEST_TEXT = "entity_num|EntityName\nC0001|Café Déjà Vu “Ltd” – €5 😀\n"
+
+func TestNewReader(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		data     []byte
+		enc      Encoding
+		expected Encoding
+		text     string
+	}{
+		"utf-8 bom is stripped": {
+			append(append([]byte{}, bomUTF8...), []byte(TEST_TEXT)...), AUTO, UTF8, TEST_TEXT,
+		},
+		"utf-8 without bom is detected": {
+			[]byte(TEST_TEXT), AUTO, UTF8, TEST_TEXT,
+		},
+		"utf-16le bom is detected": {
+			append(append([]byte{}, bomUTF16LE...), encodeUTF16(TEST_TEXT, true)...), AUTO, UTF16LE, TEST_TEXT,
+		},
+		"utf-16be bom is detected": {
+			append(append([]byte{}, bomUTF16BE...), encodeUTF16(TEST_TEXT, false)...), AUTO, UTF16BE, TEST_TEXT,
+		},
+		"utf-16le without bom is sniffed": {
+			encodeUTF16("entity_num|EntityName\nC0001|Plaza\n", true), AUTO, UTF16LE, "entity_num|EntityName\nC0001|Plaza\n",
+		},
+		"utf-16be selected": {
+			encodeUTF16(TEST_TEXT, false), UTF16BE, UTF16BE, TEST_TEXT,
+		},
+		"windows-1252 is sniffed": {
+			[]byte("C0001|Caf\xe9 \x93Ltd\x94 \x96 \x805\n"), AUTO, WINDOWS1252, "C0001|Café “Ltd” – €5\n",
+		},
+		"latin-1 selected": {
+			[]byte("C0001|Caf\xe9 \x93\n"), LATIN1, LATIN1, "C0001|Café \u0093\n",
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			r, enc, err := NewReader(bytes.NewReader(tc.data), tc.enc)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, enc)
+
+			out, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, tc.text, string(out))
+		})
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	enc, err := ParseEncoding("UTF16LE")
+	require.NoError(t, err)
+	require.Equal(t, UTF16LE, enc)
+
+	enc, err = ParseEncoding("cp1252")
+	require.NoError(t, err)
+	require.Equal(t, WINDOWS1252, enc)
+
+	_, err = ParseEncoding("ebcdic")
+	require.Error(t, err)
+}
+
+func encodeUTF16(s string, littleEndian bool) []byte {
+	out := []byte{}
+	for _, u := range utf16.Encode([]rune(s)) {
+		if littleEndian {
+			out = append(out, byte(u), byte(u>>8))
+		} else {
+			out = append(out, byte(u>>8), byte(u))
+		}
+	}
+	return out
+}
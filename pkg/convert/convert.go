@@ -0,0 +1,115 @@
+package convert
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/comfforts/errors"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const ERROR_KEY_CONFLICT string = "key %s conflicts with value at %s"
+
+// DEFAULT_SEPARATOR joins nested object keys into flattened column names
+const DEFAULT_SEPARATOR string = "."
+
+// jsonNumber matches json number grammar, so values like zip codes with leading zeros stay strings
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// Flatten returns record with nested objects flattened into keys joined by sep,
+// e.g. {"address": {"city": "LA"}} becomes {"address.city": "LA"},
+// arrays & empty objects are kept as values
+func Flatten(r models.JSONMapper, sep string) models.JSONMapper {
+	if sep == "" {
+		sep = DEFAULT_SEPARATOR
+	}
+	flat := models.JSONMapper{}
+	flatten(flat, "", r, sep)
+	return flat
+}
+
+func flatten(flat models.JSONMapper, prefix string, r map[string]interface{}, sep string) {
+	for k, v := range r {
+		key := k
+		if prefix != "" {
+			key = prefix + sep + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok && len(nested) > 0 {
+			flatten(flat, key, nested, sep)
+			continue
+		}
+		flat[key] = v
+	}
+}
+
+// Unflatten returns record with keys joined by sep nested into objects, reverses Flatten,
+// keys are nested in sorted order, keys conflicting with a value at a parent key, e.g. a.b & a.b.c,
+// fail with an error, since one of them would be dropped
+func Unflatten(r models.JSONMapper, sep string) (models.JSONMapper, error) {
+	if sep == "" {
+		sep = DEFAULT_SEPARATOR
+	}
+	keys := make([]string, 0, len(r))
+	for k := range r {
+		keys = append(keys, k)
+	}
+	// parent keys sort before their nested keys
+	sort.Strings(keys)
+
+	nested := models.JSONMapper{}
+	for _, k := range keys {
+		parts := strings.Split(k, sep)
+		obj := map[string]interface{}(nested)
+		for i, p := range parts[:len(parts)-1] {
+			child, ok := obj[p]
+			if !ok {
+				child = map[string]interface{}{}
+				obj[p] = child
+			}
+			m, ok := child.(map[string]interface{})
+			if !ok {
+				return nil, errors.NewAppError(ERROR_KEY_CONFLICT, k, strings.Join(parts[:i+1], sep))
+			}
+			obj = m
+		}
+		leaf := parts[len(parts)-1]
+		if _, ok := obj[leaf]; ok {
+			return nil, errors.NewAppError(ERROR_KEY_CONFLICT, k, k)
+		}
+		obj[leaf] = r[k]
+	}
+	return nested, nil
+}
+
+// InferValue returns nil for empty & null text, bool for true & false,
+// json.Number for numbers & the text otherwise
+func InferValue(s string) interface{} {
+	switch {
+	case s == "", strings.EqualFold(s, "null"):
+		return nil
+	case strings.EqualFold(s, "true"):
+		return true
+	case strings.EqualFold(s, "false"):
+		return false
+	case jsonNumber.MatchString(s):
+		// kept as json number text, so large ids don't lose precision
+		return json.Number(s)
+	}
+	return s
+}
+
+// Infer returns record with string values replaced by inferred values
+func Infer(r models.JSONMapper) models.JSONMapper {
+	inferred := make(models.JSONMapper, len(r))
+	for k, v := range r {
+		if s, ok := v.(string); ok {
+			inferred[k] = InferValue(s)
+			continue
+		}
+		inferred[k] = v
+	}
+	return inferred
+}
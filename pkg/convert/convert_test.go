@@ -0,0 +1,113 @@
+package convert
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+func TestFlattenUnflatten(t *testing.T) {
+	r := models.JSONMapper{
+		"entity_num": "C0001",
+		"address": map[string]interface{}{
+			"city": "Los Angeles",
+			"geo": map[string]interface{}{
+				"lat": 34.05,
+			},
+		},
+		"tags": []interface{}{"llc", "active"},
+	}
+
+	flat := Flatten(r, "")
+	require.Equal(t, models.JSONMapper{
+		"entity_num":      "C0001",
+		"address.city":    "Los Angeles",
+		"address.geo.lat": 34.05,
+		"tags":            []interface{}{"llc", "active"},
+	}, flat)
+	nested, err := Unflatten(flat, "")
+	require.NoError(t, err)
+	require.Equal(t, r, nested)
+
+	flat = Flatten(r, "_")
+	require.Contains(t, flat, "address_geo_lat")
+}
+
+func TestUnflattenConflict(t *testing.T) {
+	for scenario, r := range map[string]models.JSONMapper{
+		"scalar parent key conflict fails": {
+			"address":      "123 Main St",
+			"address.city": "Los Angeles",
+			"agent.name":   "Wong",
+		},
+		"nested scalar parent key conflict fails": {
+			"a.b":   "x",
+			"a.b.c": "y",
+			"a.d":   "z",
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			// fails alike whatever the map order
+			for i := 0; i < 20; i++ {
+				_, err := Unflatten(r, ".")
+				require.Error(t, err)
+			}
+		})
+	}
+
+	nested, err := Unflatten(models.JSONMapper{
+		"a.b.c": "x",
+		"a.b.d": "y",
+		"a.e":   "z",
+	}, ".")
+	require.NoError(t, err)
+	require.Equal(t, models.JSONMapper{
+		"a": map[string]interface{}{
+			"b": map[string]interface{}{"c": "x", "d": "y"},
+			"e": "z",
+		},
+	}, nested)
+}
+
+func TestInferValue(t *testing.T) {
+	for text, expected := range map[string]interface{}{
+		"":                    nil,
+		"null":                nil,
+		"NULL":                nil,
+		"true":                true,
+		"False":               false,
+		"42":                  json.Number("42"),
+		"-3.5e2":              json.Number("-3.5e2"),
+		"123456789012345678":  json.Number("123456789012345678"),
+		"02134":               "02134",
+		"C0001":               "C0001",
+		"1.":                  "1.",
+		"Plaza Hollywood LLC": "Plaza Hollywood LLC",
+	} {
+		require.Equal(t, expected, InferValue(text), text)
+	}
+}
+
+func TestInfer(t *testing.T) {
+	r := Infer(models.JSONMapper{
+		"entity_num": "C0001",
+		"employees":  "12",
+		"active":     "true",
+		"agent":      "",
+		"score":      1.5,
+	})
+	require.Equal(t, models.JSONMapper{
+		"entity_num": "C0001",
+		"employees":  json.Number("12"),
+		"active":     true,
+		"agent":      nil,
+		"score":      1.5,
+	}, r)
+
+	data, err := json.Marshal(r)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"employees":12`)
+}
@@ -9,41 +9,118 @@ import (
 	"github.com/comfforts/errors"
 	"github.com/comfforts/logger"
 	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/charset"
+	"github.com/comfforts/localstorage/pkg/models"
 )
 
 const (
-	ERR_FILE        string = "%s doesn't exist"
-	ERR_NO_FILE     string = "file doesn't exist"
-	ERR_CSV_HEADERS string = "error reading csv headers"
-	ERR_CSV_RECORD  string = "error reading csv record"
+	ERR_FILE            string = "%s doesn't exist"
+	ERR_NO_FILE         string = "file doesn't exist"
+	ERR_CSV_HEADERS     string = "error reading csv headers"
+	ERR_CSV_RECORD      string = "error reading csv record"
+	ERR_CSV_WRITE       string = "error writing csv record"
+	ERR_CSV_DECODE      string = "error decoding csv file %s"
+	ERR_CSV_COLUMN      string = "column %s not in csv headers"
+	ERR_CSV_FIELD_COUNT string = "line %d has %d fields, expected %d"
 )
 
+// DEFAULT_COMMA is the field delimiter of csv files
+const DEFAULT_COMMA rune = '|'
+
+type CSVConfig struct {
+	// Encoding of file content, AUTO detects it, byte order marks are stripped
+	Encoding charset.Encoding
+	// Headers normalize header names
+	Headers HeaderRules
+	// Columns, if set, are the normalized header names emitted, in order,
+	// other columns are dropped
+	Columns []string
+	// Comma is the field delimiter, defaults to DEFAULT_COMMA
+	Comma rune
+	// LazyQuotes allows quotes in unquoted fields & non-doubled quotes in quoted fields
+	LazyQuotes bool
+	// RowPolicy handles rows with more or fewer fields than headers, when reading records
+	RowPolicy RowPolicy
+}
+
+type RowPolicy string
+
+const (
+	// ROW_PAD pads short rows with empty values & drops extra fields of long rows
+	ROW_PAD RowPolicy = ""
+	// ROW_DROP drops rows with mismatched field count
+	ROW_DROP RowPolicy = "drop"
+	// ROW_ERROR reports rows with mismatched field count as errors
+	ROW_ERROR RowPolicy = "error"
+	// ROW_EXTRAS pads short rows & collects extra fields of long rows under EXTRAS_KEY
+	ROW_EXTRAS RowPolicy = "extras"
+)
+
+// EXTRAS_KEY is the reserved record key holding extra fields of long rows
+const EXTRAS_KEY string = "_extras"
+
 type csvFiler struct {
 	*os.File
-	reader *csv.Reader
-	size   uint64
-	logger logger.AppLogger
+	reader     *csv.Reader
+	size       uint64
+	encoding   charset.Encoding
+	config     CSVConfig
+	headers    []string
+	headersErr error
+	// headersRead is set once headers are read
+	headersRead bool
+	fieldCount  int
+	projection  []int
+	logger      logger.AppLogger
 }
 
 func NewCSVFiler(f *os.File, logger logger.AppLogger) (*csvFiler, error) {
+	return NewCSVFilerWithConfig(f, CSVConfig{}, logger)
+}
+
+func NewCSVFilerWithConfig(f *os.File, cfg CSVConfig, logger logger.AppLogger) (*csvFiler, error) {
+	return NewCSVFilerWithReader(f, f, cfg, logger)
+}
+
+// NewCSVFilerWithReader returns filer of file f reading its content from src,
+// e.g. a decrypting reader of f
+func NewCSVFilerWithReader(f *os.File, src io.Reader, cfg CSVConfig, logger logger.AppLogger) (*csvFiler, error) {
 	fs, err := os.Stat(f.Name())
 	if err != nil {
 		logger.Error(ERR_NO_FILE, zap.Error(err))
 		return nil, errors.WrapError(err, ERR_FILE, f.Name())
 	}
 	size := uint64(fs.Size())
-	reader := csv.NewReader(f)
-	reader.Comma = '|'
+
+	r, enc, err := charset.NewReader(src, cfg.Encoding)
+	if err != nil {
+		logger.Error(ERR_CSV_DECODE, zap.Error(err))
+		return nil, errors.WrapError(err, ERR_CSV_DECODE, f.Name())
+	}
+	reader := csv.NewReader(r)
+	reader.Comma = DEFAULT_COMMA
+	if cfg.Comma != 0 {
+		reader.Comma = cfg.Comma
+	}
+	reader.LazyQuotes = cfg.LazyQuotes
 	reader.FieldsPerRecord = -1
 
 	return &csvFiler{
-		File:   f,
-		size:   size,
-		reader: reader,
-		logger: logger,
+		File:     f,
+		size:     size,
+		reader:   reader,
+		encoding: enc,
+		config:   cfg,
+		logger:   logger,
 	}, nil
 }
 
+// Encoding returns encoding the file is decoded from
+func (f *csvFiler) Encoding() charset.Encoding {
+	return f.encoding
+}
+
 // ReadCSVFile takes context, []string res chan & err chan
 // sends headers as first result to res chan and records afterwards
 // sends errors on err channel
@@ -54,13 +131,106 @@ func (f *csvFiler) ReadCSVFile(ctx context.Context, resCh chan []string, errCh c
 		close(errCh)
 	}()
 
+	f.read(ctx, errCh, func(headers []string) bool {
+		resCh <- headers
+		return true
+	}, func(record []string, line int) bool {
+		if record != nil {
+			record = f.project(record)
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case resCh <- record:
+			return true
+		}
+	})
+}
+
+// ReadCSVRecords takes context, JSONMapper res chan & err chan
+// sends each record keyed by header name to res chan,
+// rows with more or fewer fields than headers are handled per configured row policy
+// sends errors on err channel
+// closes res and err channels on done
+func (f *csvFiler) ReadCSVRecords(ctx context.Context, resCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(resCh)
+		close(errCh)
+	}()
+
+	var headers []string
+	f.read(ctx, errCh, func(h []string) bool {
+		headers = h
+		return h != nil
+	}, func(record []string, line int) bool {
+		if record == nil {
+			return true
+		}
+		r, err := f.toRecord(headers, record, line)
+		if err != nil {
+			errCh <- err
+			return true
+		}
+		if r == nil {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case resCh <- r:
+			return true
+		}
+	})
+}
+
+// ReadHeaders reads, normalizes & projects headers, returns emitted headers,
+// headers are read once, so callers can get them before reading records
+func (f *csvFiler) ReadHeaders() ([]string, error) {
+	if f.headersRead {
+		return f.headers, f.headersErr
+	}
+	f.headersRead = true
+
 	f.logger.Info("csv file: reading headers", zap.Any("offset", f.reader.InputOffset()))
 	headers, err := f.reader.Read()
 	if err != nil {
 		f.logger.Error(ERR_CSV_HEADERS, zap.Error(err))
-		errCh <- errors.WrapError(err, ERR_CSV_HEADERS)
+		f.headersErr = errors.WrapError(err, ERR_CSV_HEADERS)
+		return nil, f.headersErr
+	}
+	headers = f.config.Headers.Normalize(headers)
+	f.fieldCount = len(headers)
+
+	// projected columns' indexes in file headers
+	if len(f.config.Columns) > 0 {
+		f.projection, err = project(headers, f.config.Columns)
+		if err != nil {
+			f.logger.Error(ERR_CSV_HEADERS, zap.Error(err))
+			f.headersErr = err
+			return nil, f.headersErr
+		}
+		headers = append([]string{}, f.config.Columns...)
+		// records are copied into projected rows, so the reader can reuse its slice
+		f.reader.ReuseRecord = true
+	}
+	f.headers = headers
+	return f.headers, nil
+}
+
+// read reads headers & calls onHeaders with emitted headers,
+// then calls onRecord with each raw record & its line number, until either returns false
+func (f *csvFiler) read(ctx context.Context, errCh chan error, onHeaders func(headers []string) bool, onRecord func(record []string, line int) bool) {
+	headers, err := f.ReadHeaders()
+	if err != nil {
+		errCh <- err
+		// records can't be projected without headers
+		if len(f.config.Columns) > 0 {
+			return
+		}
+	}
+	if !onHeaders(headers) {
+		return
 	}
-	resCh <- headers
 
 	f.logger.Info("csv file: start reading records", zap.Any("offset", f.reader.InputOffset()))
 	for i := 0; ; i = i + 1 {
@@ -71,12 +241,111 @@ func (f *csvFiler) ReadCSVFile(ctx context.Context, resCh chan []string, errCh c
 		} else if err != nil {
 			f.logger.Error(ERR_CSV_RECORD, zap.Error(err), zap.Any("offset", f.reader.InputOffset()))
 			errCh <- errors.WrapError(err, ERR_CSV_RECORD)
+			// reading goes on past malformed rows, not past failed reads
+			if _, ok := err.(*csv.ParseError); !ok {
+				return
+			}
+		}
+
+		line := 0
+		if record != nil {
+			line, _ = f.reader.FieldPos(0)
+		}
+		if !onRecord(record, line) {
+			return
+		}
+	}
+}
+
+// project returns projected copy of record, or record if there's no projection
+func (f *csvFiler) project(record []string) []string {
+	if f.projection == nil {
+		return record
+	}
+	row := make([]string, len(f.projection))
+	for j, idx := range f.projection {
+		if idx < len(record) {
+			row[j] = record[idx]
+		}
+	}
+	return row
+}
+
+// toRecord keys record fields by headers, applying row policy to short & long rows,
+// returns nil record for dropped rows
+func (f *csvFiler) toRecord(headers, record []string, line int) (models.JSONMapper, error) {
+	var extras []string
+	if len(record) != f.fieldCount {
+		switch f.config.RowPolicy {
+		case ROW_DROP:
+			f.logger.Info("csv file: dropping row", zap.Int("line", line), zap.Int("fields", len(record)))
+			return nil, nil
+		case ROW_ERROR:
+			return nil, errors.NewAppError(ERR_CSV_FIELD_COUNT, line, len(record), f.fieldCount)
+		case ROW_EXTRAS:
+			if len(record) > f.fieldCount {
+				extras = append([]string{}, record[f.fieldCount:]...)
+			}
+		}
+	}
+
+	row := f.project(record)
+	r := make(models.JSONMapper, len(headers)+1)
+	for i, h := range headers {
+		if i < len(row) {
+			r[h] = row[i]
+		} else {
+			r[h] = ""
+		}
+	}
+	if extras != nil {
+		r[EXTRAS_KEY] = extras
+	}
+	return r, nil
+}
+
+// project returns indexes of columns in headers
+func project(headers, columns []string) ([]int, error) {
+	positions := make(map[string]int, len(headers))
+	for i, h := range headers {
+		if _, ok := positions[h]; !ok {
+			positions[h] = i
+		}
+	}
+	projection := make([]int, len(columns))
+	for i, c := range columns {
+		idx, ok := positions[c]
+		if !ok {
+			return nil, errors.NewAppError(ERR_CSV_COLUMN, c)
 		}
+		projection[i] = idx
+	}
+	return projection, nil
+}
+
+// WriteCSVFile takes context, writer & []string req chan
+// writes records received on req chan, headers first, as delimited rows
+// returns when req chan is closed or context is done
+func WriteCSVFile(ctx context.Context, w io.Writer, reqCh chan []string) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = DEFAULT_COMMA
 
+	for {
 		select {
 		case <-ctx.Done():
-			return
-		case resCh <- record:
+			writer.Flush()
+			return writer.Error()
+		case record, ok := <-reqCh:
+			if !ok {
+				writer.Flush()
+				if err := writer.Error(); err != nil {
+					return errors.WrapError(err, ERR_CSV_WRITE)
+				}
+				return nil
+			}
+			if err := writer.Write(record); err != nil {
+				return errors.WrapError(err, ERR_CSV_WRITE)
+			}
 		}
 	}
 }
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
 	"github.com/comfforts/logger"
+
+	"github.com/comfforts/localstorage/pkg/models"
 )
 
 const TEST_DIR = "data"
@@ -73,3 +76,145 @@ func TestAgents(t *testing.T) {
 		}
 	}
 }
+
+func TestSnakeCase(t *testing.T) {
+	for in, out := range map[string]string{
+		"Entity Num":     "entity_num",
+		"ENTITY_NUM":     "entity_num",
+		"entity_num":     "entity_num",
+		"EntityNum":      "entity_num",
+		" entity-num ":   "entity_num",
+		"LastSIFileDate": "last_si_file_date",
+		"Address2Line":   "address2_line",
+	} {
+		require.Equal(t, out, SnakeCase(in), in)
+	}
+}
+
+func TestReadCSVFileNormalizedProjection(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	err := os.MkdirAll(TEST_DIR, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	fPath := filepath.Join(TEST_DIR, "filings-wide.csv")
+	err = os.WriteFile(fPath, []byte(" Entity Name |ENTITY_NUM|Initial Filing Date|Jurisdiction|EntityStatus\nPlaza Hollywood LLC|C0001|2001-01-01|CA|Active\nTelford Plaza Corp|C0008\n"), os.ModePerm)
+	require.NoError(t, err)
+
+	file, err := os.Open(fPath)
+	require.NoError(t, err)
+
+	csvFiler, err := NewCSVFilerWithConfig(file, CSVConfig{
+		Headers: HeaderRules{
+			Trim:      true,
+			SnakeCase: true,
+			Aliases:   map[string]string{"jurisdiction": "state"},
+		},
+		Columns: []string{"entity_num", "state", "entity_name"},
+	}, logger)
+	require.NoError(t, err)
+	defer csvFiler.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resCh := make(chan []string)
+	errCh := make(chan error)
+	go csvFiler.ReadCSVFile(ctx, resCh, errCh)
+
+	rows := [][]string{}
+	for resCh != nil || errCh != nil {
+		select {
+		case r, ok := <-resCh:
+			if !ok {
+				resCh = nil
+				continue
+			}
+			rows = append(rows, r)
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+	require.Equal(t, [][]string{
+		{"entity_num", "state", "entity_name"},
+		{"C0001", "CA", "Plaza Hollywood LLC"},
+		{"C0008", "", "Telford Plaza Corp"},
+	}, rows)
+}
+
+func TestReadCSVRecordsRowPolicy(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	err := os.MkdirAll(TEST_DIR, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	fPath := filepath.Join(TEST_DIR, "filings-ragged.csv")
+	err = os.WriteFile(fPath, []byte("entity_name|entity_num|state\nPlaza Hollywood LLC|C0001|CA\nTelford Plaza Corp|C0008\nLakeside Inc|C0009|NV|extra|more\n"), os.ModePerm)
+	require.NoError(t, err)
+
+	full := models.JSONMapper{"entity_name": "Plaza Hollywood LLC", "entity_num": "C0001", "state": "CA"}
+	for policy, expected := range map[RowPolicy]struct {
+		records []models.JSONMapper
+		errs    int
+	}{
+		ROW_PAD: {records: []models.JSONMapper{
+			full,
+			{"entity_name": "Telford Plaza Corp", "entity_num": "C0008", "state": ""},
+			{"entity_name": "Lakeside Inc", "entity_num": "C0009", "state": "NV"},
+		}},
+		ROW_DROP:  {records: []models.JSONMapper{full}},
+		ROW_ERROR: {records: []models.JSONMapper{full}, errs: 2},
+		ROW_EXTRAS: {records: []models.JSONMapper{
+			full,
+			{"entity_name": "Telford Plaza Corp", "entity_num": "C0008", "state": ""},
+			{"entity_name": "Lakeside Inc", "entity_num": "C0009", "state": "NV", EXTRAS_KEY: []string{"extra", "more"}},
+		}},
+	} {
+		t.Run(fmt.Sprintf("policy %q", policy), func(t *testing.T) {
+			file, err := os.Open(fPath)
+			require.NoError(t, err)
+
+			csvFiler, err := NewCSVFilerWithConfig(file, CSVConfig{RowPolicy: policy}, logger)
+			require.NoError(t, err)
+			defer csvFiler.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			resCh := make(chan models.JSONMapper)
+			errCh := make(chan error)
+			go csvFiler.ReadCSVRecords(ctx, resCh, errCh)
+
+			records := []models.JSONMapper{}
+			errs := 0
+			for resCh != nil || errCh != nil {
+				select {
+				case r, ok := <-resCh:
+					if !ok {
+						resCh = nil
+						continue
+					}
+					records = append(records, r)
+				case _, ok := <-errCh:
+					if !ok {
+						errCh = nil
+						continue
+					}
+					errs++
+				}
+			}
+			require.Equal(t, expected.records, records)
+			require.Equal(t, expected.errs, errs)
+		})
+	}
+}
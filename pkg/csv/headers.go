@@ -0,0 +1,89 @@
+package csv
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/comfforts/localstorage/pkg/charset"
+)
+
+type CaseFold string
+
+const (
+	FOLD_NONE  CaseFold = ""
+	FOLD_LOWER CaseFold = "lower"
+	FOLD_UPPER CaseFold = "upper"
+)
+
+// HeaderRules normalize header names, applied in order:
+// trim, snake_case, case folding & finally alias lookup
+type HeaderRules struct {
+	Trim      bool
+	SnakeCase bool
+	Fold      CaseFold
+	// Aliases map normalized header names to canonical names,
+	// e.g. entity_number => entity_num
+	Aliases map[string]string
+}
+
+// Normalize returns normalized copy of headers
+func (hr HeaderRules) Normalize(headers []string) []string {
+	out := make([]string, len(headers))
+	for i, h := range headers {
+		out[i] = hr.NormalizeName(h)
+	}
+	return out
+}
+
+// NormalizeName normalizes a single header name
+func (hr HeaderRules) NormalizeName(h string) string {
+	// a byte order mark left by a mis-decoded first header is never part of the name
+	h = charset.StripBOM(h)
+	if hr.Trim {
+		h = strings.TrimSpace(h)
+	}
+	if hr.SnakeCase {
+		h = SnakeCase(h)
+	}
+	switch hr.Fold {
+	case FOLD_LOWER:
+		h = strings.ToLower(h)
+	case FOLD_UPPER:
+		h = strings.ToUpper(h)
+	}
+	if alias, ok := hr.Aliases[h]; ok {
+		h = alias
+	}
+	return h
+}
+
+// SnakeCase converts names like `Entity Num`, `EntityNum`, `ENTITY_NUM` or `entity-num` to entity_num
+func SnakeCase(s string) string {
+	runes := []rune(strings.TrimSpace(s))
+	words := []string{}
+	word := []rune{}
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if unicode.IsUpper(r) && len(word) > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// split camelCase & the end of an acronym, as in HTTPServer
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		word = append(word, r)
+	}
+	flush()
+	return strings.Join(words, "_")
+}
@@ -0,0 +1,251 @@
+package dedupe
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+
+	"github.com/comfforts/localstorage/pkg/extsort"
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	ERROR_MISSING_FIELDS string = "missing dedupe key fields"
+	ERROR_ENCODING_KEY   string = "error encoding dedupe key"
+)
+
+// SEQ_FIELD is the reserved field holding input position of spilled records
+const SEQ_FIELD string = "_dedupe_seq"
+
+type Policy int
+
+const (
+	// FIRST_WINS keeps the first record seen for a key
+	FIRST_WINS Policy = iota
+	// LAST_WINS keeps the last record seen for a key
+	LAST_WINS
+)
+
+type Config struct {
+	// Fields make up the dedupe key, e.g. entity_num & last name
+	Fields []string
+	Policy Policy
+	// MemoryBudget, if set, dedupes by spilling sorted runs to disk within the budget,
+	// output is then ordered by key fields, otherwise records are hashed in memory
+	MemoryBudget int64
+	// TempDir is directory for spilled runs, defaults to os temp dir
+	TempDir string
+	// WrapRun & UnwrapRun, if set, wrap spilled run writers & readers, see extsort.Config
+	WrapRun   func(w io.Writer) (io.Writer, func() error, error)
+	UnwrapRun func(r io.Reader) (io.Reader, error)
+}
+
+type Deduper struct {
+	config Config
+	logger logger.AppLogger
+}
+
+func NewDeduper(cfg Config, logger logger.AppLogger) (*Deduper, error) {
+	if logger == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	if len(cfg.Fields) < 1 {
+		return nil, errors.NewAppError(ERROR_MISSING_FIELDS)
+	}
+	return &Deduper{
+		config: cfg,
+		logger: logger,
+	}, nil
+}
+
+// Dedupe takes context, JSONMapper in chan, out chan & err chan
+// drops records with repeated key fields as per policy,
+// sends remaining records to out chan & errors to err chan
+// closes out and err channels on done
+func (d *Deduper) Dedupe(ctx context.Context, inCh <-chan models.JSONMapper, outCh chan models.JSONMapper, errCh chan error) {
+	if d.config.MemoryBudget > 0 {
+		d.dedupeSpilled(ctx, inCh, outCh, errCh)
+		return
+	}
+	d.dedupeInMemory(ctx, inCh, outCh, errCh)
+}
+
+// dedupeInMemory keeps a hash of seen keys, first wins streams records through,
+// last wins holds latest record per key & emits them in order of first appearance
+func (d *Deduper) dedupeInMemory(ctx context.Context, inCh <-chan models.JSONMapper, outCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(outCh)
+		close(errCh)
+	}()
+
+	seen := map[string]int{}
+	latest := []models.JSONMapper{}
+	for {
+		var r models.JSONMapper
+		select {
+		case <-ctx.Done():
+			return
+		case rec, ok := <-inCh:
+			if !ok {
+				for _, r := range latest {
+					select {
+					case <-ctx.Done():
+						return
+					case outCh <- r:
+					}
+				}
+				return
+			}
+			r = rec
+		}
+
+		key, err := d.key(r)
+		if err != nil {
+			errCh <- err
+			continue
+		}
+
+		i, ok := seen[key]
+		if d.config.Policy == LAST_WINS {
+			if ok {
+				latest[i] = r
+			} else {
+				seen[key] = len(latest)
+				latest = append(latest, r)
+			}
+			continue
+		}
+
+		if ok {
+			continue
+		}
+		seen[key] = 0
+		select {
+		case <-ctx.Done():
+			return
+		case outCh <- r:
+		}
+	}
+}
+
+// dedupeSpilled tags records with input position, externally sorts them by key fields & position,
+// then keeps first or last record of each run of equal keys
+func (d *Deduper) dedupeSpilled(ctx context.Context, inCh <-chan models.JSONMapper, outCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(outCh)
+		close(errCh)
+	}()
+
+	sorter, err := extsort.NewSorter(extsort.Config{
+		Less:         extsort.ByKeys(append(append([]string{}, d.config.Fields...), SEQ_FIELD)...),
+		MemoryBudget: d.config.MemoryBudget,
+		TempDir:      d.config.TempDir,
+		WrapRun:      d.config.WrapRun,
+		UnwrapRun:    d.config.UnwrapRun,
+	}, d.logger)
+	if err != nil {
+		errCh <- err
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	taggedCh := make(chan models.JSONMapper)
+	go func() {
+		defer close(taggedCh)
+		for seq := 0; ; seq++ {
+			select {
+			case <-ctx.Done():
+				return
+			case r, ok := <-inCh:
+				if !ok {
+					return
+				}
+				tagged := make(models.JSONMapper, len(r)+1)
+				for k, v := range r {
+					tagged[k] = v
+				}
+				tagged[SEQ_FIELD] = seq
+				select {
+				case <-ctx.Done():
+					return
+				case taggedCh <- tagged:
+				}
+			}
+		}
+	}()
+
+	sortedCh := make(chan models.JSONMapper)
+	sortErrCh := make(chan error)
+	go sorter.Sort(ctx, taggedCh, sortedCh, sortErrCh)
+
+	var kept models.JSONMapper
+	emit := func() bool {
+		if kept == nil {
+			return true
+		}
+		delete(kept, SEQ_FIELD)
+		select {
+		case <-ctx.Done():
+			return false
+		case outCh <- kept:
+			return true
+		}
+	}
+
+	for sortedCh != nil || sortErrCh != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-sortErrCh:
+			if !ok {
+				sortErrCh = nil
+				continue
+			}
+			errCh <- err
+		case r, ok := <-sortedCh:
+			if !ok {
+				sortedCh = nil
+				continue
+			}
+			if kept != nil && d.sameKey(kept, r) {
+				// sorted by position within key, later records win for last wins
+				if d.config.Policy == LAST_WINS {
+					kept = r
+				}
+				continue
+			}
+			if !emit() {
+				return
+			}
+			kept = r
+		}
+	}
+	emit()
+}
+
+// key returns record's dedupe key, in memory & spilled dedupes match keys of equal values alike
+func (d *Deduper) key(r models.JSONMapper) (string, error) {
+	vals := make([]string, len(d.config.Fields))
+	for i, f := range d.config.Fields {
+		vals[i] = extsort.Key(r[f])
+	}
+	data, err := json.Marshal(vals)
+	if err != nil {
+		return "", errors.WrapError(err, ERROR_ENCODING_KEY)
+	}
+	return string(data), nil
+}
+
+func (d *Deduper) sameKey(a, b models.JSONMapper) bool {
+	for _, f := range d.config.Fields {
+		if extsort.Key(a[f]) != extsort.Key(b[f]) {
+			return false
+		}
+	}
+	return true
+}
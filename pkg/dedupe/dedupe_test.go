@@ -0,0 +1,125 @@
+package dedupe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const TEST_DIR = "data"
+
+func TestDedupe(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		policy Policy
+		budget int64
+		seqs   []int
+	}{
+		"in memory first wins succeeds": {FIRST_WINS, 0, []int{0, 1, 3}},
+		"in memory last wins succeeds":  {LAST_WINS, 0, []int{4, 5, 3}},
+		"spilled first wins succeeds":   {FIRST_WINS, 1, []int{3, 0, 1}},
+		"spilled last wins succeeds":    {LAST_WINS, 1, []int{3, 4, 5}},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			require.Equal(t, tc.seqs, testDedupe(t, tc.policy, tc.budget))
+		})
+	}
+}
+
+func TestDedupeKeyValues(t *testing.T) {
+	records := []models.JSONMapper{
+		{"id": 1, "seq": 0},
+		{"id": "1", "seq": 1},
+		{"id": 1.0, "seq": 2},
+		{"id": "a", "seq": 3},
+		{"id": nil, "seq": 4},
+	}
+	// in memory & spilled dedupes match equal values of different types alike
+	for _, budget := range []int64{0, 1} {
+		require.ElementsMatch(t, []int{0, 3, 4}, runDedupe(t, Config{
+			Fields:       []string{"id"},
+			MemoryBudget: budget,
+		}, records), "budget %d", budget)
+	}
+}
+
+func testDedupe(t *testing.T, policy Policy, budget int64) []int {
+	records := []models.JSONMapper{
+		{"entity_num": "C0001", "LastName": "Wong"},
+		{"entity_num": "C0002", "LastName": "Wong"},
+		{"entity_num": "C0001", "LastName": "Wong"},
+		{"entity_num": "C0001", "LastName": "Chan"},
+		{"entity_num": "C0001", "LastName": "Wong"},
+		{"entity_num": "C0002", "LastName": "Wong"},
+	}
+	for i, r := range records {
+		r["seq"] = i
+	}
+	return runDedupe(t, Config{
+		Fields:       []string{"entity_num", "LastName"},
+		Policy:       policy,
+		MemoryBudget: budget,
+	}, records)
+}
+
+// runDedupe dedupes records as per cfg & returns seq field of deduped records
+func runDedupe(t *testing.T, cfg Config, records []models.JSONMapper) []int {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	tmpDir := filepath.Join(TEST_DIR, "runs")
+	err := os.MkdirAll(tmpDir, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	cfg.TempDir = tmpDir
+	deduper, err := NewDeduper(cfg, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inCh := make(chan models.JSONMapper)
+	outCh := make(chan models.JSONMapper)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(inCh)
+		for _, r := range records {
+			inCh <- r
+		}
+	}()
+	go deduper.Dedupe(ctx, inCh, outCh, errCh)
+
+	seqs := []int{}
+	for outCh != nil || errCh != nil {
+		select {
+		case r, ok := <-outCh:
+			if !ok {
+				outCh = nil
+				continue
+			}
+			_, tagged := r[SEQ_FIELD]
+			require.Equal(t, false, tagged)
+			switch seq := r["seq"].(type) {
+			case int:
+				seqs = append(seqs, seq)
+			case float64:
+				seqs = append(seqs, int(seq))
+			}
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+	return seqs
+}
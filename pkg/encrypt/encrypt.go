@@ -0,0 +1,360 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/comfforts/errors"
+)
+
+const (
+	ERROR_BAD_KEY       string = "encryption key %s must be 32 bytes"
+	ERROR_UNKNOWN_KEY   string = "unknown encryption key %s"
+	ERROR_BAD_HEADER    string = "invalid encryption header: %s"
+	ERROR_ENCRYPTING    string = "encrypting chunk %d"
+	ERROR_DECRYPTING    string = "decrypting chunk %d"
+	ERROR_TRUNCATED     string = "encrypted stream truncated after chunk %d"
+	ERROR_TOO_MANY      string = "encrypted stream exceeds chunk limit"
+	ERROR_WRITER_CLOSED string = "encrypting writer closed"
+)
+
+const (
+	// MAGIC starts encrypted files
+	MAGIC   string = "LSENC"
+	VERSION byte   = 2
+	// KEY_SIZE is AES-256 key size
+	KEY_SIZE           int = 32
+	DEFAULT_CHUNK_SIZE int = 64 * 1024
+	// MAX_CHUNK_SIZE bounds chunk buffers of read headers
+	MAX_CHUNK_SIZE int = 16 * 1024 * 1024
+	// SALT_SIZE is size of random per file salt, files are encrypted with a key
+	// derived from provider's key & salt, so chunk nonces are just the
+	// 4 byte chunk counter & last chunk flag
+	SALT_SIZE int = 32
+)
+
+// KeyProvider supplies AES-256 keys, by id, e.g. from a KMS or secrets store
+type KeyProvider interface {
+	// EncryptionKey returns id & key encrypting new files
+	EncryptionKey(ctx context.Context) (id string, key []byte, err error)
+	// DecryptionKey returns key of given id, for reading files encrypted with it
+	DecryptionKey(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeys provides keys held in memory, new files are encrypted with key ID,
+// older keys are kept for reading files encrypted before rotation
+type StaticKeys struct {
+	ID   string
+	Keys map[string][]byte
+}
+
+var _ KeyProvider = StaticKeys{}
+
+func (s StaticKeys) EncryptionKey(ctx context.Context) (string, []byte, error) {
+	key, err := s.DecryptionKey(ctx, s.ID)
+	return s.ID, key, err
+}
+
+func (s StaticKeys) DecryptionKey(ctx context.Context, id string) ([]byte, error) {
+	key, ok := s.Keys[id]
+	if !ok {
+		return nil, errors.NewAppError(ERROR_UNKNOWN_KEY, id)
+	}
+	return key, nil
+}
+
+type Config struct {
+	// Keys, if set, encrypts written & copied files & decrypts read ones
+	Keys KeyProvider
+	// ChunkSize is plaintext size of encrypted chunks, defaults to DEFAULT_CHUNK_SIZE
+	ChunkSize int
+}
+
+// header is encrypted stream's header, authenticated with every chunk
+type header struct {
+	chunkSize int
+	salt      []byte
+	keyID     string
+	raw       []byte
+}
+
+func (h *header) encode() {
+	var b bytes.Buffer
+	b.WriteString(MAGIC)
+	b.WriteByte(VERSION)
+	binary.Write(&b, binary.BigEndian, uint32(h.chunkSize))
+	b.Write(h.salt)
+	b.WriteByte(byte(len(h.keyID)))
+	b.WriteString(h.keyID)
+	h.raw = b.Bytes()
+}
+
+func readHeader(r io.Reader) (*header, error) {
+	fixed := make([]byte, len(MAGIC)+1+4+SALT_SIZE+1)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, errors.WrapError(err, ERROR_BAD_HEADER, "short header")
+	}
+	if string(fixed[:len(MAGIC)]) != MAGIC {
+		return nil, errors.NewAppError(ERROR_BAD_HEADER, "not encrypted")
+	}
+	i := len(MAGIC)
+	if fixed[i] != VERSION {
+		return nil, errors.NewAppError(ERROR_BAD_HEADER, "unsupported version")
+	}
+	i++
+	h := &header{chunkSize: int(binary.BigEndian.Uint32(fixed[i:]))}
+	if h.chunkSize <= 0 || h.chunkSize > MAX_CHUNK_SIZE {
+		return nil, errors.NewAppError(ERROR_BAD_HEADER, "bad chunk size")
+	}
+	i += 4
+	h.salt = fixed[i : i+SALT_SIZE]
+	keyID := make([]byte, fixed[len(fixed)-1])
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		return nil, errors.WrapError(err, ERROR_BAD_HEADER, "short key id")
+	}
+	h.raw = append(fixed, keyID...)
+	h.keyID = string(keyID)
+	return h, nil
+}
+
+// newAEAD returns cipher of header's file, keyed by key derived from given key & header's salt
+func newAEAD(h *header, key []byte) (cipher.AEAD, error) {
+	if len(key) != KEY_SIZE {
+		return nil, errors.NewAppError(ERROR_BAD_KEY, h.keyID)
+	}
+	block, err := aes.NewCipher(deriveKey(key, h.salt, h.raw))
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_BAD_KEY, h.keyID)
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey derives KEY_SIZE bytes key from secret with HKDF-SHA256 (RFC 5869)
+func deriveKey(secret, salt, info []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	// one expand block is a KEY_SIZE key
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:KEY_SIZE]
+}
+
+// nonce returns chunk's nonce into buf, zero padded chunk counter & last chunk flag
+func nonce(buf []byte, chunk uint32, last bool) []byte {
+	for i := range buf {
+		buf[i] = 0
+	}
+	binary.BigEndian.PutUint32(buf[len(buf)-5:], chunk)
+	if last {
+		buf[len(buf)-1] = 1
+	}
+	return buf
+}
+
+// Writer encrypts written data in chunks, Close writes final chunk
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header *header
+	buf    []byte
+	out    []byte
+	nonce  []byte
+	chunk  uint32
+	closed bool
+}
+
+// NewWriter returns writer encrypting into w with provider's encryption key,
+// writes header to w, chunkSize defaults to DEFAULT_CHUNK_SIZE
+func NewWriter(ctx context.Context, w io.Writer, keys KeyProvider, chunkSize int) (*Writer, error) {
+	if keys == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	if chunkSize <= 0 {
+		chunkSize = DEFAULT_CHUNK_SIZE
+	}
+	if chunkSize > MAX_CHUNK_SIZE {
+		chunkSize = MAX_CHUNK_SIZE
+	}
+	id, key, err := keys.EncryptionKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > math.MaxUint8 {
+		return nil, errors.NewAppError(ERROR_BAD_HEADER, "key id too long")
+	}
+	h := &header{chunkSize: chunkSize, salt: make([]byte, SALT_SIZE), keyID: id}
+	if _, err := rand.Read(h.salt); err != nil {
+		return nil, errors.WrapError(err, ERROR_ENCRYPTING, 0)
+	}
+	h.encode()
+	aead, err := newAEAD(h, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(h.raw); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:      w,
+		aead:   aead,
+		header: h,
+		buf:    make([]byte, 0, chunkSize),
+		out:    make([]byte, 0, chunkSize+aead.Overhead()),
+		nonce:  make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.NewAppError(ERROR_WRITER_CLOSED)
+	}
+	n := 0
+	for len(p) > 0 {
+		// full chunk is sealed once more data follows, last chunk is sealed on close
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close writes final chunk, doesn't close underlying writer
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+func (w *Writer) seal(last bool) error {
+	if w.chunk == math.MaxUint32 {
+		return errors.NewAppError(ERROR_TOO_MANY)
+	}
+	w.out = w.aead.Seal(w.out[:0], nonce(w.nonce, w.chunk, last), w.buf, w.header.raw)
+	if _, err := w.w.Write(w.out); err != nil {
+		return errors.WrapError(err, ERROR_ENCRYPTING, w.chunk)
+	}
+	w.buf = w.buf[:0]
+	w.chunk++
+	return nil
+}
+
+// Reader decrypts encrypted stream, chunks are authenticated before they're read
+type Reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header *header
+	in     []byte
+	out    []byte
+	plain  []byte
+	nonce  []byte
+	chunk  uint32
+	done   bool
+	// err is first decryption error, returned by every later read
+	err error
+}
+
+// NewReader reads r's header & returns reader decrypting it with provider's key of header's key id
+func NewReader(ctx context.Context, r io.Reader, keys KeyProvider) (*Reader, error) {
+	if keys == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	h, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys.DecryptionKey(ctx, h.keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(h, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		header: h,
+		in:     make([]byte, h.chunkSize+aead.Overhead()),
+		out:    make([]byte, 0, h.chunkSize),
+		nonce:  make([]byte, aead.NonceSize()),
+	}, nil
+}
+
+// Read reads decrypted data, once a chunk fails to decrypt, reads return its error
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.open()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// open reads & decrypts next chunk, a short chunk or one followed by end of stream is last
+func (r *Reader) open() error {
+	n, err := io.ReadFull(r.r, r.in)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err == io.EOF:
+		return errors.NewAppError(ERROR_TRUNCATED, r.chunk)
+	case err != nil:
+		return errors.WrapError(err, ERROR_DECRYPTING, r.chunk)
+	default:
+		if _, err := r.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+	plain, err := r.aead.Open(r.out[:0], nonce(r.nonce, r.chunk, last), r.in[:n], r.header.raw)
+	if err != nil {
+		if last {
+			// a non last chunk at end of stream, stream was cut off after it
+			_, err := r.aead.Open(r.out[:0], nonce(r.nonce, r.chunk, false), r.in[:n], r.header.raw)
+			if err == nil {
+				return errors.NewAppError(ERROR_TRUNCATED, r.chunk)
+			}
+		}
+		return errors.NewAppError(ERROR_DECRYPTING, r.chunk)
+	}
+	r.plain, r.done = plain, last
+	r.chunk++
+	return nil
+}
+
+// Detect reports if r starts with encryption header magic, seeking back to its start
+func Detect(r io.ReadSeeker) (bool, error) {
+	magic := make([]byte, len(MAGIC))
+	n, err := io.ReadFull(r, magic)
+	if _, serr := r.Seek(0, io.SeekStart); serr != nil {
+		return false, serr
+	}
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return n == len(MAGIC) && string(magic) == MAGIC, nil
+}
@@ -0,0 +1,176 @@
+package encrypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testKeys(t *testing.T, ids ...string) StaticKeys {
+	keys := StaticKeys{ID: ids[0], Keys: map[string][]byte{}}
+	for _, id := range ids {
+		key := make([]byte, KEY_SIZE)
+		_, err := rand.Read(key)
+		require.NoError(t, err)
+		keys.Keys[id] = key
+	}
+	return keys
+}
+
+func encrypt(t *testing.T, keys KeyProvider, chunkSize int, plain []byte) []byte {
+	var b bytes.Buffer
+	w, err := NewWriter(context.Background(), &b, keys, chunkSize)
+	require.NoError(t, err)
+	// uneven writes span chunks
+	for len(plain) > 0 {
+		n := 7
+		if n > len(plain) {
+			n = len(plain)
+		}
+		_, err := w.Write(plain[:n])
+		require.NoError(t, err)
+		plain = plain[n:]
+	}
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+	_, err = w.Write([]byte("late"))
+	require.Error(t, err)
+	return b.Bytes()
+}
+
+func decrypt(keys KeyProvider, data []byte) ([]byte, error) {
+	r, err := NewReader(context.Background(), bytes.NewReader(data), keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	keys := testKeys(t, "k1")
+	const chunkSize = 16
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize, 3*chunkSize + 5} {
+		plain := make([]byte, size)
+		_, err := rand.Read(plain)
+		require.NoError(t, err)
+
+		data := encrypt(t, keys, chunkSize, plain)
+		if size >= chunkSize {
+			require.NotContains(t, string(data), string(plain))
+		}
+		got, err := decrypt(keys, data)
+		require.NoError(t, err, "size %d", size)
+		require.Equal(t, plain, append([]byte{}, got...), "size %d", size)
+	}
+}
+
+func TestTampering(t *testing.T) {
+	keys := testKeys(t, "k1")
+	const chunkSize = 16
+	plain := bytes.Repeat([]byte("principal data "), 5)
+	data := encrypt(t, keys, chunkSize, plain)
+	headerSize := len(MAGIC) + 1 + 4 + SALT_SIZE + 1 + len("k1")
+	chunk := chunkSize + 16
+
+	flipped := append([]byte{}, data...)
+	flipped[headerSize+chunk+3] ^= 1
+	_, err := decrypt(keys, flipped)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "decrypting chunk 1")
+
+	// header is authenticated
+	flipped = append([]byte{}, data...)
+	flipped[len(MAGIC)+1+4] ^= 1
+	_, err = decrypt(keys, flipped)
+	require.Error(t, err)
+
+	// cut at chunk boundary
+	_, err = decrypt(keys, data[:headerSize+2*chunk])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "truncated after chunk 1")
+
+	// failed reads don't read on past failed chunk
+	flipped = append([]byte{}, data...)
+	flipped[headerSize+chunk+3] ^= 1
+	r, err := NewReader(context.Background(), bytes.NewReader(flipped), keys)
+	require.NoError(t, err)
+	buf := make([]byte, chunkSize)
+	_, err = io.ReadFull(r, buf)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, rerr := r.Read(buf)
+		require.Error(t, rerr)
+		require.Contains(t, rerr.Error(), "decrypting chunk 1")
+	}
+	_, err = decrypt(keys, data[:headerSize])
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "truncated after chunk 0")
+
+	// swapped chunks
+	swapped := append([]byte{}, data[:headerSize]...)
+	swapped = append(swapped, data[headerSize+chunk:headerSize+2*chunk]...)
+	swapped = append(swapped, data[headerSize:headerSize+chunk]...)
+	swapped = append(swapped, data[headerSize+2*chunk:]...)
+	_, err = decrypt(keys, swapped)
+	require.Error(t, err)
+}
+
+func TestDeriveKey(t *testing.T) {
+	// RFC 5869 test case 1, first KEY_SIZE bytes of output
+	secret, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	require.Equal(t, "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf", hex.EncodeToString(deriveKey(secret, salt, info)))
+
+	// files of same key & plaintext have own salts, so own keys & ciphertexts
+	keys := testKeys(t, "k1")
+	plain := []byte("same plaintext, same key")
+	a, b := encrypt(t, keys, 16, plain), encrypt(t, keys, 16, plain)
+	headerSize := len(MAGIC) + 1 + 4 + SALT_SIZE + 1 + len("k1")
+	require.NotEqual(t, a[len(MAGIC)+5:len(MAGIC)+5+SALT_SIZE], b[len(MAGIC)+5:len(MAGIC)+5+SALT_SIZE])
+	require.NotEqual(t, a[headerSize:], b[headerSize:])
+}
+
+func TestKeys(t *testing.T) {
+	old := testKeys(t, "k1")
+	data := encrypt(t, old, 0, []byte("hello"))
+
+	// rotated keys still read older files
+	rotated := testKeys(t, "k2")
+	rotated.Keys["k1"] = old.Keys["k1"]
+	got, err := decrypt(rotated, data)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(got))
+
+	_, err = decrypt(testKeys(t, "k2"), data)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown encryption key k1")
+
+	_, err = NewWriter(context.Background(), io.Discard, StaticKeys{ID: "short", Keys: map[string][]byte{"short": []byte("key")}}, 0)
+	require.Error(t, err)
+	_, err = NewWriter(context.Background(), io.Discard, nil, 0)
+	require.Error(t, err)
+}
+
+func TestDetect(t *testing.T) {
+	data := encrypt(t, testKeys(t, "k1"), 0, []byte("hello"))
+	for in, encrypted := range map[string]bool{
+		string(data): true,
+		`[{"a": 1}]`: false,
+		"LS":         false,
+		"":           false,
+	} {
+		r := bytes.NewReader([]byte(in))
+		ok, err := Detect(r)
+		require.NoError(t, err)
+		require.Equal(t, encrypted, ok)
+		pos, err := r.Seek(0, io.SeekCurrent)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), pos)
+	}
+}
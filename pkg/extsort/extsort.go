@@ -0,0 +1,403 @@
+package extsort
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	ERROR_MISSING_LESS    string = "missing sort comparison"
+	ERROR_CREATING_RUN    string = "creating sort run file"
+	ERROR_WRITING_RUN     string = "writing sort run file %s"
+	ERROR_READING_RUN     string = "reading sort run file %s"
+	ERROR_ENCODING_RECORD string = "error encoding sort record"
+)
+
+// DEFAULT_MEMORY_BUDGET is the default approximate number of bytes of records held in memory
+const DEFAULT_MEMORY_BUDGET int64 = 64 << 20
+
+// recordOverhead approximates per record map overhead on top of its encoded size
+const recordOverhead int64 = 64
+
+// Less reports whether record a sorts before record b
+type Less func(a, b models.JSONMapper) bool
+
+// ByKeys returns ascending comparison on given record fields, in order,
+// numbers & numeric strings, e.g. csv fields, compare numerically & sort before other values,
+// other values compare by their string form, missing values first
+func ByKeys(keys ...string) Less {
+	return func(a, b models.JSONMapper) bool {
+		for _, k := range keys {
+			if c := Compare(a[k], b[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	}
+}
+
+// Compare compares two record values, returns -1, 0 or 1
+func Compare(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	af, aok := toFloat(a)
+	bf, bok := toFloat(b)
+	switch {
+	case aok && bok:
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		default:
+			return 0
+		}
+	case aok:
+		// numbers sort before other values, keeping the order total
+		return -1
+	case bok:
+		return 1
+	}
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	switch {
+	case as < bs:
+		return -1
+	case as > bs:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Key returns value's canonical form, values compare equal iff their keys are equal
+func Key(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if f, ok := toFloat(v); ok {
+		// adding zero folds negative zero into zero
+		return "n:" + strconv.FormatFloat(f+0, 'g', -1, 64)
+	}
+	return "s:" + fmt.Sprint(v)
+}
+
+type Config struct {
+	// Less defines sort order
+	Less Less
+	// MemoryBudget is approximate number of bytes of records held in memory before spilling a run
+	MemoryBudget int64
+	// TempDir is directory for run files, defaults to os temp dir
+	TempDir string
+	// WrapRun, if set, wraps run file writers, e.g. encrypting spilled records,
+	// returned close func flushes wrapped writer
+	WrapRun func(w io.Writer) (io.Writer, func() error, error)
+	// UnwrapRun, if set, wraps run file readers, reversing WrapRun
+	UnwrapRun func(r io.Reader) (io.Reader, error)
+}
+
+type Sorter struct {
+	config Config
+	logger logger.AppLogger
+}
+
+func NewSorter(cfg Config, logger logger.AppLogger) (*Sorter, error) {
+	if logger == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	if cfg.Less == nil {
+		return nil, errors.NewAppError(ERROR_MISSING_LESS)
+	}
+	if cfg.MemoryBudget <= 0 {
+		cfg.MemoryBudget = DEFAULT_MEMORY_BUDGET
+	}
+	return &Sorter{
+		config: cfg,
+		logger: logger,
+	}, nil
+}
+
+// Sort takes context, JSONMapper in chan, out chan & err chan
+// buffers records from in chan up to memory budget, spills sorted runs to temp files,
+// k-way merges runs & sends sorted records to out chan, errors to err chan
+// closes out and err channels on done
+func (s *Sorter) Sort(ctx context.Context, inCh <-chan models.JSONMapper, outCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(outCh)
+		close(errCh)
+	}()
+
+	runs := []string{}
+	defer func() {
+		for _, r := range runs {
+			if err := os.Remove(r); err != nil {
+				s.logger.Error("error removing sort run", zap.Error(err), zap.String("run", r))
+			}
+		}
+	}()
+
+	buf := []models.JSONMapper{}
+	var bufSize int64
+	for {
+		var (
+			r  models.JSONMapper
+			ok bool
+		)
+		select {
+		case <-ctx.Done():
+			return
+		case r, ok = <-inCh:
+		}
+		if !ok {
+			break
+		}
+
+		size, err := recordSize(r)
+		if err != nil {
+			errCh <- err
+			continue
+		}
+		buf = append(buf, r)
+		bufSize = bufSize + size
+
+		if bufSize >= s.config.MemoryBudget {
+			run, err := s.spill(buf)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			runs = append(runs, run)
+			buf = []models.JSONMapper{}
+			bufSize = 0
+		}
+	}
+
+	// everything fit in memory
+	if len(runs) == 0 {
+		sort.SliceStable(buf, func(i, j int) bool { return s.config.Less(buf[i], buf[j]) })
+		for _, r := range buf {
+			select {
+			case <-ctx.Done():
+				return
+			case outCh <- r:
+			}
+		}
+		return
+	}
+
+	if len(buf) > 0 {
+		run, err := s.spill(buf)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		runs = append(runs, run)
+	}
+
+	s.logger.Info("merging sort runs", zap.Int("runs", len(runs)))
+	if err := s.merge(ctx, runs, outCh); err != nil {
+		errCh <- err
+	}
+}
+
+// spill sorts buffered records & writes them as a json lines run file, wrapped by WrapRun if set
+func (s *Sorter) spill(buf []models.JSONMapper) (string, error) {
+	sort.SliceStable(buf, func(i, j int) bool { return s.config.Less(buf[i], buf[j]) })
+
+	f, err := os.CreateTemp(s.config.TempDir, "extsort-run-*.jsonl")
+	if err != nil {
+		return "", errors.WrapError(err, ERROR_CREATING_RUN)
+	}
+
+	var rw io.Writer = f
+	closeRun := func() error { return nil }
+	if s.config.WrapRun != nil {
+		rw, closeRun, err = s.config.WrapRun(f)
+		if err != nil {
+			f.Close()
+			return f.Name(), errors.WrapError(err, ERROR_WRITING_RUN, f.Name())
+		}
+	}
+
+	w := bufio.NewWriter(rw)
+	enc := json.NewEncoder(w)
+	for _, r := range buf {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return f.Name(), errors.WrapError(err, ERROR_WRITING_RUN, f.Name())
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return f.Name(), errors.WrapError(err, ERROR_WRITING_RUN, f.Name())
+	}
+	if err := closeRun(); err != nil {
+		f.Close()
+		return f.Name(), errors.WrapError(err, ERROR_WRITING_RUN, f.Name())
+	}
+	if err := f.Close(); err != nil {
+		return f.Name(), errors.WrapError(err, ERROR_WRITING_RUN, f.Name())
+	}
+	s.logger.Info("spilled sort run", zap.String("run", f.Name()), zap.Int("records", len(buf)))
+	return f.Name(), nil
+}
+
+// merge k-way merges sorted run files into out chan
+func (s *Sorter) merge(ctx context.Context, runs []string, outCh chan models.JSONMapper) error {
+	h := &runHeap{less: s.config.Less}
+	for i, r := range runs {
+		f, err := os.Open(r)
+		if err != nil {
+			return errors.WrapError(err, ERROR_READING_RUN, r)
+		}
+		defer f.Close()
+
+		var src io.Reader = f
+		if s.config.UnwrapRun != nil {
+			if src, err = s.config.UnwrapRun(f); err != nil {
+				return errors.WrapError(err, ERROR_READING_RUN, r)
+			}
+		}
+
+		rr := &runReader{
+			index: i,
+			name:  r,
+			dec:   json.NewDecoder(bufio.NewReader(src)),
+		}
+		ok, err := rr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.readers = append(h.readers, rr)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		rr := h.readers[0]
+		select {
+		case <-ctx.Done():
+			return nil
+		case outCh <- rr.head:
+		}
+
+		ok, err := rr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+type runReader struct {
+	index int
+	name  string
+	dec   *json.Decoder
+	head  models.JSONMapper
+}
+
+func (rr *runReader) next() (bool, error) {
+	var r models.JSONMapper
+	if err := rr.dec.Decode(&r); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, errors.WrapError(err, ERROR_READING_RUN, rr.name)
+	}
+	rr.head = r
+	return true, nil
+}
+
+// runHeap orders run readers by their head record, ties by run index to keep sort stable
+type runHeap struct {
+	readers []*runReader
+	less    Less
+}
+
+func (h runHeap) Len() int { return len(h.readers) }
+func (h runHeap) Less(i, j int) bool {
+	a, b := h.readers[i], h.readers[j]
+	if h.less(a.head, b.head) {
+		return true
+	}
+	if h.less(b.head, a.head) {
+		return false
+	}
+	return a.index < b.index
+}
+func (h runHeap) Swap(i, j int)       { h.readers[i], h.readers[j] = h.readers[j], h.readers[i] }
+func (h *runHeap) Push(x interface{}) { h.readers = append(h.readers, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := h.readers
+	n := len(old)
+	rr := old[n-1]
+	h.readers = old[:n-1]
+	return rr
+}
+
+func recordSize(r models.JSONMapper) (int64, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return 0, errors.WrapError(err, ERROR_ENCODING_RECORD)
+	}
+	return int64(len(data)) + recordOverhead, nil
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, false
+		}
+		return f, true
+	}
+	return 0, false
+}
@@ -0,0 +1,207 @@
+package extsort
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const TEST_DIR = "data"
+
+func TestSort(t *testing.T) {
+	for scenario, budget := range map[string]int64{
+		"in memory sort succeeds":           DEFAULT_MEMORY_BUDGET,
+		"spilled runs merge succeeds":       1024,
+		"single record runs merge succeeds": 1,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testSort(t, budget)
+		})
+	}
+}
+
+func testSort(t *testing.T, budget int64) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	tmpDir := filepath.Join(TEST_DIR, "runs")
+	err := os.MkdirAll(tmpDir, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	sorter, err := NewSorter(Config{
+		Less:         ByKeys("entity_num", "last_name"),
+		MemoryBudget: budget,
+		TempDir:      tmpDir,
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inCh := make(chan models.JSONMapper)
+	outCh := make(chan models.JSONMapper)
+	errCh := make(chan error)
+
+	count := 100
+	go func() {
+		defer close(inCh)
+		for i := 0; i < count; i++ {
+			inCh <- models.JSONMapper{
+				"entity_num": fmt.Sprintf("C%04d", (i*37)%50),
+				"last_name":  fmt.Sprintf("name-%d", i%2),
+				"seq":        i,
+			}
+		}
+	}()
+	go sorter.Sort(ctx, inCh, outCh, errCh)
+
+	sorted := []models.JSONMapper{}
+	for outCh != nil || errCh != nil {
+		select {
+		case r, ok := <-outCh:
+			if !ok {
+				outCh = nil
+				continue
+			}
+			sorted = append(sorted, r)
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+
+	require.Equal(t, count, len(sorted))
+	less := ByKeys("entity_num", "last_name")
+	for i := 1; i < len(sorted); i++ {
+		require.Equal(t, false, less(sorted[i], sorted[i-1]))
+	}
+
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(entries))
+}
+
+// xorWriter & xorReader mask run bytes, standing in for run encryption
+type xorWriter struct{ w io.Writer }
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	return x.w.Write(xor(append([]byte{}, p...)))
+}
+
+func xor(p []byte) []byte {
+	for i := range p {
+		p[i] ^= 0x5a
+	}
+	return p
+}
+
+func TestSortWrappedRuns(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	tmpDir := filepath.Join(TEST_DIR, "runs")
+	err := os.MkdirAll(tmpDir, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	wrapped, closed := 0, 0
+	sorter, err := NewSorter(Config{
+		Less:         ByKeys("entity_num"),
+		MemoryBudget: 64,
+		TempDir:      tmpDir,
+		WrapRun: func(w io.Writer) (io.Writer, func() error, error) {
+			wrapped++
+			return xorWriter{w}, func() error { closed++; return nil }, nil
+		},
+		UnwrapRun: func(r io.Reader) (io.Reader, error) {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			// run files hold wrapped bytes only
+			require.NotContains(t, string(data), "entity_num")
+			return bytes.NewReader(xor(data)), nil
+		},
+	}, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inCh := make(chan models.JSONMapper)
+	outCh := make(chan models.JSONMapper)
+	errCh := make(chan error)
+	count := 20
+	go func() {
+		defer close(inCh)
+		for i := 0; i < count; i++ {
+			inCh <- models.JSONMapper{"entity_num": fmt.Sprintf("C%04d", (i*7)%count)}
+		}
+	}()
+	go sorter.Sort(ctx, inCh, outCh, errCh)
+
+	sorted := []models.JSONMapper{}
+	for outCh != nil || errCh != nil {
+		select {
+		case r, ok := <-outCh:
+			if !ok {
+				outCh = nil
+				continue
+			}
+			sorted = append(sorted, r)
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+
+	require.Equal(t, count, len(sorted))
+	for i, r := range sorted {
+		require.Equal(t, fmt.Sprintf("C%04d", i), r["entity_num"])
+	}
+	require.Greater(t, wrapped, 1)
+	require.Equal(t, wrapped, closed)
+}
+
+func TestCompare(t *testing.T) {
+	require.Equal(t, -1, Compare(nil, "a"))
+	require.Equal(t, -1, Compare(2, 10.5))
+	require.Equal(t, 1, Compare("b", "a"))
+	require.Equal(t, 0, Compare(float64(3), 3))
+	// numeric strings, e.g. csv fields, compare numerically
+	require.Equal(t, 1, Compare("10", "9"))
+	require.Equal(t, 0, Compare(" 1.0", 1))
+	require.Equal(t, -1, Compare("-2", "1e1"))
+	// numbers sort before other values
+	require.Equal(t, -1, Compare("10", "9a"))
+	require.Equal(t, 1, Compare("NaN", 5))
+}
+
+func TestKey(t *testing.T) {
+	for _, pair := range [][2]interface{}{{1, "1"}, {"1.0", 1.0}, {-0.0, "0"}, {nil, nil}, {"a", "a"}} {
+		require.Equal(t, 0, Compare(pair[0], pair[1]))
+		require.Equal(t, Key(pair[0]), Key(pair[1]))
+	}
+	for _, pair := range [][2]interface{}{{1, "a"}, {nil, ""}, {"1", "2"}} {
+		require.NotEqual(t, 0, Compare(pair[0], pair[1]))
+		require.NotEqual(t, Key(pair[0]), Key(pair[1]))
+	}
+}
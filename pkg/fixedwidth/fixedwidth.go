@@ -0,0 +1,499 @@
+package fixedwidth
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	ERR_FILE            string = "%s doesn't exist"
+	ERR_NO_FILE         string = "file doesn't exist"
+	ERR_LAYOUT_FILE     string = "reading layout %s"
+	ERR_EMPTY_LAYOUT    string = "layout has no columns"
+	ERR_COLUMN_NAME     string = "column %d missing name"
+	ERR_COLUMN_BOUNDS   string = "column %s has invalid start %d or width %d"
+	ERR_COLUMN_OVERLAP  string = "column %s overlaps column %s"
+	ERR_COLUMN_TYPE     string = "column %s has unsupported type %s"
+	ERR_FW_LINE         string = "error reading fixed width line %d"
+	ERR_FW_VALUE        string = "error parsing column %s of line %d"
+	ERR_FW_WRITE        string = "error writing fixed width record"
+	ERR_FW_STRUCT       string = "error setting field %s"
+	ERR_FW_DECODE       string = "error decoding line %d"
+	ERR_FW_STRUCT_TYPE  string = "%s isn't a struct type"
+	ERR_FW_RECORD_WIDTH string = "record has %d values, layout has %d columns"
+)
+
+type ColumnType string
+
+const (
+	TYPE_STRING ColumnType = "string"
+	TYPE_INT    ColumnType = "int"
+	TYPE_FLOAT  ColumnType = "float"
+	TYPE_BOOL   ColumnType = "bool"
+)
+
+type Align string
+
+const (
+	// ALIGN_LEFT pads values on the right
+	ALIGN_LEFT Align = "left"
+	// ALIGN_RIGHT pads values on the left, as usual for numbers
+	ALIGN_RIGHT Align = "right"
+)
+
+// Column is a fixed width field, Start is the zero based character position in the line
+type Column struct {
+	Name  string     `json:"name"`
+	Start int        `json:"start"`
+	Width int        `json:"width"`
+	Type  ColumnType `json:"type,omitempty"`
+	// Trim strips padding from values read
+	Trim bool `json:"trim,omitempty"`
+	// Pad is the padding character, defaults to space
+	Pad   string `json:"pad,omitempty"`
+	Align Align  `json:"align,omitempty"`
+}
+
+func (c Column) padRune() rune {
+	if c.Pad == "" {
+		return ' '
+	}
+	r, _ := utf8.DecodeRuneInString(c.Pad)
+	return r
+}
+
+type Layout struct {
+	Columns []Column `json:"columns"`
+}
+
+// LoadLayout reads json layout spec file & validates it
+func LoadLayout(filePath string) (Layout, error) {
+	var l Layout
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return l, errors.WrapError(err, ERR_LAYOUT_FILE, filePath)
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return l, errors.WrapError(err, ERR_LAYOUT_FILE, filePath)
+	}
+	return l, l.Validate()
+}
+
+// Validate checks columns are named, typed & don't overlap
+func (l Layout) Validate() error {
+	if len(l.Columns) < 1 {
+		return errors.NewAppError(ERR_EMPTY_LAYOUT)
+	}
+	cols := append([]Column{}, l.Columns...)
+	for i, c := range cols {
+		if c.Name == "" {
+			return errors.NewAppError(ERR_COLUMN_NAME, i)
+		}
+		if c.Start < 0 || c.Width < 1 {
+			return errors.NewAppError(ERR_COLUMN_BOUNDS, c.Name, c.Start, c.Width)
+		}
+		switch c.Type {
+		case "", TYPE_STRING, TYPE_INT, TYPE_FLOAT, TYPE_BOOL:
+		default:
+			return errors.NewAppError(ERR_COLUMN_TYPE, c.Name, c.Type)
+		}
+	}
+	sort.Slice(cols, func(i, j int) bool { return cols[i].Start < cols[j].Start })
+	for i := 1; i < len(cols); i++ {
+		if cols[i].Start < cols[i-1].Start+cols[i-1].Width {
+			return errors.NewAppError(ERR_COLUMN_OVERLAP, cols[i].Name, cols[i-1].Name)
+		}
+	}
+	return nil
+}
+
+// Width returns line width of layout
+func (l Layout) Width() int {
+	w := 0
+	for _, c := range l.Columns {
+		if end := c.Start + c.Width; end > w {
+			w = end
+		}
+	}
+	return w
+}
+
+// Headers returns column names, in layout order
+func (l Layout) Headers() []string {
+	headers := make([]string, len(l.Columns))
+	for i, c := range l.Columns {
+		headers[i] = c.Name
+	}
+	return headers
+}
+
+// Split cuts line into column values, in layout order,
+// columns past the end of a short line are empty
+func (l Layout) Split(line string) []string {
+	runes := []rune(line)
+	row := make([]string, len(l.Columns))
+	for i, c := range l.Columns {
+		if c.Start >= len(runes) {
+			continue
+		}
+		end := c.Start + c.Width
+		if end > len(runes) {
+			end = len(runes)
+		}
+		v := string(runes[c.Start:end])
+		if c.Trim {
+			v = trimPad(v, c.padRune(), c.Align)
+		}
+		row[i] = v
+	}
+	return row
+}
+
+// Record converts split row into a record, typed per column,
+// empty typed values are nil
+func (l Layout) Record(row []string, lineNum int) (models.JSONMapper, error) {
+	r := models.JSONMapper{}
+	for i, c := range l.Columns {
+		v := ""
+		if i < len(row) {
+			v = row[i]
+		}
+		val, err := parseValue(c, v)
+		if err != nil {
+			return r, errors.WrapError(err, ERR_FW_VALUE, c.Name, lineNum)
+		}
+		r[c.Name] = val
+	}
+	return r, nil
+}
+
+// Format lays out row values, in layout order, into a fixed width line,
+// padding & truncating each value to its column width
+func (l Layout) Format(row []string) (string, error) {
+	if len(row) != len(l.Columns) {
+		return "", errors.NewAppError(ERR_FW_RECORD_WIDTH, len(row), len(l.Columns))
+	}
+	line := []rune(strings.Repeat(" ", l.Width()))
+	for i, c := range l.Columns {
+		v := []rune(row[i])
+		if len(v) > c.Width {
+			v = v[:c.Width]
+		}
+		pad := []rune(strings.Repeat(string(c.padRune()), c.Width-len(v)))
+		field := append(append([]rune{}, v...), pad...)
+		if c.Align == ALIGN_RIGHT {
+			field = append(pad, v...)
+		}
+		copy(line[c.Start:], field)
+	}
+	return string(line), nil
+}
+
+// FixedWidthFiler reads fixed width file lines as per its layout
+type FixedWidthFiler struct {
+	*os.File
+	reader *bufio.Reader
+	layout Layout
+	size   uint64
+	logger logger.AppLogger
+}
+
+func NewFixedWidthFiler(f *os.File, layout Layout, logger logger.AppLogger) (*FixedWidthFiler, error) {
+	fs, err := os.Stat(f.Name())
+	if err != nil {
+		logger.Error(ERR_NO_FILE, zap.Error(err))
+		return nil, errors.WrapError(err, ERR_FILE, f.Name())
+	}
+	if err := layout.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &FixedWidthFiler{
+		File:   f,
+		reader: bufio.NewReader(f),
+		layout: layout,
+		size:   uint64(fs.Size()),
+		logger: logger,
+	}, nil
+}
+
+// ReadFixedWidthFile takes context, []string res chan & err chan
+// splits each line into column values, in layout order, & sends them to res chan
+// sends errors on err chan
+// closes res and err channels on done
+func (f *FixedWidthFiler) ReadFixedWidthFile(ctx context.Context, resCh chan []string, errCh chan error) {
+	defer func() {
+		close(resCh)
+		close(errCh)
+	}()
+
+	f.readLines(ctx, errCh, func(line string, lineNum int) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case resCh <- f.layout.Split(line):
+			return true
+		}
+	})
+}
+
+// ReadFixedWidthRecords takes context, JSONMapper res chan & err chan
+// sends each line as a record keyed by column name, typed per column, to res chan
+// sends errors on err chan
+// closes res and err channels on done
+func (f *FixedWidthFiler) ReadFixedWidthRecords(ctx context.Context, resCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(resCh)
+		close(errCh)
+	}()
+
+	f.readLines(ctx, errCh, func(line string, lineNum int) bool {
+		r, err := f.layout.Record(f.layout.Split(line), lineNum)
+		if err != nil {
+			errCh <- err
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case resCh <- r:
+			return true
+		}
+	})
+}
+
+// ReadFixedWidthStructs takes context, filer, T res chan & err chan
+// sends each line decoded into struct T to res chan,
+// struct fields map to columns by `fixedwidth` tag or field name
+// sends errors on err chan
+// closes res and err channels on done
+func ReadFixedWidthStructs[T any](ctx context.Context, f *FixedWidthFiler, resCh chan T, errCh chan error) {
+	defer func() {
+		close(resCh)
+		close(errCh)
+	}()
+
+	// type of T, not of its zero value, which is nil for interface types
+	if t := reflect.TypeOf((*T)(nil)).Elem(); t.Kind() != reflect.Struct {
+		select {
+		case <-ctx.Done():
+		case errCh <- errors.NewAppError(ERR_FW_STRUCT_TYPE, t.String()):
+		}
+		return
+	}
+
+	f.readLines(ctx, errCh, func(line string, lineNum int) bool {
+		r, err := f.layout.Record(f.layout.Split(line), lineNum)
+		if err != nil {
+			errCh <- err
+			return true
+		}
+		var v T
+		if err := setFields(reflect.ValueOf(&v).Elem(), r); err != nil {
+			errCh <- errors.WrapError(err, ERR_FW_DECODE, lineNum)
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case resCh <- v:
+			return true
+		}
+	})
+}
+
+// readLines calls fn with each non-empty line, without line ending, until fn returns false
+func (f *FixedWidthFiler) readLines(ctx context.Context, errCh chan error, fn func(line string, lineNum int) bool) {
+	for lineNum := 1; ; lineNum++ {
+		line, err := f.reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			f.logger.Error(ERR_FW_LINE, zap.Error(err), zap.Int("line", lineNum))
+			errCh <- errors.WrapError(err, ERR_FW_LINE, lineNum)
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && !fn(line, lineNum) {
+			return
+		}
+		if err == io.EOF {
+			f.logger.Info("fixed width file: end of file", zap.Int("lines", lineNum))
+			return
+		}
+	}
+}
+
+func (f *FixedWidthFiler) Close() error {
+	return f.File.Close()
+}
+
+// WriteFixedWidthFile takes context, writer, layout & []string req chan
+// writes each row of values, in layout order, as a fixed width line
+// returns when req chan is closed or context is done
+func WriteFixedWidthFile(ctx context.Context, w io.Writer, layout Layout, reqCh chan []string) error {
+	if err := layout.Validate(); err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for {
+		select {
+		case <-ctx.Done():
+			return bw.Flush()
+		case row, ok := <-reqCh:
+			if !ok {
+				if err := bw.Flush(); err != nil {
+					return errors.WrapError(err, ERR_FW_WRITE)
+				}
+				return nil
+			}
+			line, err := layout.Format(row)
+			if err != nil {
+				return err
+			}
+			if _, err := bw.WriteString(line + "\n"); err != nil {
+				return errors.WrapError(err, ERR_FW_WRITE)
+			}
+		}
+	}
+}
+
+// WriteFixedWidthRecords takes context, writer, layout & JSONMapper req chan
+// writes each record's column values as a fixed width line
+// returns when req chan is closed or context is done
+func WriteFixedWidthRecords(ctx context.Context, w io.Writer, layout Layout, reqCh chan models.JSONMapper) error {
+	rowCh := make(chan []string)
+	go func() {
+		defer close(rowCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case r, ok := <-reqCh:
+				if !ok {
+					return
+				}
+				row := make([]string, len(layout.Columns))
+				for i, c := range layout.Columns {
+					row[i] = formatValue(r[c.Name])
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case rowCh <- row:
+				}
+			}
+		}
+	}()
+
+	err := WriteFixedWidthFile(ctx, w, layout, rowCh)
+	for range rowCh {
+	}
+	return err
+}
+
+func trimPad(v string, pad rune, align Align) string {
+	if pad == ' ' {
+		return strings.TrimSpace(v)
+	}
+	if align == ALIGN_RIGHT {
+		return strings.TrimLeft(v, string(pad))
+	}
+	return strings.TrimRight(v, string(pad))
+}
+
+func parseValue(c Column, v string) (interface{}, error) {
+	if c.Type == "" || c.Type == TYPE_STRING {
+		return v, nil
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	switch c.Type {
+	case TYPE_INT:
+		return strconv.ParseInt(v, 10, 64)
+	case TYPE_FLOAT:
+		return strconv.ParseFloat(v, 64)
+	case TYPE_BOOL:
+		switch strings.ToUpper(v) {
+		case "Y", "YES":
+			return true, nil
+		case "N", "NO":
+			return false, nil
+		}
+		return strconv.ParseBool(v)
+	}
+	return v, nil
+}
+
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case int:
+		return strconv.Itoa(val)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return ""
+		}
+		return strings.Trim(string(data), `"`)
+	}
+}
+
+// setFields sets struct fields from record values, by `fixedwidth` tag or field name
+func setFields(sv reflect.Value, r models.JSONMapper) error {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := sf.Tag.Get("fixedwidth")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		val, ok := r[name]
+		if !ok || val == nil {
+			continue
+		}
+
+		fv := sv.Field(i)
+		rv := reflect.ValueOf(val)
+		switch {
+		case rv.Type().AssignableTo(fv.Type()):
+			fv.Set(rv)
+		case rv.Type().ConvertibleTo(fv.Type()) && rv.Kind() != reflect.String && fv.Kind() != reflect.String:
+			fv.Set(rv.Convert(fv.Type()))
+		case fv.Kind() == reflect.String:
+			fv.SetString(formatValue(val))
+		default:
+			return errors.NewAppError(ERR_FW_STRUCT, sf.Name)
+		}
+	}
+	return nil
+}
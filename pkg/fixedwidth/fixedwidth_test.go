@@ -0,0 +1,159 @@
+package fixedwidth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const TEST_DIR = "data"
+
+var testLayout = Layout{
+	Columns: []Column{
+		{Name: "entity_num", Start: 0, Width: 8, Trim: true},
+		{Name: "EntityName", Start: 8, Width: 20, Trim: true},
+		{Name: "Employees", Start: 28, Width: 6, Type: TYPE_INT, Trim: true, Pad: "0", Align: ALIGN_RIGHT},
+		{Name: "Active", Start: 35, Width: 1, Type: TYPE_BOOL},
+	},
+}
+
+type testFiling struct {
+	EntityNum  string `fixedwidth:"entity_num"`
+	EntityName string
+	Employees  int
+	Active     bool
+}
+
+func TestLayoutValidate(t *testing.T) {
+	require.NoError(t, testLayout.Validate())
+	require.Error(t, Layout{}.Validate())
+	require.Error(t, Layout{Columns: []Column{{Name: "a", Start: 0, Width: 4}, {Name: "b", Start: 3, Width: 2}}}.Validate())
+	require.Error(t, Layout{Columns: []Column{{Name: "a", Start: 0, Width: 4, Type: "date"}}}.Validate())
+}
+
+func TestWriteReadFixedWidth(t *testing.T) {
+	err := os.MkdirAll(TEST_DIR, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fPath := filepath.Join(TEST_DIR, "filings.txt")
+	wf, err := os.Create(fPath)
+	require.NoError(t, err)
+
+	reqCh := make(chan models.JSONMapper)
+	go func() {
+		defer close(reqCh)
+		reqCh <- models.JSONMapper{"entity_num": "C0001", "EntityName": "Plaza Hollywood LLC", "Employees": 42, "Active": "Y"}
+		reqCh <- models.JSONMapper{"entity_num": "C0006", "EntityName": "Exchange Square Holdings Inc", "Employees": 1200, "Active": "N"}
+		reqCh <- models.JSONMapper{"entity_num": "C0008", "EntityName": "Telford Plaza Corp"}
+	}()
+	err = WriteFixedWidthRecords(ctx, wf, testLayout, reqCh)
+	require.NoError(t, err)
+	require.NoError(t, wf.Close())
+
+	data, err := os.ReadFile(fPath)
+	require.NoError(t, err)
+	t.Logf("TestWriteReadFixedWidth: file:\n%s", data)
+	require.Equal(t, "C0001   Plaza Hollywood LLC 000042 Y\nC0006   Exchange Square Hold001200 N\nC0008   Telford Plaza Corp  000000  \n", string(data))
+
+	// rows
+	rows := readAll(t, fPath, func(fw *FixedWidthFiler, errCh chan error) <-chan []string {
+		resCh := make(chan []string)
+		go fw.ReadFixedWidthFile(ctx, resCh, errCh)
+		return resCh
+	})
+	require.Equal(t, []string{"C0006", "Exchange Square Hold", "1200", "N"}, rows[1])
+
+	// records
+	records := readAll(t, fPath, func(fw *FixedWidthFiler, errCh chan error) <-chan models.JSONMapper {
+		resCh := make(chan models.JSONMapper)
+		go fw.ReadFixedWidthRecords(ctx, resCh, errCh)
+		return resCh
+	})
+	require.Equal(t, 3, len(records))
+	require.Equal(t, int64(42), records[0]["Employees"])
+	require.Equal(t, true, records[0]["Active"])
+	require.Equal(t, nil, records[2]["Active"])
+
+	// structs
+	filings := readAll(t, fPath, func(fw *FixedWidthFiler, errCh chan error) <-chan testFiling {
+		resCh := make(chan testFiling)
+		go ReadFixedWidthStructs(ctx, fw, resCh, errCh)
+		return resCh
+	})
+	require.Equal(t, testFiling{EntityNum: "C0006", EntityName: "Exchange Square Hold", Employees: 1200, Active: false}, filings[1])
+	require.Equal(t, testFiling{EntityNum: "C0008", EntityName: "Telford Plaza Corp"}, filings[2])
+
+	// non struct types fail, interface types too
+	requireStructError(t, fPath, "interface {} isn't a struct type", func(fw *FixedWidthFiler, errCh chan error) {
+		go ReadFixedWidthStructs(ctx, fw, make(chan interface{}), errCh)
+	})
+	requireStructError(t, fPath, "*fixedwidth.testFiling isn't a struct type", func(fw *FixedWidthFiler, errCh chan error) {
+		go ReadFixedWidthStructs(ctx, fw, make(chan *testFiling), errCh)
+	})
+}
+
+func requireStructError(t *testing.T, fPath, msg string, read func(fw *FixedWidthFiler, errCh chan error)) {
+	t.Helper()
+
+	file, err := os.Open(fPath)
+	require.NoError(t, err)
+
+	fw, err := NewFixedWidthFiler(file, testLayout, logger.NewTestAppLogger(TEST_DIR))
+	require.NoError(t, err)
+	defer fw.Close()
+
+	errCh := make(chan error)
+	read(fw, errCh)
+	errs := []error{}
+	for err := range errCh {
+		errs = append(errs, err)
+	}
+	require.Equal(t, 1, len(errs))
+	require.Contains(t, errs[0].Error(), msg)
+}
+
+func readAll[T any](t *testing.T, fPath string, read func(fw *FixedWidthFiler, errCh chan error) <-chan T) []T {
+	t.Helper()
+
+	file, err := os.Open(fPath)
+	require.NoError(t, err)
+
+	fw, err := NewFixedWidthFiler(file, testLayout, logger.NewTestAppLogger(TEST_DIR))
+	require.NoError(t, err)
+	defer fw.Close()
+
+	errCh := make(chan error)
+	resCh := read(fw, errCh)
+
+	results := []T{}
+	for resCh != nil || errCh != nil {
+		select {
+		case r, ok := <-resCh:
+			if !ok {
+				resCh = nil
+				continue
+			}
+			results = append(results, r)
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			require.NoError(t, err)
+		}
+	}
+	return results
+}
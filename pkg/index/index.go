@@ -0,0 +1,479 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/charset"
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	ERROR_UNSUPPORTED_FORMAT string = "unsupported index format %s"
+	ERROR_MISSING_KEY_FIELD  string = "missing index key field"
+	ERROR_BUILDING_INDEX     string = "building index for %s"
+	ERROR_READING_INDEX      string = "reading index %s"
+	ERROR_WRITING_INDEX      string = "writing index %s"
+	ERROR_READING_RECORD     string = "reading record at offset %d of %s"
+	ERROR_FILE_STATS         string = "getting file stats of %s"
+	ERROR_START_TOKEN        string = "error reading start token"
+	ERROR_KEY_NOT_IN_HEADERS string = "key field %s not in headers"
+)
+
+const (
+	FORMAT_JSON   string = "json"
+	FORMAT_NDJSON string = "ndjson"
+	FORMAT_CSV    string = "csv"
+)
+
+// INDEX_VERSION is bumped when sidecar layout or key format changes, older sidecars are rebuilt
+const INDEX_VERSION = 2
+
+// INDEX_EXT is appended to data file path for the sidecar index file
+const INDEX_EXT = ".idx"
+
+// CSV_COMMA is the field delimiter of indexed csv files
+const CSV_COMMA rune = '|'
+
+// Entry locates a record with given key in data file
+type Entry struct {
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// sidecar is the on-disk layout of an index
+type sidecar struct {
+	Version  int       `json:"version"`
+	Format   string    `json:"format"`
+	KeyField string    `json:"key_field"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Headers  []string  `json:"headers,omitempty"`
+	Entries  []Entry   `json:"entries"`
+}
+
+// Index maps record keys of a json array, ndjson or csv data file
+// to byte offsets, for direct lookups without scanning the file
+type Index struct {
+	mu       sync.Mutex
+	dataPath string
+	meta     sidecar
+	// saved indexes save their rebuilds, so later opens don't rebuild again
+	saved  bool
+	logger logger.AppLogger
+}
+
+// IndexPath returns sidecar index path of data file
+func IndexPath(dataPath string) string {
+	return dataPath + INDEX_EXT
+}
+
+// Open loads sidecar index of data file if it is still valid for
+// data file's size & modification time, otherwise builds & saves a new one
+func Open(dataPath, keyField, format string, logger logger.AppLogger) (*Index, error) {
+	if logger == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	idx := &Index{
+		dataPath: dataPath,
+		logger:   logger,
+	}
+
+	meta, err := readSidecar(IndexPath(dataPath))
+	if err == nil && meta.Version == INDEX_VERSION && meta.KeyField == keyField && meta.Format == format {
+		idx.meta = meta
+		stale, err := idx.stale()
+		if err != nil {
+			return nil, err
+		}
+		if !stale {
+			idx.saved = true
+			return idx, nil
+		}
+		logger.Info("index stale, rebuilding", zap.String("dataPath", dataPath))
+	}
+
+	if err := idx.build(keyField, format); err != nil {
+		return nil, err
+	}
+	if err := idx.Save(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Build indexes data file by key field without saving sidecar, rebuilds aren't saved either
+func Build(dataPath, keyField, format string, logger logger.AppLogger) (*Index, error) {
+	if logger == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	idx := &Index{
+		dataPath: dataPath,
+		logger:   logger,
+	}
+	if err := idx.build(keyField, format); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Save writes sidecar index next to data file, later rebuilds are saved too
+func (idx *Index) Save() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.save()
+}
+
+func (idx *Index) save() error {
+	path := IndexPath(idx.dataPath)
+	data, err := json.Marshal(idx.meta)
+	if err != nil {
+		return errors.WrapError(err, ERROR_WRITING_INDEX, path)
+	}
+
+	// write & rename, so readers never see a partial index
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_INDEX, path)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_INDEX, path)
+	}
+	idx.saved = true
+	return nil
+}
+
+// Len returns number of indexed records
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.meta.Entries)
+}
+
+// Get returns records with given key, rebuilding the index first if data file changed
+func (idx *Index) Get(key string) ([]models.JSONMapper, error) {
+	entries, err := idx.lookup(func(entries []Entry) (int, int) {
+		lo := sort.Search(len(entries), func(i int) bool { return entries[i].Key >= key })
+		hi := sort.Search(len(entries), func(i int) bool { return entries[i].Key > key })
+		return lo, hi
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx.readEntries(entries)
+}
+
+// Range returns records with keys from start, inclusive, to end, exclusive, in key order,
+// empty end reads to the last key
+func (idx *Index) Range(start, end string) ([]models.JSONMapper, error) {
+	entries, err := idx.lookup(func(entries []Entry) (int, int) {
+		lo := sort.Search(len(entries), func(i int) bool { return entries[i].Key >= start })
+		hi := len(entries)
+		if end != "" {
+			hi = sort.Search(len(entries), func(i int) bool { return entries[i].Key >= end })
+		}
+		return lo, hi
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx.readEntries(entries)
+}
+
+// lookup revalidates the index & returns entries within bounds
+func (idx *Index) lookup(bounds func(entries []Entry) (int, int)) ([]Entry, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	stale, err := idx.stale()
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		idx.logger.Info("index stale, rebuilding", zap.String("dataPath", idx.dataPath))
+		if err := idx.build(idx.meta.KeyField, idx.meta.Format); err != nil {
+			return nil, err
+		}
+		if idx.saved {
+			if err := idx.save(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	lo, hi := bounds(idx.meta.Entries)
+	if lo >= hi {
+		return []Entry{}, nil
+	}
+	return append([]Entry{}, idx.meta.Entries[lo:hi]...), nil
+}
+
+// readEntries seeks to each entry's offset & decodes its record
+func (idx *Index) readEntries(entries []Entry) ([]models.JSONMapper, error) {
+	results := []models.JSONMapper{}
+	if len(entries) < 1 {
+		return results, nil
+	}
+
+	f, err := os.Open(idx.dataPath)
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_READING_RECORD, entries[0].Offset, idx.dataPath)
+	}
+	defer f.Close()
+
+	for _, e := range entries {
+		buf := make([]byte, e.Length)
+		if _, err := f.ReadAt(buf, e.Offset); err != nil {
+			return nil, errors.WrapError(err, ERROR_READING_RECORD, e.Offset, idx.dataPath)
+		}
+		r, err := idx.decode(buf)
+		if err != nil {
+			return nil, errors.WrapError(err, ERROR_READING_RECORD, e.Offset, idx.dataPath)
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (idx *Index) decode(buf []byte) (models.JSONMapper, error) {
+	if idx.meta.Format != FORMAT_CSV {
+		var r models.JSONMapper
+		if err := json.Unmarshal(buf, &r); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	reader := csv.NewReader(bytes.NewReader(buf))
+	reader.Comma = CSV_COMMA
+	reader.FieldsPerRecord = -1
+	row, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	r := models.JSONMapper{}
+	for i, h := range idx.meta.Headers {
+		if i < len(row) {
+			r[h] = row[i]
+		} else {
+			r[h] = ""
+		}
+	}
+	return r, nil
+}
+
+// stale reports whether data file size or modification time changed since indexing
+func (idx *Index) stale() (bool, error) {
+	fs, err := os.Stat(idx.dataPath)
+	if err != nil {
+		return false, errors.WrapError(err, ERROR_FILE_STATS, idx.dataPath)
+	}
+	return fs.Size() != idx.meta.Size || !fs.ModTime().Equal(idx.meta.ModTime), nil
+}
+
+func (idx *Index) build(keyField, format string) error {
+	if keyField == "" {
+		return errors.NewAppError(ERROR_MISSING_KEY_FIELD)
+	}
+
+	fs, err := os.Stat(idx.dataPath)
+	if err != nil {
+		return errors.WrapError(err, ERROR_FILE_STATS, idx.dataPath)
+	}
+	f, err := os.Open(idx.dataPath)
+	if err != nil {
+		return errors.WrapError(err, ERROR_BUILDING_INDEX, idx.dataPath)
+	}
+	defer f.Close()
+
+	meta := sidecar{
+		Version:  INDEX_VERSION,
+		Format:   format,
+		KeyField: keyField,
+		Size:     fs.Size(),
+		ModTime:  fs.ModTime(),
+	}
+
+	switch format {
+	case FORMAT_JSON:
+		meta.Entries, err = scanJSONArray(f, keyField)
+	case FORMAT_NDJSON:
+		meta.Entries, err = scanNDJSON(f, keyField)
+	case FORMAT_CSV:
+		meta.Headers, meta.Entries, err = scanCSV(f, keyField)
+	default:
+		return errors.NewAppError(ERROR_UNSUPPORTED_FORMAT, format)
+	}
+	if err != nil {
+		return errors.WrapError(err, ERROR_BUILDING_INDEX, idx.dataPath)
+	}
+
+	sort.SliceStable(meta.Entries, func(i, j int) bool { return meta.Entries[i].Key < meta.Entries[j].Key })
+	idx.meta = meta
+	idx.logger.Info("built index", zap.String("dataPath", idx.dataPath), zap.Int("entries", len(meta.Entries)))
+	return nil
+}
+
+func readSidecar(path string) (sidecar, error) {
+	var meta sidecar
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return meta, errors.WrapError(err, ERROR_READING_INDEX, path)
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, errors.WrapError(err, ERROR_READING_INDEX, path)
+	}
+	return meta, nil
+}
+
+func scanJSONArray(r io.Reader, keyField string) ([]Entry, error) {
+	reader := bufio.NewReader(r)
+	bom, err := skipBOM(reader)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(reader)
+	t, err := dec.Token()
+	if err != nil || t != json.Delim('[') {
+		return nil, errors.NewAppError(ERROR_START_TOKEN)
+	}
+
+	entries := []Entry{}
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		// raw value excludes leading whitespace & separators, so it ends at input offset
+		end := dec.InputOffset()
+		key, err := jsonKey(raw, keyField)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, Entry{
+			Key:    key,
+			Offset: bom + end - int64(len(raw)),
+			Length: int64(len(raw)),
+		})
+	}
+	return entries, nil
+}
+
+func scanNDJSON(r io.Reader, keyField string) ([]Entry, error) {
+	reader := bufio.NewReader(r)
+	offset, err := skipBOM(reader)
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			key, kerr := jsonKey(trimmed, keyField)
+			if kerr != nil {
+				return nil, kerr
+			}
+			entries = append(entries, Entry{
+				Key:    key,
+				Offset: offset + int64(bytes.Index(line, trimmed)),
+				Length: int64(len(trimmed)),
+			})
+		}
+		offset = offset + int64(len(line))
+		if err != nil {
+			if err == io.EOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+	}
+}
+
+func scanCSV(r io.Reader, keyField string) ([]string, []Entry, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = CSV_COMMA
+	reader.FieldsPerRecord = -1
+
+	headers, err := reader.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	headers[0] = charset.StripBOM(headers[0])
+	col := -1
+	for i, h := range headers {
+		if h == keyField {
+			col = i
+			break
+		}
+	}
+	if col < 0 {
+		return nil, nil, errors.NewAppError(ERROR_KEY_NOT_IN_HEADERS, keyField)
+	}
+
+	entries := []Entry{}
+	for {
+		start := reader.InputOffset()
+		row, err := reader.Read()
+		if err == io.EOF {
+			return headers, entries, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		key := ""
+		if col < len(row) {
+			key = row[col]
+		}
+		entries = append(entries, Entry{
+			Key:    key,
+			Offset: start,
+			Length: reader.InputOffset() - start,
+		})
+	}
+}
+
+// skipBOM skips leading utf-8 byte order mark of reader, returns skipped bytes
+func skipBOM(reader *bufio.Reader) (int64, error) {
+	head, err := reader.Peek(3)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	n := len(head) - len(charset.StripBOM(string(head)))
+	if _, err := reader.Discard(n); err != nil {
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+// jsonKey returns record's key field value as index key,
+// numbers are formatted without exponent, so they match their csv & query form
+func jsonKey(raw []byte, keyField string) (string, error) {
+	var r map[string]interface{}
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return "", err
+	}
+	switch v := r[keyField].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
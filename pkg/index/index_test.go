@@ -0,0 +1,151 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+)
+
+const TEST_DIR = "data"
+
+func TestIndex(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		format string
+		data   string
+	}{
+		"json array index lookup succeeds": {
+			FORMAT_JSON,
+			`[{"entity_num": "C0006", "name": "Exchange Square"},
+  {"entity_num": "C0001", "name": "Plaza Hollywood"},
+	{"entity_num":"C0008","name":"Telford Plaza, \"Kowloon\""} , {"entity_num": "C0001", "name": "Plaza Hollywood II"}]`,
+		},
+		"ndjson index lookup succeeds": {
+			FORMAT_NDJSON,
+			"{\"entity_num\": \"C0006\", \"name\": \"Exchange Square\"}\n\n  {\"entity_num\": \"C0001\", \"name\": \"Plaza Hollywood\"}\r\n{\"entity_num\": \"C0008\", \"name\": \"Telford Plaza, \\\"Kowloon\\\"\"}\n{\"entity_num\": \"C0001\", \"name\": \"Plaza Hollywood II\"}",
+		},
+		"csv index lookup succeeds": {
+			FORMAT_CSV,
+			"entity_num|name\nC0006|Exchange Square\nC0001|Plaza Hollywood\nC0008|\"Telford Plaza, \"\"Kowloon\"\"\"\nC0001|\"Plaza\nHollywood II\"\n",
+		},
+		"json array with byte order mark index lookup succeeds": {
+			FORMAT_JSON,
+			"\xEF\xBB\xBF" + `[{"entity_num": "C0006", "name": "Exchange Square"},
+  {"entity_num": "C0001", "name": "Plaza Hollywood"},
+	{"entity_num":"C0008","name":"Telford Plaza, \"Kowloon\""} , {"entity_num": "C0001", "name": "Plaza Hollywood II"}]`,
+		},
+		"ndjson with byte order mark index lookup succeeds": {
+			FORMAT_NDJSON,
+			"\xEF\xBB\xBF{\"entity_num\": \"C0006\", \"name\": \"Exchange Square\"}\n{\"entity_num\": \"C0001\", \"name\": \"Plaza Hollywood\"}\n{\"entity_num\": \"C0008\", \"name\": \"Telford Plaza, \\\"Kowloon\\\"\"}\n{\"entity_num\": \"C0001\", \"name\": \"Plaza Hollywood II\"}\n",
+		},
+		"csv with byte order mark index lookup succeeds": {
+			FORMAT_CSV,
+			"\xEF\xBB\xBFentity_num|name\nC0006|Exchange Square\nC0001|Plaza Hollywood\nC0008|\"Telford Plaza, \"\"Kowloon\"\"\"\nC0001|\"Plaza\nHollywood II\"\n",
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			testIndex(t, tc.format, tc.data)
+		})
+	}
+}
+
+func TestIndexNumericKeys(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	err := os.MkdirAll(TEST_DIR, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	// numeric keys are looked up in their plain form, not exponent form
+	dataPath := filepath.Join(TEST_DIR, "agents.json")
+	err = os.WriteFile(dataPath, []byte(`[{"id": 1234567, "name": "Wong"}, {"id": 12.5, "name": "Lee"}, {"id": "1234567", "name": "Chan"}]`), os.ModePerm)
+	require.NoError(t, err)
+
+	idx, err := Open(dataPath, "id", FORMAT_JSON, logger)
+	require.NoError(t, err)
+	rs, err := idx.Get("1234567")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(rs))
+	rs, err = idx.Get("12.5")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rs))
+	require.Equal(t, "Lee", rs[0]["name"])
+}
+
+func testIndex(t *testing.T, format, data string) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	err := os.MkdirAll(TEST_DIR, os.ModePerm)
+	require.NoError(t, err)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	dataPath := filepath.Join(TEST_DIR, "filings."+format)
+	err = os.WriteFile(dataPath, []byte(data), os.ModePerm)
+	require.NoError(t, err)
+
+	idx, err := Open(dataPath, "entity_num", format, logger)
+	require.NoError(t, err)
+	require.Equal(t, 4, idx.Len())
+
+	_, err = os.Stat(IndexPath(dataPath))
+	require.NoError(t, err)
+
+	rs, err := idx.Get("C0001")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(rs))
+	require.Equal(t, "Plaza Hollywood", rs[0]["name"])
+
+	rs, err = idx.Get("C0008")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rs))
+	require.Equal(t, `Telford Plaza, "Kowloon"`, rs[0]["name"])
+
+	rs, err = idx.Get("C0002")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(rs))
+
+	rs, err = idx.Range("C0002", "C0008")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rs))
+	require.Equal(t, "Exchange Square", rs[0]["name"])
+
+	rs, err = idx.Range("C0006", "")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(rs))
+
+	// reopening uses saved sidecar
+	idx, err = Open(dataPath, "entity_num", format, logger)
+	require.NoError(t, err)
+	require.Equal(t, 4, idx.Len())
+
+	// changing data file invalidates the index
+	changed := map[string]string{
+		FORMAT_JSON:   `[{"entity_num": "C0009", "name": "Festival Walk"}]`,
+		FORMAT_NDJSON: `{"entity_num": "C0009", "name": "Festival Walk"}`,
+		FORMAT_CSV:    "entity_num|name\nC0009|Festival Walk\n",
+	}[format]
+	err = os.WriteFile(dataPath, []byte(changed), os.ModePerm)
+	require.NoError(t, err)
+	future := time.Now().Add(time.Minute)
+	err = os.Chtimes(dataPath, future, future)
+	require.NoError(t, err)
+
+	rs, err = idx.Get("C0009")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(rs))
+	require.Equal(t, "Festival Walk", rs[0]["name"])
+	require.Equal(t, 1, idx.Len())
+
+	// rebuilt index is saved
+	meta, err := readSidecar(IndexPath(dataPath))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(meta.Entries))
+	require.Equal(t, "C0009", meta.Entries[0].Key)
+}
@@ -0,0 +1,284 @@
+package join
+
+import (
+	"context"
+
+	"github.com/comfforts/errors"
+
+	"github.com/comfforts/localstorage/pkg/extsort"
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	ERROR_UNSORTED_STREAM string = "%s stream not sorted by key at %v"
+	ERROR_MISSING_KEY     string = "missing key function"
+)
+
+type JoinType int
+
+const (
+	// INNER emits primary records matched in every related stream
+	INNER JoinType = iota
+	// LEFT emits every primary record, with whatever related records match
+	LEFT
+)
+
+// DEFAULT_KEY_FIELD is the entity number field shared by business models
+const DEFAULT_KEY_FIELD string = "entity_num"
+
+// KeyFunc returns join key of a record
+type KeyFunc func(r models.JSONMapper) interface{}
+
+// FieldKey returns key function reading given record field
+func FieldKey(field string) KeyFunc {
+	return func(r models.JSONMapper) interface{} {
+		return r[field]
+	}
+}
+
+// Input is a named related record stream
+type Input struct {
+	Name    string
+	Records <-chan models.JSONMapper
+}
+
+// Joined is a primary record combined with its related records, by input name
+type Joined struct {
+	Key     interface{}
+	Primary models.JSONMapper
+	Related map[string][]models.JSONMapper
+}
+
+type Config struct {
+	Type JoinType
+	Key  KeyFunc
+}
+
+// MergeJoin takes context, config, primary stream & related streams,
+// all sorted ascending by key, joins them in a single pass,
+// sends joined records to out chan & errors to err chan
+// closes out and err channels on done
+func MergeJoin(ctx context.Context, cfg Config, primary <-chan models.JSONMapper, related []Input, outCh chan Joined, errCh chan error) {
+	defer func() {
+		close(outCh)
+		close(errCh)
+	}()
+	if cfg.Key == nil {
+		errCh <- errors.NewAppError(ERROR_MISSING_KEY)
+		return
+	}
+
+	cursors := make([]*cursor, len(related))
+	for i, in := range related {
+		cursors[i] = &cursor{input: in, key: cfg.Key}
+	}
+
+	var prevKey interface{}
+	isFirst := true
+	for {
+		var p models.JSONMapper
+		select {
+		case <-ctx.Done():
+			return
+		case r, ok := <-primary:
+			if !ok {
+				return
+			}
+			p = r
+		}
+
+		key := cfg.Key(p)
+		if !isFirst && extsort.Compare(key, prevKey) < 0 {
+			errCh <- errors.NewAppError(ERROR_UNSORTED_STREAM, "primary", key)
+			return
+		}
+		isFirst = false
+		prevKey = key
+
+		j := Joined{
+			Key:     key,
+			Primary: p,
+			Related: map[string][]models.JSONMapper{},
+		}
+		for _, c := range cursors {
+			group, err := c.group(ctx, key)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			j.Related[c.input.Name] = group
+		}
+
+		if !emit(ctx, cfg.Type, j, outCh) {
+			return
+		}
+	}
+}
+
+// HashJoin takes context, config, primary stream & related streams, in any order,
+// indexes related streams in memory by key, then streams primary records through the index,
+// sends joined records to out chan & errors to err chan
+// closes out and err channels on done
+func HashJoin(ctx context.Context, cfg Config, primary <-chan models.JSONMapper, related []Input, outCh chan Joined, errCh chan error) {
+	defer func() {
+		close(outCh)
+		close(errCh)
+	}()
+	if cfg.Key == nil {
+		errCh <- errors.NewAppError(ERROR_MISSING_KEY)
+		return
+	}
+
+	indexes := make([]map[string][]models.JSONMapper, len(related))
+	for i, in := range related {
+		idx := map[string][]models.JSONMapper{}
+		for {
+			var r models.JSONMapper
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return
+			case r, ok = <-in.Records:
+			}
+			if !ok {
+				break
+			}
+			key := cfg.Key(r)
+			if key == nil {
+				continue
+			}
+			k := hashKey(key)
+			idx[k] = append(idx[k], r)
+		}
+		indexes[i] = idx
+	}
+
+	for {
+		var p models.JSONMapper
+		select {
+		case <-ctx.Done():
+			return
+		case r, ok := <-primary:
+			if !ok {
+				return
+			}
+			p = r
+		}
+
+		key := cfg.Key(p)
+		j := Joined{
+			Key:     key,
+			Primary: p,
+			Related: map[string][]models.JSONMapper{},
+		}
+		for i, in := range related {
+			group := []models.JSONMapper{}
+			if key != nil {
+				group = append(group, indexes[i][hashKey(key)]...)
+			}
+			j.Related[in.Name] = group
+		}
+
+		if !emit(ctx, cfg.Type, j, outCh) {
+			return
+		}
+	}
+}
+
+// emit sends joined record to out chan if it satisfies join type,
+// returns false if context is done
+func emit(ctx context.Context, joinType JoinType, j Joined, outCh chan Joined) bool {
+	if joinType == INNER {
+		for _, group := range j.Related {
+			if len(group) < 1 {
+				return true
+			}
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case outCh <- j:
+		return true
+	}
+}
+
+// cursor walks a sorted related stream, one key group at a time
+type cursor struct {
+	input    Input
+	key      KeyFunc
+	head     models.JSONMapper
+	hasHead  bool
+	done     bool
+	lastKey  interface{}
+	hasLast  bool
+	groupKey interface{}
+	grouped  []models.JSONMapper
+	hasGroup bool
+}
+
+// group returns related records matching key, skipping records with lesser keys
+func (c *cursor) group(ctx context.Context, key interface{}) ([]models.JSONMapper, error) {
+	// records without key match nothing
+	if key == nil {
+		return []models.JSONMapper{}, nil
+	}
+
+	// repeated primary key reuses last group
+	if c.hasGroup && extsort.Compare(c.groupKey, key) == 0 {
+		return c.grouped, nil
+	}
+
+	group := []models.JSONMapper{}
+	for {
+		if !c.hasHead && !c.done {
+			if err := c.advance(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if c.done && !c.hasHead {
+			break
+		}
+
+		cmp := extsort.Compare(c.key(c.head), key)
+		if cmp > 0 {
+			break
+		}
+		if cmp == 0 {
+			group = append(group, c.head)
+		}
+		c.hasHead = false
+	}
+
+	c.groupKey = key
+	c.grouped = group
+	c.hasGroup = true
+	return group, nil
+}
+
+func (c *cursor) advance(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		c.done = true
+		return nil
+	case r, ok := <-c.input.Records:
+		if !ok {
+			c.done = true
+			return nil
+		}
+		k := c.key(r)
+		if c.hasLast && extsort.Compare(k, c.lastKey) < 0 {
+			return errors.NewAppError(ERROR_UNSORTED_STREAM, c.input.Name, k)
+		}
+		c.lastKey = k
+		c.hasLast = true
+		c.head = r
+		c.hasHead = true
+		return nil
+	}
+}
+
+// hashKey returns key's index key, equal for keys merge joins compare equal
+func hashKey(key interface{}) string {
+	return extsort.Key(key)
+}
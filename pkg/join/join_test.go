@@ -0,0 +1,150 @@
+package join
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+func TestJoin(t *testing.T) {
+	for scenario, fn := range map[string]func(
+		ctx context.Context,
+		cfg Config,
+		primary <-chan models.JSONMapper,
+		related []Input,
+		outCh chan Joined,
+		errCh chan error,
+	){
+		"merge join succeeds": MergeJoin,
+		"hash join succeeds":  HashJoin,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			joined, errs := runJoin(t, fn, LEFT, []string{"C1", "C2", "C3", "C3"}, []string{"C0", "C1", "C1", "C3"}, []string{"C2", "C3"})
+			require.Equal(t, 0, len(errs))
+			require.Equal(t, 4, len(joined))
+			require.Equal(t, 2, len(joined[0].Related["agents"]))
+			require.Equal(t, 0, len(joined[0].Related["principals"]))
+			require.Equal(t, 0, len(joined[1].Related["agents"]))
+			require.Equal(t, 1, len(joined[1].Related["principals"]))
+			require.Equal(t, 1, len(joined[3].Related["agents"]))
+			require.Equal(t, 1, len(joined[3].Related["principals"]))
+
+			joined, errs = runJoin(t, fn, INNER, []string{"C1", "C2", "C3", "C3"}, []string{"C0", "C1", "C1", "C3"}, []string{"C2", "C3"})
+			require.Equal(t, 0, len(errs))
+			require.Equal(t, 2, len(joined))
+			require.Equal(t, "C3", joined[0].Key)
+			require.Equal(t, "C3", joined[1].Key)
+		})
+	}
+}
+
+func TestMergeJoinUnsorted(t *testing.T) {
+	_, errs := runJoin(t, MergeJoin, LEFT, []string{"C1", "C2", "C3"}, []string{"C3", "C1"}, []string{})
+	require.Equal(t, 1, len(errs))
+	t.Logf("TestMergeJoinUnsorted: error: %v", errs[0])
+}
+
+func TestJoinKeyTypes(t *testing.T) {
+	// json numbers, csv strings & ints of same value join alike in both strategies
+	filings := []interface{}{1.0, "2", 1234567.0}
+	agents := []interface{}{"1", 2, "1234567"}
+	principals := []interface{}{"1.0", 2.0}
+	for name, fn := range map[string]func(
+		ctx context.Context,
+		cfg Config,
+		primary <-chan models.JSONMapper,
+		related []Input,
+		outCh chan Joined,
+		errCh chan error,
+	){
+		"merge join": MergeJoin,
+		"hash join":  HashJoin,
+	} {
+		joined, errs := runJoinKeys(t, fn, LEFT, filings, agents, principals)
+		require.Equal(t, 0, len(errs), name)
+		require.Equal(t, 3, len(joined), name)
+		for i, j := range joined {
+			require.Equal(t, 1, len(j.Related["agents"]), "%s: filing %d", name, i)
+		}
+		require.Equal(t, []int{1, 1, 0}, []int{
+			len(joined[0].Related["principals"]),
+			len(joined[1].Related["principals"]),
+			len(joined[2].Related["principals"]),
+		}, name)
+	}
+}
+
+func runJoin(
+	t *testing.T,
+	fn func(ctx context.Context, cfg Config, primary <-chan models.JSONMapper, related []Input, outCh chan Joined, errCh chan error),
+	joinType JoinType,
+	filings, agents, principals []string,
+) ([]Joined, []error) {
+	t.Helper()
+
+	keys := func(ks []string) []interface{} {
+		vs := make([]interface{}, len(ks))
+		for i, k := range ks {
+			vs[i] = k
+		}
+		return vs
+	}
+	return runJoinKeys(t, fn, joinType, keys(filings), keys(agents), keys(principals))
+}
+
+func runJoinKeys(
+	t *testing.T,
+	fn func(ctx context.Context, cfg Config, primary <-chan models.JSONMapper, related []Input, outCh chan Joined, errCh chan error),
+	joinType JoinType,
+	filings, agents, principals []interface{},
+) ([]Joined, []error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := func(keys []interface{}) <-chan models.JSONMapper {
+		ch := make(chan models.JSONMapper)
+		go func() {
+			defer close(ch)
+			for i, k := range keys {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- models.JSONMapper{DEFAULT_KEY_FIELD: k, "seq": i}:
+				}
+			}
+		}()
+		return ch
+	}
+
+	outCh := make(chan Joined)
+	errCh := make(chan error)
+	go fn(ctx, Config{Type: joinType, Key: FieldKey(DEFAULT_KEY_FIELD)}, stream(filings), []Input{
+		{Name: "agents", Records: stream(agents)},
+		{Name: "principals", Records: stream(principals)},
+	}, outCh, errCh)
+
+	joined := []Joined{}
+	errs := []error{}
+	for outCh != nil || errCh != nil {
+		select {
+		case j, ok := <-outCh:
+			if !ok {
+				outCh = nil
+				continue
+			}
+			joined = append(joined, j)
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			errs = append(errs, err)
+		}
+	}
+	return joined, errs
+}
@@ -2,14 +2,17 @@ package json
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"os"
 
 	"github.com/comfforts/errors"
 	"github.com/comfforts/logger"
 	"go.uber.org/zap"
 
+	"github.com/comfforts/localstorage/pkg/charset"
 	"github.com/comfforts/localstorage/pkg/models"
 )
 
@@ -18,6 +21,11 @@ const (
 	ERROR_START_TOKEN     string = "error reading start token"
 	ERROR_END_TOKEN       string = "error reading end token"
 	ERROR_DECODING_RESULT string = "error decoding result json"
+	ERROR_DECODING_KEY    string = "error decoding object key"
+	ERROR_READING_LINE    string = "error reading json line"
+	ERROR_DECODING_FILE   string = "error decoding json file %s"
+	ERROR_ENCODING_RESULT string = "error encoding result json"
+	ERROR_WRITING_RESULT  string = "error writing result json"
 )
 
 var (
@@ -25,39 +33,89 @@ var (
 	ErrEndToken   = errors.NewAppError(ERROR_END_TOKEN)
 )
 
+// KeyValue is a single key/value pair of a top-level json object
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+type JSONConfig struct {
+	// Encoding of file content, AUTO detects it, byte order marks are stripped
+	Encoding charset.Encoding
+}
+
 type jsonFiler struct {
 	*os.File
-	reader *bufio.Reader
-	size   uint64
-	logger logger.AppLogger
+	reader   *bufio.Reader
+	size     uint64
+	encoding charset.Encoding
+	logger   logger.AppLogger
 }
 
 func NewJSONFiler(f *os.File, logger logger.AppLogger) (*jsonFiler, error) {
+	return NewJSONFilerWithConfig(f, JSONConfig{}, logger)
+}
+
+func NewJSONFilerWithConfig(f *os.File, cfg JSONConfig, logger logger.AppLogger) (*jsonFiler, error) {
+	return NewJSONFilerWithReader(f, f, cfg, logger)
+}
+
+// NewJSONFilerWithReader returns filer of file f reading its content from src,
+// e.g. a decrypting reader of f
+func NewJSONFilerWithReader(f *os.File, src io.Reader, cfg JSONConfig, logger logger.AppLogger) (*jsonFiler, error) {
 	fs, err := os.Stat(f.Name())
 	if err != nil {
 		logger.Error("error getting filer file stats", zap.Error(err))
 		return nil, errors.WrapError(err, ERROR_NO_FILE, f.Name())
 	}
 	size := uint64(fs.Size())
-	reader := bufio.NewReader(f)
+
+	r, enc, err := charset.NewReader(src, cfg.Encoding)
+	if err != nil {
+		logger.Error("error detecting filer file encoding", zap.Error(err))
+		return nil, errors.WrapError(err, ERROR_DECODING_FILE, f.Name())
+	}
+	reader := bufio.NewReader(r)
 
 	return &jsonFiler{
-		File:   f,
-		size:   size,
-		reader: reader,
-		logger: logger,
+		File:     f,
+		size:     size,
+		reader:   reader,
+		encoding: enc,
+		logger:   logger,
 	}, nil
 }
 
+// Encoding returns encoding the file is decoded from
+func (f *jsonFiler) Encoding() charset.Encoding {
+	return f.encoding
+}
+
+// ReadJSONFile takes context, JSONMapper res chan & err chan
+// reads json array, one record at a time,
+// sends records to res chan & errors on err chan
+// closes res and err channels on done, also once context is done
 func (f *jsonFiler) ReadJSONFile(ctx context.Context, resCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(resCh)
+		close(errCh)
+	}()
+
+	sendErr := func(err error) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case errCh <- err:
+			return true
+		}
+	}
+
 	dec := json.NewDecoder(f.reader)
 
 	// read open bracket
 	t, err := dec.Token()
 	if err != nil || t != json.Delim('[') {
-		errCh <- ErrStartToken
-		close(resCh)
-		close(errCh)
+		sendErr(ErrStartToken)
 		return
 	}
 
@@ -65,8 +123,8 @@ func (f *jsonFiler) ReadJSONFile(ctx context.Context, resCh chan models.JSONMapp
 	for dec.More() {
 		var result models.JSONMapper
 		err := dec.Decode(&result)
-		if err != nil {
-			errCh <- errors.WrapError(err, ERROR_DECODING_RESULT)
+		if err != nil && !sendErr(errors.WrapError(err, ERROR_DECODING_RESULT)) {
+			return
 		}
 		select {
 		case <-ctx.Done():
@@ -78,11 +136,230 @@ func (f *jsonFiler) ReadJSONFile(ctx context.Context, resCh chan models.JSONMapp
 	// read closing bracket
 	t, err = dec.Token()
 	if err != nil || t != json.Delim(']') {
+		sendErr(ErrEndToken)
+	}
+}
+
+// ReadNDJSONFile takes context, JSONMapper res chan & err chan
+// reads newline delimited json, one record per line, skipping blank lines
+// sends records to res chan & errors on err chan
+// closes res and err channels on done
+func (f *jsonFiler) ReadNDJSONFile(ctx context.Context, resCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(resCh)
+		close(errCh)
+	}()
+
+	for {
+		line, err := f.reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var result models.JSONMapper
+			if err := json.Unmarshal(line, &result); err != nil {
+				errCh <- errors.WrapError(err, ERROR_DECODING_RESULT)
+			} else {
+				select {
+				case <-ctx.Done():
+					return
+				case resCh <- result:
+				}
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				errCh <- errors.WrapError(err, ERROR_READING_LINE)
+			}
+			return
+		}
+	}
+}
+
+// ReadJSONObject takes context, KeyValue res chan & err chan
+// reads a top-level json object, one key at a time,
+// sends key/value pairs to res chan & errors on err chan
+// closes res and err channels on done
+func (f *jsonFiler) ReadJSONObject(ctx context.Context, resCh chan KeyValue, errCh chan error) {
+	defer func() {
+		close(resCh)
+		close(errCh)
+	}()
+
+	dec := json.NewDecoder(f.reader)
+
+	// read open brace
+	t, err := dec.Token()
+	if err != nil || t != json.Delim('{') {
+		errCh <- ErrStartToken
+		return
+	}
+
+	// while the object contains keys
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			errCh <- errors.WrapError(err, ERROR_DECODING_KEY)
+			return
+		}
+		key, ok := t.(string)
+		if !ok {
+			errCh <- errors.NewAppError(ERROR_DECODING_KEY)
+			return
+		}
+
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			errCh <- errors.WrapError(err, ERROR_DECODING_RESULT)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case resCh <- KeyValue{Key: key, Value: value}:
+		}
+	}
+
+	// read closing brace
+	t, err = dec.Token()
+	if err != nil || t != json.Delim('}') {
 		errCh <- ErrEndToken
 	}
+}
+
+// WriteJSONArray takes context, writer & JSONMapper req chan
+// writes records received on req chan, one at a time, as a json array
+// finalizes the array when req chan is closed, returns context error if context is done
+func WriteJSONArray(ctx context.Context, w io.Writer, reqCh chan models.JSONMapper) error {
+	bw := bufio.NewWriter(w)
+	if err := bw.WriteByte('['); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_RESULT)
+	}
+
+	isFirst := true
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case r, ok := <-reqCh:
+			if !ok {
+				break loop
+			}
+			data, err := json.Marshal(r)
+			if err != nil {
+				return errors.WrapError(err, ERROR_ENCODING_RESULT)
+			}
+			if !isFirst {
+				if err := bw.WriteByte(','); err != nil {
+					return errors.WrapError(err, ERROR_WRITING_RESULT)
+				}
+			}
+			isFirst = false
+			if _, err := bw.Write(data); err != nil {
+				return errors.WrapError(err, ERROR_WRITING_RESULT)
+			}
+		}
+	}
+	// partial array isn't finalized, so it can't pass for a complete one
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := bw.WriteString("]\n"); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_RESULT)
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_RESULT)
+	}
+	return nil
+}
 
-	close(resCh)
-	close(errCh)
+// WriteNDJSONFile takes context, writer & JSONMapper req chan
+// writes records received on req chan as newline delimited json
+// returns when req chan is closed, or context error if context is done
+func WriteNDJSONFile(ctx context.Context, w io.Writer, reqCh chan models.JSONMapper) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case r, ok := <-reqCh:
+			if !ok {
+				break loop
+			}
+			if err := enc.Encode(r); err != nil {
+				return errors.WrapError(err, ERROR_ENCODING_RESULT)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_RESULT)
+	}
+	return nil
+}
+
+// WriteJSONObject takes context, writer & KeyValue req chan
+// writes key/value pairs received on req chan as a top-level json object
+// finalizes the object when req chan is closed, returns context error if context is done
+func WriteJSONObject(ctx context.Context, w io.Writer, reqCh chan KeyValue) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString("{"); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_RESULT)
+	}
+
+	isFirst := true
+	writeKV := func(kv KeyValue) error {
+		key, err := json.Marshal(kv.Key)
+		if err != nil {
+			return errors.WrapError(err, ERROR_ENCODING_RESULT)
+		}
+		val, err := json.Marshal(kv.Value)
+		if err != nil {
+			return errors.WrapError(err, ERROR_ENCODING_RESULT)
+		}
+		if !isFirst {
+			if err := bw.WriteByte(','); err != nil {
+				return errors.WrapError(err, ERROR_WRITING_RESULT)
+			}
+		}
+		isFirst = false
+		for _, b := range [][]byte{key, {':'}, val} {
+			if _, err := bw.Write(b); err != nil {
+				return errors.WrapError(err, ERROR_WRITING_RESULT)
+			}
+		}
+		return nil
+	}
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case kv, ok := <-reqCh:
+			if !ok {
+				break loop
+			}
+			if err := writeKV(kv); err != nil {
+				return err
+			}
+		}
+	}
+	// partial object isn't finalized, so it can't pass for a complete one
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := bw.WriteString("}\n"); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_RESULT)
+	}
+	if err := bw.Flush(); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_RESULT)
+	}
+	return nil
 }
 
 func (f *jsonFiler) Close() error {
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/comfforts/logger"
 	"github.com/stretchr/testify/require"
@@ -68,6 +70,132 @@ func TestReadJSONArray(t *testing.T) {
 	}
 }
 
+func TestReadWriteJSONObject(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	fPath := filepath.Join(TEST_DIR, "data-object.json")
+	err := os.MkdirAll(filepath.Dir(fPath), os.ModePerm)
+	require.NoError(t, err)
+
+	wf, err := os.Create(fPath)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reqCh := make(chan KeyValue)
+	go func() {
+		defer close(reqCh)
+		for i, item := range createStoreJSONList() {
+			reqCh <- KeyValue{Key: fmt.Sprintf("C%04d", i), Value: item}
+		}
+	}()
+	err = WriteJSONObject(ctx, wf, reqCh)
+	require.NoError(t, err)
+	require.NoError(t, wf.Close())
+
+	file, err := os.Open(fPath)
+	require.NoError(t, err)
+
+	jsonFiler, err := NewJSONFiler(file, logger)
+	require.NoError(t, err)
+	defer jsonFiler.Close()
+
+	resCh := make(chan KeyValue)
+	errCh := make(chan error)
+	go jsonFiler.ReadJSONObject(ctx, resCh, errCh)
+
+	keys := []string{}
+	errCount := 0
+	for resCh != nil || errCh != nil {
+		select {
+		case kv, ok := <-resCh:
+			if !ok {
+				resCh = nil
+				continue
+			}
+			keys = append(keys, kv.Key)
+			require.Equal(t, "starbucks", kv.Value.(map[string]interface{})["org"])
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			t.Logf("TestReadWriteJSONObject - error: %v", err)
+			errCount++
+		}
+	}
+	require.Equal(t, []string{"C0000", "C0001", "C0002"}, keys)
+	require.Equal(t, 0, errCount)
+}
+
+func TestReadJSONArrayCancelled(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	fPath, err := createJSONFile(TEST_DIR, "cancelled")
+	require.NoError(t, err)
+	file, err := os.Open(fPath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	jsonFiler, err := NewJSONFiler(file, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resCh, errCh := make(chan models.JSONMapper), make(chan error)
+	go jsonFiler.ReadJSONFile(ctx, resCh, errCh)
+
+	// cancelled after first record, with unread records left, streams close
+	<-resCh
+	cancel()
+	timeout := time.After(5 * time.Second)
+	for resCh != nil || errCh != nil {
+		select {
+		case <-timeout:
+			require.Fail(t, "read streams not closed")
+		case _, ok := <-resCh:
+			if !ok {
+				resCh = nil
+			}
+		case _, ok := <-errCh:
+			if !ok {
+				errCh = nil
+			}
+		}
+	}
+}
+
+func TestWriteJSONArrayCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reqCh := make(chan models.JSONMapper)
+	go func() {
+		reqCh <- models.JSONMapper{"name": "first"}
+		cancel()
+	}()
+
+	var sb strings.Builder
+	err := WriteJSONArray(ctx, &sb, reqCh)
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotContains(t, sb.String(), "]")
+}
+
+func TestWriteJSONObjectCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reqCh := make(chan KeyValue)
+	go func() {
+		reqCh <- KeyValue{Key: "C0000", Value: "first"}
+		cancel()
+	}()
+
+	var sb strings.Builder
+	err := WriteJSONObject(ctx, &sb, reqCh)
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotContains(t, sb.String(), "}")
+}
+
 func createJSONFile(dir, name string) (string, error) {
 	fPath := fmt.Sprintf("%s.json", name)
 	if dir != "" {
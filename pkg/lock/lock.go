@@ -0,0 +1,231 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	ERROR_LOCKING_FILE   string = "locking %s"
+	ERROR_UNLOCKING_FILE string = "unlocking %s"
+	ERROR_LOCK_TIMEOUT   string = "timed out locking %s"
+)
+
+const (
+	// DEFAULT_LOCK_SUFFIX names lock files, matching watch lock files
+	DEFAULT_LOCK_SUFFIX    string        = ".lock"
+	DEFAULT_RETRY_INTERVAL time.Duration = 10 * time.Millisecond
+)
+
+type Config struct {
+	// Timeout, if set, limits wait for a lock, otherwise lock waits till context done
+	Timeout time.Duration
+	// LockFile locks by exclusively creating a lock file next to locked path,
+	// for coordination where flock isn't available or honored, e.g. network file systems,
+	// shared locks are exclusive in lock file mode
+	LockFile bool
+	// LockSuffix names lock files, defaults to DEFAULT_LOCK_SUFFIX
+	LockSuffix string
+	// Stale, if set, removes lock files older than duration, left by crashed processes
+	Stale time.Duration
+	// RetryInterval is wait between lock attempts, defaults to DEFAULT_RETRY_INTERVAL
+	RetryInterval time.Duration
+}
+
+type Locker struct {
+	config Config
+	logger logger.AppLogger
+}
+
+func NewLocker(cfg Config, logger logger.AppLogger) (*Locker, error) {
+	if logger == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	if cfg.LockSuffix == "" {
+		cfg.LockSuffix = DEFAULT_LOCK_SUFFIX
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = DEFAULT_RETRY_INTERVAL
+	}
+	return &Locker{
+		config: cfg,
+		logger: logger,
+	}, nil
+}
+
+// Lock is a held lock, a flock of file, a lock file at lockPath, or both
+type Lock struct {
+	path     string
+	file     *os.File
+	lockPath string
+}
+
+// Lock takes an exclusive lock on path, waits till lock is acquired, timeout or context done,
+// missing paths aren't created, they're locked with a lock file in flock mode too
+func (l *Locker) Lock(ctx context.Context, path string) (*Lock, error) {
+	return l.acquire(ctx, path, true)
+}
+
+// RLock takes a shared lock on existing path,
+// waits till lock is acquired, timeout or context done
+func (l *Locker) RLock(ctx context.Context, path string) (*Lock, error) {
+	return l.acquire(ctx, path, false)
+}
+
+func (l *Locker) acquire(ctx context.Context, path string, exclusive bool) (*Lock, error) {
+	if l.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.config.Timeout)
+		defer cancel()
+	}
+
+	try := l.tryFlock
+	if l.config.LockFile || !flockSupported {
+		try = l.tryLockFile
+	}
+
+	ticker := time.NewTicker(l.config.RetryInterval)
+	defer ticker.Stop()
+	for {
+		lk, err := try(path, exclusive)
+		if err != nil {
+			return nil, errors.WrapError(err, ERROR_LOCKING_FILE, path)
+		}
+		if lk != nil {
+			return lk, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, errors.NewAppError(ERROR_LOCK_TIMEOUT, path)
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// tryFlock tries a non-blocking flock, returns nil lock if held elsewhere,
+// missing paths are locked with a lock file, which flocks of the path, once created, honor
+func (l *Locker) tryFlock(path string, exclusive bool) (*Lock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if exclusive && os.IsNotExist(err) {
+			return l.tryMissing(path)
+		}
+		return nil, err
+	}
+
+	ok, err := flock(f, exclusive)
+	if err != nil || !ok {
+		f.Close()
+		return nil, err
+	}
+	// lock file of a lock taken while path was missing
+	if held, err := l.lockFileHeld(path); err != nil || held {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{path: path, file: f}, nil
+}
+
+// tryMissing locks missing path with a lock file, also flocking path if it's been created meanwhile
+func (l *Locker) tryMissing(path string) (*Lock, error) {
+	lk, err := l.tryLockFile(path, true)
+	if err != nil || lk == nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lk, nil
+		}
+		lk.Unlock()
+		return nil, err
+	}
+	ok, err := flock(f, true)
+	if err != nil || !ok {
+		f.Close()
+		lk.Unlock()
+		return nil, err
+	}
+	lk.file = f
+	return lk, nil
+}
+
+// lockFileHeld checks if path's lock file exists, stale lock files are removed
+func (l *Locker) lockFileHeld(path string) (bool, error) {
+	lockPath := path + l.config.LockSuffix
+	info, err := os.Stat(lockPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if l.config.Stale > 0 && time.Since(info.ModTime()) > l.config.Stale {
+		l.logger.Info("removing stale lock file", zap.String("path", lockPath), zap.Time("modTime", info.ModTime()))
+		if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// tryLockFile tries to exclusively create lock file, returns nil lock if it exists,
+// stale lock files are removed
+func (l *Locker) tryLockFile(path string, exclusive bool) (*Lock, error) {
+	lockPath := path + l.config.LockSuffix
+	f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if _, err := l.lockFileHeld(path); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	defer f.Close()
+
+	// owner, for debugging stuck locks
+	host, _ := os.Hostname()
+	if _, err := fmt.Fprintf(f, "%s %d\n", host, os.Getpid()); err != nil {
+		os.Remove(lockPath)
+		return nil, err
+	}
+	return &Lock{path: path, lockPath: lockPath}, nil
+}
+
+// Path returns locked path
+func (lk *Lock) Path() string {
+	return lk.path
+}
+
+// Unlock releases lock
+func (lk *Lock) Unlock() error {
+	if lk.lockPath != "" {
+		if err := os.Remove(lk.lockPath); err != nil {
+			if lk.file != nil {
+				lk.file.Close()
+			}
+			return errors.WrapError(err, ERROR_UNLOCKING_FILE, lk.path)
+		}
+	}
+	if lk.file == nil {
+		return nil
+	}
+	// closing releases flock
+	if err := lk.file.Close(); err != nil {
+		return errors.WrapError(err, ERROR_UNLOCKING_FILE, lk.path)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package lock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+)
+
+const TEST_DIR = "data"
+
+func TestLock(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	for name, lockFile := range map[string]bool{"flock": false, "lock file": true} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			path := filepath.Join(TEST_DIR, "filings.json")
+			require.NoError(t, os.MkdirAll(TEST_DIR, os.ModePerm))
+			require.NoError(t, os.RemoveAll(path))
+
+			l, err := NewLocker(Config{Timeout: 50 * time.Millisecond, LockFile: lockFile}, logger)
+			require.NoError(t, err)
+
+			lk, err := l.Lock(ctx, path)
+			require.NoError(t, err)
+			require.Equal(t, path, lk.Path())
+			// missing paths aren't created by locks
+			require.NoFileExists(t, path)
+
+			// held exclusive lock blocks till timeout
+			_, err = l.Lock(ctx, path)
+			require.Error(t, err)
+			_, err = l.RLock(ctx, path)
+			require.Error(t, err)
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err = l.Lock(cancelled, path)
+			require.ErrorIs(t, err, context.Canceled)
+
+			// waiting lock is acquired once released
+			held := lk
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				held.Unlock()
+			}()
+			lk, err = l.Lock(ctx, path)
+			require.NoError(t, err)
+
+			// lock of missing path holds path once it's created
+			require.NoError(t, os.WriteFile(path, []byte("[]"), os.ModePerm))
+			_, err = l.Lock(ctx, path)
+			require.Error(t, err)
+			_, err = l.RLock(ctx, path)
+			require.Error(t, err)
+			require.NoError(t, lk.Unlock())
+			require.NoFileExists(t, path+DEFAULT_LOCK_SUFFIX)
+
+			r1, err := l.RLock(ctx, path)
+			require.NoError(t, err)
+			if lockFile {
+				// shared locks are exclusive in lock file mode
+				_, err = l.RLock(ctx, path)
+				require.Error(t, err)
+			} else {
+				r2, err := l.RLock(ctx, path)
+				require.NoError(t, err)
+				_, err = l.Lock(ctx, path)
+				require.Error(t, err)
+				require.NoError(t, r2.Unlock())
+			}
+			require.NoError(t, r1.Unlock())
+		})
+	}
+}
+
+func TestStaleLockFile(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	path := filepath.Join(TEST_DIR, "agents.csv")
+	err := os.MkdirAll(TEST_DIR, os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(path+DEFAULT_LOCK_SUFFIX, nil, 0644)
+	require.NoError(t, err)
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(path+DEFAULT_LOCK_SUFFIX, old, old))
+
+	l, err := NewLocker(Config{Timeout: 50 * time.Millisecond, LockFile: true}, logger)
+	require.NoError(t, err)
+	_, err = l.Lock(context.Background(), path)
+	require.Error(t, err)
+
+	l, err = NewLocker(Config{Timeout: 50 * time.Millisecond, LockFile: true, Stale: time.Minute}, logger)
+	require.NoError(t, err)
+	lk, err := l.Lock(context.Background(), path)
+	require.NoError(t, err)
+	require.NoError(t, lk.Unlock())
+	_, err = os.Stat(path + DEFAULT_LOCK_SUFFIX)
+	require.True(t, os.IsNotExist(err))
+}
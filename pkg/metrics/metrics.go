@@ -0,0 +1,35 @@
+package metrics
+
+import "time"
+
+// Metrics records I/O metrics of storage methods, labelled by method name
+type Metrics interface {
+	// AddBytesRead & AddBytesWritten count file bytes read & written
+	AddBytesRead(method string, n int64)
+	AddBytesWritten(method string, n int64)
+	// AddRecordsRead & AddRecordsWritten count records decoded & encoded
+	AddRecordsRead(method string, n int)
+	AddRecordsWritten(method string, n int)
+	// AddDecodeErrors counts read stream errors
+	AddDecodeErrors(method string, n int)
+	// ObserveLatency records an operation's duration & result,
+	// streaming operations are observed once their stream is done
+	ObserveLatency(method string, d time.Duration, err error)
+	// StreamStarted & StreamEnded track in-flight streams
+	StreamStarted(method string)
+	StreamEnded(method string)
+}
+
+// Noop discards metrics
+type Noop struct{}
+
+var _ Metrics = Noop{}
+
+func (Noop) AddBytesRead(method string, n int64)                      {}
+func (Noop) AddBytesWritten(method string, n int64)                   {}
+func (Noop) AddRecordsRead(method string, n int)                      {}
+func (Noop) AddRecordsWritten(method string, n int)                   {}
+func (Noop) AddDecodeErrors(method string, n int)                     {}
+func (Noop) ObserveLatency(method string, d time.Duration, err error) {}
+func (Noop) StreamStarted(method string)                              {}
+func (Noop) StreamEnded(method string)                                {}
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// NAMESPACE prefixes exposed metric names
+	NAMESPACE = "localstorage"
+	// CONTENT_TYPE is prometheus text exposition format content type
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+	RESULT_OK    = "ok"
+	RESULT_ERROR = "error"
+)
+
+// DEFAULT_BUCKETS are latency histogram upper bounds in seconds
+var DEFAULT_BUCKETS = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Prometheus keeps metrics in memory & serves them in prometheus text exposition format
+type Prometheus struct {
+	mu       sync.Mutex
+	buckets  []float64
+	counters map[string]map[string]float64
+	gauges   map[string]map[string]float64
+	hists    map[string]*histogram
+}
+
+var _ Metrics = (*Prometheus)(nil)
+var _ http.Handler = (*Prometheus)(nil)
+
+// NewPrometheus returns prometheus metrics with given latency buckets, in seconds,
+// or DEFAULT_BUCKETS
+func NewPrometheus(buckets []float64) *Prometheus {
+	if len(buckets) == 0 {
+		buckets = DEFAULT_BUCKETS
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Prometheus{
+		buckets:  buckets,
+		counters: map[string]map[string]float64{},
+		gauges:   map[string]map[string]float64{},
+		hists:    map[string]*histogram{},
+	}
+}
+
+// metric name, help & type
+var help = []struct {
+	name, help, typ string
+}{
+	{"bytes_read_total", "File bytes read.", "counter"},
+	{"bytes_written_total", "File bytes written.", "counter"},
+	{"records_read_total", "Records decoded.", "counter"},
+	{"records_written_total", "Records encoded.", "counter"},
+	{"decode_errors_total", "Read stream errors.", "counter"},
+	{"operations_total", "Completed operations by result.", "counter"},
+	{"streams_in_flight", "Open read & write streams.", "gauge"},
+	{"operation_duration_seconds", "Operation latency.", "histogram"},
+}
+
+func (p *Prometheus) add(name, labels string, v float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m, ok := p.counters[name]
+	if !ok {
+		m = map[string]float64{}
+		p.counters[name] = m
+	}
+	m[labels] += v
+}
+
+func (p *Prometheus) AddBytesRead(method string, n int64) {
+	p.add("bytes_read_total", methodLabel(method), float64(n))
+}
+
+func (p *Prometheus) AddBytesWritten(method string, n int64) {
+	p.add("bytes_written_total", methodLabel(method), float64(n))
+}
+
+func (p *Prometheus) AddRecordsRead(method string, n int) {
+	p.add("records_read_total", methodLabel(method), float64(n))
+}
+
+func (p *Prometheus) AddRecordsWritten(method string, n int) {
+	p.add("records_written_total", methodLabel(method), float64(n))
+}
+
+func (p *Prometheus) AddDecodeErrors(method string, n int) {
+	p.add("decode_errors_total", methodLabel(method), float64(n))
+}
+
+func (p *Prometheus) ObserveLatency(method string, d time.Duration, err error) {
+	result := RESULT_OK
+	if err != nil {
+		result = RESULT_ERROR
+	}
+	p.add("operations_total", fmt.Sprintf(`method="%s",result="%s"`, escape(method), result), 1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	labels := methodLabel(method)
+	h, ok := p.hists[labels]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.hists[labels] = h
+	}
+	secs := d.Seconds()
+	for i, b := range p.buckets {
+		if secs <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+func (p *Prometheus) StreamStarted(method string) {
+	p.gauge(method, 1)
+}
+
+func (p *Prometheus) StreamEnded(method string) {
+	p.gauge(method, -1)
+}
+
+func (p *Prometheus) gauge(method string, delta float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m, ok := p.gauges["streams_in_flight"]
+	if !ok {
+		m = map[string]float64{}
+		p.gauges["streams_in_flight"] = m
+	}
+	m[methodLabel(method)] += delta
+}
+
+// ServeHTTP writes metrics in prometheus text exposition format
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	bw := bufio.NewWriter(w)
+	p.Write(bw)
+	bw.Flush()
+}
+
+// Write writes metrics in prometheus text exposition format, sorted by name & labels
+func (p *Prometheus) Write(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, m := range help {
+		name := NAMESPACE + "_" + m.name
+		switch m.typ {
+		case "counter", "gauge":
+			values := p.counters[m.name]
+			if m.typ == "gauge" {
+				values = p.gauges[m.name]
+			}
+			if len(values) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.typ)
+			for _, labels := range sortedKeys(values) {
+				fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(values[labels]))
+			}
+		case "histogram":
+			if len(p.hists) == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, m.help, name, m.typ)
+			for _, labels := range sortedKeys(p.hists) {
+				h := p.hists[labels]
+				for i, b := range p.buckets {
+					fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(b), h.counts[i])
+				}
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+				fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+				fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+			}
+		}
+	}
+}
+
+func methodLabel(method string) string {
+	return fmt.Sprintf(`method="%s"`, escape(method))
+}
+
+// escape escapes label value as per exposition format
+func escape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusWrite(t *testing.T) {
+	p := NewPrometheus([]float64{0.5, 0.01})
+
+	p.AddBytesRead("ReadFile", 100)
+	p.AddBytesRead("ReadFile", 28)
+	p.AddRecordsRead("ReadFile", 3)
+	p.AddDecodeErrors("ReadFile", 1)
+	p.AddBytesWritten("Write\"File", 64)
+	p.StreamStarted("ReadFile")
+	p.StreamStarted("ReadFile")
+	p.StreamEnded("ReadFile")
+	p.ObserveLatency("ReadFile", 250*time.Microsecond, nil)
+	p.ObserveLatency("ReadFile", 250*time.Millisecond, errors.New("failed"))
+
+	var sb strings.Builder
+	p.Write(&sb)
+	out := sb.String()
+
+	for _, line := range []string{
+		"# TYPE localstorage_bytes_read_total counter",
+		`localstorage_bytes_read_total{method="ReadFile"} 128`,
+		`localstorage_bytes_written_total{method="Write\"File"} 64`,
+		`localstorage_records_read_total{method="ReadFile"} 3`,
+		`localstorage_decode_errors_total{method="ReadFile"} 1`,
+		`localstorage_operations_total{method="ReadFile",result="error"} 1`,
+		`localstorage_operations_total{method="ReadFile",result="ok"} 1`,
+		"# TYPE localstorage_streams_in_flight gauge",
+		`localstorage_streams_in_flight{method="ReadFile"} 1`,
+		"# TYPE localstorage_operation_duration_seconds histogram",
+		`localstorage_operation_duration_seconds_bucket{method="ReadFile",le="0.01"} 1`,
+		`localstorage_operation_duration_seconds_bucket{method="ReadFile",le="0.5"} 2`,
+		`localstorage_operation_duration_seconds_bucket{method="ReadFile",le="+Inf"} 2`,
+		`localstorage_operation_duration_seconds_sum{method="ReadFile"} 0.25025`,
+		`localstorage_operation_duration_seconds_count{method="ReadFile"} 2`,
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+	// unrecorded metrics aren't exposed
+	require.NotContains(t, out, "records_written_total")
+	// counters are sorted by labels
+	require.Less(t, strings.Index(out, `result="error"`), strings.Index(out, `result="ok"`))
+}
+
+func TestPrometheusServeHTTP(t *testing.T) {
+	p := NewPrometheus(nil)
+	p.AddRecordsWritten("WriteFile", 2)
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	resp := rec.Result()
+	require.Equal(t, 200, resp.StatusCode)
+	require.Equal(t, CONTENT_TYPE, resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `localstorage_records_written_total{method="WriteFile"} 2`)
+}
+
+func TestNoop(t *testing.T) {
+	var m Metrics = Noop{}
+	m.AddBytesRead("ReadFile", 1)
+	m.ObserveLatency("ReadFile", time.Second, nil)
+	m.StreamStarted("ReadFile")
+	m.StreamEnded("ReadFile")
+}
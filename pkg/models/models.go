@@ -45,3 +45,10 @@ type BusinessPrincipal struct {
 	Address      string
 	PositionType string
 }
+
+// BusinessEntity is a business filing combined with its agents & principals
+type BusinessEntity struct {
+	Filing     BusinessFiling      `json:"filing"`
+	Agents     []BusinessAgent     `json:"agents"`
+	Principals []BusinessPrincipal `json:"principals"`
+}
@@ -0,0 +1,256 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	ERROR_TIMEOUT string = "timed out after %s"
+	ERROR_PANIC   string = "panic: %v"
+)
+
+// Func processes a record, returning nil record drops it,
+// it should return once context is done
+type Func func(ctx context.Context, r models.JSONMapper) (models.JSONMapper, error)
+
+type Config struct {
+	// Workers is number of records processed at once, defaults to number of CPUs
+	Workers int
+	// Ordered sends results in input order, otherwise as they complete
+	Ordered bool
+	// Buffer bounds ordered results waiting for earlier records, defaults to 2 x workers
+	Buffer int
+	// Retries is number of retries of a failed record, waiting Backoff, doubled each retry
+	Retries int
+	Backoff time.Duration
+	// Retryable, if set, limits retries to errors it accepts
+	Retryable func(err error) bool
+	// Timeout, if set, limits each attempt
+	Timeout time.Duration
+}
+
+// RecordError is a record's processing error, after retries
+type RecordError struct {
+	Index    int
+	Attempts int
+	Err      error
+}
+
+func (e RecordError) Error() string {
+	return fmt.Sprintf("record %d: %d attempts: %v", e.Index, e.Attempts, e.Err)
+}
+
+func (e RecordError) Unwrap() error {
+	return e.Err
+}
+
+type Processor struct {
+	config Config
+	fn     Func
+	logger logger.AppLogger
+}
+
+func NewProcessor(cfg Config, fn Func, logger logger.AppLogger) (*Processor, error) {
+	if fn == nil || logger == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = 2 * cfg.Workers
+	}
+	return &Processor{
+		config: cfg,
+		fn:     fn,
+		logger: logger,
+	}, nil
+}
+
+// job is a record being processed, result is sent on its buffered result chan
+type job struct {
+	index  int
+	record models.JSONMapper
+	result chan outcome
+}
+
+type outcome struct {
+	record models.JSONMapper
+	err    error
+}
+
+// ProcessStream takes context, input chan, output chan & err chan
+// processes input records with configured workers, sends results on output chan
+// & record errors, as RecordError, on err chan
+// input is read only as fast as results are taken
+// closes output and err channels on done
+func (p *Processor) ProcessStream(ctx context.Context, inCh <-chan models.JSONMapper, outCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(outCh)
+		close(errCh)
+	}()
+
+	send := func(j job, o outcome) bool {
+		if o.err != nil {
+			select {
+			case <-ctx.Done():
+				return false
+			case errCh <- o.err:
+				return true
+			}
+		}
+		if o.record == nil {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case outCh <- o.record:
+			return true
+		}
+	}
+
+	jobs := make(chan job)
+	// ordered jobs waiting to be sent, bounds ordered results held
+	var pending chan job
+	if p.config.Ordered {
+		pending = make(chan job, p.config.Buffer)
+	}
+
+	go func() {
+		defer close(jobs)
+		if pending != nil {
+			defer close(pending)
+		}
+		for i := 0; ; i++ {
+			var j job
+			select {
+			case <-ctx.Done():
+				return
+			case r, ok := <-inCh:
+				if !ok {
+					return
+				}
+				j = job{index: i, record: r, result: make(chan outcome, 1)}
+			}
+			if pending != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case pending <- j:
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- j:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < p.config.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				o := p.run(ctx, j)
+				if pending != nil {
+					j.result <- o
+					continue
+				}
+				if !send(j, o) {
+					return
+				}
+			}
+		}()
+	}
+
+	if pending != nil {
+		for j := range pending {
+			var o outcome
+			select {
+			case <-ctx.Done():
+				wg.Wait()
+				return
+			case o = <-j.result:
+			}
+			if !send(j, o) {
+				break
+			}
+		}
+	}
+	wg.Wait()
+}
+
+// run processes job's record, retrying failed attempts as configured
+func (p *Processor) run(ctx context.Context, j job) outcome {
+	backoff := p.config.Backoff
+	attempts := 0
+	for {
+		attempts++
+		r, err := p.attempt(ctx, j.record)
+		if err == nil {
+			return outcome{record: r}
+		}
+		if ctx.Err() != nil || attempts > p.config.Retries || (p.config.Retryable != nil && !p.config.Retryable(err)) {
+			return outcome{err: RecordError{Index: j.index, Attempts: attempts, Err: err}}
+		}
+
+		p.logger.Debug("retrying record", zap.Int("index", j.index), zap.Int("attempts", attempts), zap.Error(err))
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return outcome{err: RecordError{Index: j.index, Attempts: attempts, Err: err}}
+			case <-timer.C:
+			}
+			backoff *= 2
+		}
+	}
+}
+
+// attempt calls processing func, recovering panics,
+// with timeout set, returns once it's reached even if func hasn't
+func (p *Processor) attempt(ctx context.Context, r models.JSONMapper) (models.JSONMapper, error) {
+	if p.config.Timeout <= 0 {
+		return p.call(ctx, r)
+	}
+
+	actx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := p.call(actx, r)
+		done <- outcome{record: res, err: err}
+	}()
+	select {
+	case o := <-done:
+		return o.record, o.err
+	case <-actx.Done():
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.NewAppError(ERROR_TIMEOUT, p.config.Timeout)
+	}
+}
+
+func (p *Processor) call(ctx context.Context, r models.JSONMapper) (res models.JSONMapper, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = errors.NewAppError(ERROR_PANIC, rec)
+		}
+	}()
+	return p.fn(ctx, r)
+}
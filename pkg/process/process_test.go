@@ -0,0 +1,205 @@
+package process
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const TEST_DIR = "data"
+
+func records(n int) chan models.JSONMapper {
+	inCh := make(chan models.JSONMapper)
+	go func() {
+		defer close(inCh)
+		for i := 0; i < n; i++ {
+			inCh <- models.JSONMapper{"index": i}
+		}
+	}()
+	return inCh
+}
+
+func run(t *testing.T, p *Processor, inCh chan models.JSONMapper) ([]models.JSONMapper, []error) {
+	outCh, errCh := make(chan models.JSONMapper), make(chan error)
+	go p.ProcessStream(context.Background(), inCh, outCh, errCh)
+
+	results, errs := []models.JSONMapper{}, []error{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for err := range errCh {
+			errs = append(errs, err)
+		}
+	}()
+	for r := range outCh {
+		results = append(results, r)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+func TestProcessOrdered(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	p, err := NewProcessor(Config{Workers: 4, Ordered: true}, func(ctx context.Context, r models.JSONMapper) (models.JSONMapper, error) {
+		i := r["index"].(int)
+		// later records finish first
+		time.Sleep(time.Duration(10-i%10) * time.Millisecond)
+		if i%5 == 4 {
+			return nil, nil
+		}
+		return models.JSONMapper{"index": i, "double": 2 * i}, nil
+	}, logger)
+	require.NoError(t, err)
+
+	results, errs := run(t, p, records(50))
+	require.Equal(t, 0, len(errs))
+	require.Equal(t, 40, len(results))
+	prev := -1
+	for _, r := range results {
+		i := r["index"].(int)
+		require.Greater(t, i, prev)
+		require.Equal(t, 2*i, r["double"])
+		prev = i
+	}
+}
+
+func TestProcessUnordered(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	var running, maxRunning int32
+	p, err := NewProcessor(Config{Workers: 3}, func(ctx context.Context, r models.JSONMapper) (models.JSONMapper, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return r, nil
+	}, logger)
+	require.NoError(t, err)
+
+	results, errs := run(t, p, records(30))
+	require.Equal(t, 0, len(errs))
+	require.Equal(t, 30, len(results))
+	require.LessOrEqual(t, maxRunning, int32(3))
+}
+
+func TestProcessRetryTimeout(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	errFlaky, errFatal := errors.New("flaky"), errors.New("fatal")
+	var mu sync.Mutex
+	attempts := map[int]int{}
+	p, err := NewProcessor(Config{
+		Workers:   2,
+		Ordered:   true,
+		Retries:   2,
+		Backoff:   time.Millisecond,
+		Retryable: func(err error) bool { return err != errFatal },
+		Timeout:   50 * time.Millisecond,
+	}, func(ctx context.Context, r models.JSONMapper) (models.JSONMapper, error) {
+		i := r["index"].(int)
+		mu.Lock()
+		attempts[i]++
+		n := attempts[i]
+		mu.Unlock()
+		switch i {
+		case 0:
+			// succeeds on last retry
+			if n < 3 {
+				return nil, errFlaky
+			}
+		case 1:
+			return nil, errFlaky
+		case 2:
+			return nil, errFatal
+		case 3:
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case 4:
+			panic("bad record")
+		}
+		return r, nil
+	}, logger)
+	require.NoError(t, err)
+
+	results, errs := run(t, p, records(6))
+	require.Equal(t, []models.JSONMapper{{"index": 0}, {"index": 5}}, results)
+	require.Equal(t, 4, len(errs))
+
+	byIndex := map[int]RecordError{}
+	for _, err := range errs {
+		var re RecordError
+		require.True(t, errors.As(err, &re))
+		byIndex[re.Index] = re
+	}
+	require.Equal(t, 3, byIndex[1].Attempts)
+	require.ErrorIs(t, byIndex[1], errFlaky)
+	require.Equal(t, 1, byIndex[2].Attempts)
+	require.Equal(t, 3, byIndex[3].Attempts)
+	require.Contains(t, byIndex[3].Error(), "timed out")
+	require.Contains(t, byIndex[4].Error(), "bad record")
+}
+
+func TestProcessBackpressure(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	for _, ordered := range []bool{true, false} {
+		p, err := NewProcessor(Config{Workers: 2, Ordered: ordered, Buffer: 3}, func(ctx context.Context, r models.JSONMapper) (models.JSONMapper, error) {
+			return r, nil
+		}, logger)
+		require.NoError(t, err)
+
+		var read int32
+		inCh := make(chan models.JSONMapper)
+		go func() {
+			defer close(inCh)
+			for i := 0; i < 100; i++ {
+				inCh <- models.JSONMapper{"index": i}
+				atomic.AddInt32(&read, 1)
+			}
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		outCh, errCh := make(chan models.JSONMapper), make(chan error)
+		go p.ProcessStream(ctx, inCh, outCh, errCh)
+
+		// nothing taken, input read stops at workers & buffer
+		time.Sleep(50 * time.Millisecond)
+		require.LessOrEqual(t, atomic.LoadInt32(&read), int32(2+3+2))
+
+		cancel()
+		for range outCh {
+		}
+	}
+}
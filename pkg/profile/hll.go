@@ -0,0 +1,93 @@
+package profile
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// DEFAULT_PRECISION uses 2^14 registers, for about 0.8% standard error
+	DEFAULT_PRECISION uint8 = 14
+	MIN_PRECISION     uint8 = 4
+	MAX_PRECISION     uint8 = 18
+)
+
+// HyperLogLog estimates count of distinct values in fixed memory
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+// NewHyperLogLog returns estimator with 2^precision registers,
+// precision is clamped to supported range
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	if precision < MIN_PRECISION {
+		precision = MIN_PRECISION
+	}
+	if precision > MAX_PRECISION {
+		precision = MAX_PRECISION
+	}
+	return &HyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+// Add adds value to the estimate
+func (h *HyperLogLog) Add(value string) {
+	x := hash(value)
+	idx := x >> (64 - h.precision)
+	// remaining bits, with a sentinel bit bounding the rank
+	w := x<<h.precision | 1<<(h.precision-1)
+	rank := uint8(bits.LeadingZeros64(w)) + 1
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+// Merge adds other estimate's values, estimates must have same precision
+func (h *HyperLogLog) Merge(other *HyperLogLog) {
+	if other == nil || other.precision != h.precision {
+		return
+	}
+	for i, r := range other.registers {
+		if r > h.registers[i] {
+			h.registers[i] = r
+		}
+	}
+}
+
+// Estimate returns estimated count of distinct values added
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	// small ranges are more accurately counted from empty registers
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+// hash returns well mixed 64 bit hash of value
+func hash(value string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(value))
+	x := f.Sum64()
+	// splitmix64 finalizer spreads fnv's weak high bits
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,360 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"strconv"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/convert"
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	TYPE_NULL    string = "null"
+	TYPE_BOOLEAN string = "boolean"
+	TYPE_INTEGER string = "integer"
+	TYPE_NUMBER  string = "number"
+	TYPE_STRING  string = "string"
+	TYPE_OBJECT  string = "object"
+	TYPE_ARRAY   string = "array"
+)
+
+type Config struct {
+	// Columns, if set, limits profiled columns, in order
+	Columns []string
+	// InferTypes infers numbers, booleans & nulls of string values, e.g. csv fields
+	InferTypes bool
+	// Precision of distinct value estimates, defaults to DEFAULT_PRECISION
+	Precision uint8
+}
+
+type Profile struct {
+	Records int              `json:"records"`
+	Columns []*ColumnProfile `json:"columns"`
+}
+
+// Column returns named column profile, nil if not profiled
+func (p *Profile) Column(name string) *ColumnProfile {
+	for _, c := range p.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+type ColumnProfile struct {
+	Name string `json:"name"`
+	// Nulls counts records with null or missing column
+	Nulls int `json:"nulls"`
+	// Empty counts empty string values
+	Empty int `json:"empty"`
+	// Distinct estimates distinct non null, non empty values
+	Distinct uint64 `json:"distinct"`
+	// Type is most common inferred type of non null, non empty values,
+	// integer columns with some fractions are numbers
+	Type  string         `json:"type"`
+	Types map[string]int `json:"types"`
+	// Min & Max are numeric for number columns, otherwise least & greatest text
+	Min       interface{}    `json:"min,omitempty"`
+	Max       interface{}    `json:"max,omitempty"`
+	MinLength int            `json:"min_length"`
+	MaxLength int            `json:"max_length"`
+	AvgLength float64        `json:"avg_length"`
+	Lengths   []LengthBucket `json:"lengths"`
+}
+
+// LengthBucket counts non null values with text length in [Min, Max]
+type LengthBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Count int `json:"count"`
+}
+
+// column accumulates column profile
+type column struct {
+	name      string
+	present   int
+	nulls     int
+	empty     int
+	hll       *HyperLogLog
+	types     map[string]int
+	minNum    *float64
+	maxNum    *float64
+	minText   *string
+	maxText   *string
+	lengths   int
+	minLength int
+	maxLength int
+	totalLen  int
+	// buckets count lengths by bit length, 0, 1, 2-3, 4-7...
+	buckets [65]int
+}
+
+type Profiler struct {
+	config  Config
+	records int
+	columns map[string]*column
+	// order is columns in order first seen, or configured
+	order  []string
+	logger logger.AppLogger
+}
+
+func NewProfiler(cfg Config, logger logger.AppLogger) (*Profiler, error) {
+	if logger == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	if cfg.Precision == 0 {
+		cfg.Precision = DEFAULT_PRECISION
+	}
+	p := &Profiler{
+		config:  cfg,
+		columns: map[string]*column{},
+		logger:  logger,
+	}
+	for _, name := range cfg.Columns {
+		p.column(name)
+	}
+	return p, nil
+}
+
+// AddColumns registers columns, e.g. csv headers, so they're reported in given order
+func (p *Profiler) AddColumns(names ...string) {
+	if len(p.config.Columns) > 0 {
+		return
+	}
+	for _, name := range names {
+		p.column(name)
+	}
+}
+
+// Add adds record to profile
+func (p *Profiler) Add(r models.JSONMapper) {
+	p.records++
+	if len(p.config.Columns) > 0 {
+		for _, name := range p.config.Columns {
+			if v, ok := r[name]; ok {
+				p.columns[name].add(v, p.config.InferTypes)
+			}
+		}
+		return
+	}
+	for name, v := range r {
+		p.column(name).add(v, p.config.InferTypes)
+	}
+}
+
+// ProfileStream adds records of in chan until closed & returns profile,
+// returns on context done with context error
+func (p *Profiler) ProfileStream(ctx context.Context, inCh <-chan models.JSONMapper) (*Profile, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case r, ok := <-inCh:
+			if !ok {
+				p.logger.Debug("profiled records", zap.Int("records", p.records), zap.Int("columns", len(p.order)))
+				return p.Profile(), nil
+			}
+			p.Add(r)
+		}
+	}
+}
+
+// Profile returns profile of records added so far
+func (p *Profiler) Profile() *Profile {
+	prof := &Profile{
+		Records: p.records,
+		Columns: make([]*ColumnProfile, 0, len(p.order)),
+	}
+	for _, name := range p.order {
+		prof.Columns = append(prof.Columns, p.columns[name].profile(p.records))
+	}
+	return prof
+}
+
+func (p *Profiler) column(name string) *column {
+	c, ok := p.columns[name]
+	if !ok {
+		c = &column{
+			name:  name,
+			hll:   NewHyperLogLog(p.config.Precision),
+			types: map[string]int{},
+		}
+		p.columns[name] = c
+		p.order = append(p.order, name)
+	}
+	return c
+}
+
+func (c *column) add(v interface{}, infer bool) {
+	c.present++
+	if s, ok := v.(string); ok {
+		if s == "" {
+			c.empty++
+			c.addLength(0)
+			return
+		}
+		if infer {
+			v = convert.InferValue(s)
+		}
+	}
+
+	typ := TypeOf(v)
+	if typ == TYPE_NULL {
+		c.nulls++
+		return
+	}
+	c.types[typ]++
+
+	text := Text(v)
+	c.hll.Add(text)
+	c.addLength(len([]rune(text)))
+
+	if typ == TYPE_INTEGER || typ == TYPE_NUMBER {
+		if n, err := strconv.ParseFloat(text, 64); err == nil {
+			if c.minNum == nil || n < *c.minNum {
+				c.minNum = &n
+			}
+			if c.maxNum == nil || n > *c.maxNum {
+				c.maxNum = &n
+			}
+		}
+	}
+	if c.minText == nil || text < *c.minText {
+		c.minText = &text
+	}
+	if c.maxText == nil || text > *c.maxText {
+		c.maxText = &text
+	}
+}
+
+func (c *column) addLength(n int) {
+	if c.lengths == 0 || n < c.minLength {
+		c.minLength = n
+	}
+	if n > c.maxLength {
+		c.maxLength = n
+	}
+	c.lengths++
+	c.totalLen += n
+	c.buckets[bits.Len(uint(n))]++
+}
+
+func (c *column) profile(records int) *ColumnProfile {
+	cp := &ColumnProfile{
+		Name:      c.name,
+		Nulls:     records - c.present + c.nulls,
+		Empty:     c.empty,
+		Distinct:  c.hll.Estimate(),
+		Type:      dominantType(c.types),
+		Types:     c.types,
+		MinLength: c.minLength,
+		MaxLength: c.maxLength,
+		Lengths:   []LengthBucket{},
+	}
+	if c.lengths > 0 {
+		cp.AvgLength = float64(c.totalLen) / float64(c.lengths)
+	}
+	for i, count := range c.buckets {
+		if count == 0 {
+			continue
+		}
+		b := LengthBucket{Count: count}
+		if i > 0 {
+			b.Min, b.Max = 1<<(i-1), 1<<i-1
+		}
+		cp.Lengths = append(cp.Lengths, b)
+	}
+
+	if (cp.Type == TYPE_INTEGER || cp.Type == TYPE_NUMBER) && c.minNum != nil {
+		cp.Min, cp.Max = *c.minNum, *c.maxNum
+	} else if c.minText != nil {
+		cp.Min, cp.Max = *c.minText, *c.maxText
+	}
+	return cp
+}
+
+// dominantType returns most common type, integers & numbers together make a number column
+func dominantType(types map[string]int) string {
+	if len(types) == 0 {
+		return TYPE_NULL
+	}
+	counts := map[string]int{}
+	for t, n := range types {
+		counts[t] = n
+	}
+	if counts[TYPE_INTEGER] > 0 && counts[TYPE_NUMBER] > 0 {
+		counts[TYPE_NUMBER] += counts[TYPE_INTEGER]
+		delete(counts, TYPE_INTEGER)
+	}
+
+	dominant, max := "", 0
+	// fixed order breaks ties
+	for _, t := range []string{TYPE_STRING, TYPE_NUMBER, TYPE_INTEGER, TYPE_BOOLEAN, TYPE_OBJECT, TYPE_ARRAY} {
+		if counts[t] > max {
+			dominant, max = t, counts[t]
+		}
+	}
+	return dominant
+}
+
+// TypeOf returns json type name of decoded or inferred value,
+// whole numbers are integers
+func TypeOf(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return TYPE_NULL
+	case bool:
+		return TYPE_BOOLEAN
+	case float64:
+		if val == float64(int64(val)) {
+			return TYPE_INTEGER
+		}
+		return TYPE_NUMBER
+	case float32:
+		return TypeOf(float64(val))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return TYPE_INTEGER
+	case json.Number:
+		if _, err := val.Int64(); err == nil {
+			return TYPE_INTEGER
+		}
+		return TYPE_NUMBER
+	case string:
+		return TYPE_STRING
+	case map[string]interface{}:
+		return TYPE_OBJECT
+	case []interface{}:
+		return TYPE_ARRAY
+	}
+	return TYPE_STRING
+}
+
+// Text returns text form of value, json for objects & arrays
+func Text(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(val)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,142 @@
+package profile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const TEST_DIR = "data"
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		t.Run(fmt.Sprintf("%d distinct values", n), func(t *testing.T) {
+			h := NewHyperLogLog(DEFAULT_PRECISION)
+			for i := 0; i < n; i++ {
+				h.Add(fmt.Sprintf("C%07d", i))
+				// repeats don't count
+				h.Add(fmt.Sprintf("C%07d", i))
+			}
+			estimate := float64(h.Estimate())
+			require.LessOrEqual(t, math.Abs(estimate-float64(n)), math.Max(1, float64(n)*0.03), "estimate %v", estimate)
+		})
+	}
+
+	a, b := NewHyperLogLog(10), NewHyperLogLog(10)
+	for i := 0; i < 500; i++ {
+		a.Add(fmt.Sprint(i))
+		b.Add(fmt.Sprint(i + 250))
+	}
+	a.Merge(b)
+	require.InDelta(t, 750, float64(a.Estimate()), 750*0.1)
+}
+
+func TestProfile(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	p, err := NewProfiler(Config{InferTypes: true}, logger)
+	require.NoError(t, err)
+	p.AddColumns("entity_num", "employees", "state", "active")
+
+	records := []models.JSONMapper{
+		{"entity_num": "C0001", "employees": "12", "state": "CA", "active": "true"},
+		{"entity_num": "C0002", "employees": "3.5", "state": "", "active": "false"},
+		{"entity_num": "C0003", "employees": "", "state": "NV", "active": "true", "agent": "Wong"},
+		{"entity_num": "C0003", "employees": "150", "state": "CA"},
+	}
+	inCh := make(chan models.JSONMapper)
+	go func() {
+		defer close(inCh)
+		for _, r := range records {
+			inCh <- r
+		}
+	}()
+	prof, err := p.ProfileStream(context.Background(), inCh)
+	require.NoError(t, err)
+	require.Equal(t, 4, prof.Records)
+	require.Equal(t, 5, len(prof.Columns))
+	require.Equal(t, "agent", prof.Columns[4].Name)
+
+	entityNum := prof.Column("entity_num")
+	require.Equal(t, TYPE_STRING, entityNum.Type)
+	require.Equal(t, uint64(3), entityNum.Distinct)
+	require.Equal(t, "C0001", entityNum.Min)
+	require.Equal(t, "C0003", entityNum.Max)
+	require.Equal(t, 5, entityNum.MinLength)
+	require.Equal(t, 5, entityNum.MaxLength)
+	require.Equal(t, []LengthBucket{{Min: 4, Max: 7, Count: 4}}, entityNum.Lengths)
+
+	employees := prof.Column("employees")
+	require.Equal(t, TYPE_NUMBER, employees.Type)
+	require.Equal(t, map[string]int{TYPE_INTEGER: 2, TYPE_NUMBER: 1}, employees.Types)
+	require.Equal(t, 1, employees.Empty)
+	require.Equal(t, 0, employees.Nulls)
+	require.Equal(t, 3.5, employees.Min)
+	require.Equal(t, 150.0, employees.Max)
+	require.Equal(t, 0, employees.MinLength)
+	require.Equal(t, 3, employees.MaxLength)
+
+	require.Equal(t, 1, prof.Column("state").Empty)
+	require.Equal(t, uint64(2), prof.Column("state").Distinct)
+
+	active := prof.Column("active")
+	require.Equal(t, TYPE_BOOLEAN, active.Type)
+	require.Equal(t, 1, active.Nulls)
+
+	agent := prof.Column("agent")
+	require.Equal(t, 3, agent.Nulls)
+
+	_, err = json.Marshal(prof)
+	require.NoError(t, err)
+}
+
+func TestProfileColumns(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	p, err := NewProfiler(Config{Columns: []string{"score", "name"}}, logger)
+	require.NoError(t, err)
+	p.AddColumns("ignored")
+
+	p.Add(models.JSONMapper{"name": "Plaza", "score": 1.0, "other": "x"})
+	p.Add(models.JSONMapper{"name": nil, "score": 2.5})
+	p.Add(models.JSONMapper{"score": json.Number("10")})
+
+	prof := p.Profile()
+	require.Equal(t, []string{"score", "name"}, []string{prof.Columns[0].Name, prof.Columns[1].Name})
+	require.Equal(t, TYPE_NUMBER, prof.Columns[0].Type)
+	require.Equal(t, 1.0, prof.Columns[0].Min)
+	require.Equal(t, 10.0, prof.Columns[0].Max)
+	require.Equal(t, 2, prof.Columns[1].Nulls)
+}
+
+func TestTypeOf(t *testing.T) {
+	for v, typ := range map[interface{}]string{
+		nil:                 TYPE_NULL,
+		true:                TYPE_BOOLEAN,
+		3.0:                 TYPE_INTEGER,
+		3.5:                 TYPE_NUMBER,
+		json.Number("7"):    TYPE_INTEGER,
+		json.Number("7e-1"): TYPE_NUMBER,
+		"C0001":             TYPE_STRING,
+	} {
+		require.Equal(t, typ, TypeOf(v), "%v", v)
+	}
+	require.Equal(t, TYPE_OBJECT, TypeOf(map[string]interface{}{}))
+	require.Equal(t, TYPE_ARRAY, TypeOf([]interface{}{}))
+}
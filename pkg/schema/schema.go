@@ -0,0 +1,364 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/comfforts/errors"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const (
+	ERROR_READING_SCHEMA  string = "reading schema %s"
+	ERROR_PARSING_SCHEMA  string = "parsing schema"
+	ERROR_INVALID_PATTERN string = "invalid pattern %s at %s"
+	ERROR_INVALID_TYPE    string = "invalid type %s at %s"
+)
+
+const (
+	TYPE_NULL    string = "null"
+	TYPE_BOOLEAN string = "boolean"
+	TYPE_OBJECT  string = "object"
+	TYPE_ARRAY   string = "array"
+	TYPE_NUMBER  string = "number"
+	TYPE_INTEGER string = "integer"
+	TYPE_STRING  string = "string"
+)
+
+// Types holds the allowed json types of a schema,
+// unmarshals from a single type name or a list of names
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = Types{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = Types(list)
+	return nil
+}
+
+// Schema is the supported subset of JSON Schema draft 2020-12:
+// type, required, enum, const, pattern, numeric & length bounds,
+// nested objects & arrays
+type Schema struct {
+	Type                 Types              `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Const                interface{}        `json:"const,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Violation is a single schema violation,
+// Index is the stream element index, Pointer the JSON pointer within the element
+type Violation struct {
+	Index   int
+	Pointer string
+	Message string
+}
+
+func (v Violation) Error() string {
+	pointer := v.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+	return fmt.Sprintf("element %d: %s: %s", v.Index, pointer, v.Message)
+}
+
+// Parse parses & compiles json schema data
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, errors.WrapError(err, ERROR_PARSING_SCHEMA)
+	}
+	if err := s.compile(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Load reads, parses & compiles json schema file
+func Load(filePath string) (*Schema, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_READING_SCHEMA, filePath)
+	}
+	return Parse(data)
+}
+
+func (s *Schema) compile(pointer string) error {
+	for _, t := range s.Type {
+		switch t {
+		case TYPE_NULL, TYPE_BOOLEAN, TYPE_OBJECT, TYPE_ARRAY, TYPE_NUMBER, TYPE_INTEGER, TYPE_STRING:
+		default:
+			return errors.NewAppError(ERROR_INVALID_TYPE, t, pointer)
+		}
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return errors.WrapError(err, ERROR_INVALID_PATTERN, s.Pattern, pointer)
+		}
+		s.pattern = re
+	}
+	for name, ps := range s.Properties {
+		if err := ps.compile(pointer + "/properties/" + escapePointer(name)); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		if err := s.Items.compile(pointer + "/items"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate validates stream element at index against schema,
+// returns all violations found
+func (s *Schema) Validate(index int, v interface{}) []Violation {
+	vs := []Violation{}
+	s.validate(index, "", normalize(v), &vs)
+	return vs
+}
+
+// ValidateStream takes context, schema, JSONMapper in chan, out chan & err chan
+// passes valid elements to out chan & sends violations of invalid elements to err chan
+// closes out and err channels when in chan is closed or context is done
+func ValidateStream(ctx context.Context, s *Schema, inCh <-chan models.JSONMapper, outCh chan models.JSONMapper, errCh chan error) {
+	defer func() {
+		close(outCh)
+		close(errCh)
+	}()
+
+	for i := 0; ; i++ {
+		var item models.JSONMapper
+		select {
+		case <-ctx.Done():
+			return
+		case r, ok := <-inCh:
+			if !ok {
+				return
+			}
+			item = r
+		}
+
+		if vs := s.Validate(i, item); len(vs) > 0 {
+			for _, v := range vs {
+				select {
+				case <-ctx.Done():
+					return
+				case errCh <- v:
+				}
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case outCh <- item:
+		}
+	}
+}
+
+func (s *Schema) validate(index int, pointer string, v interface{}, vs *[]Violation) {
+	add := func(msgf string, args ...interface{}) {
+		*vs = append(*vs, Violation{
+			Index:   index,
+			Pointer: pointer,
+			Message: fmt.Sprintf(msgf, args...),
+		})
+	}
+
+	if len(s.Type) > 0 && !matchesType(s.Type, v) {
+		add("expected %s, got %s", strings.Join(s.Type, " or "), typeOf(v))
+		return
+	}
+
+	if s.Const != nil && !reflect.DeepEqual(normalize(s.Const), v) {
+		add("value %v doesn't match const %v", v, s.Const)
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(normalize(e), v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("value %v not in enum %v", v, s.Enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.MinLength != nil && n < *s.MinLength {
+			add("length %d less than minLength %d", n, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			add("length %d greater than maxLength %d", n, *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			add("value %q doesn't match pattern %s", val, s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			add("value %v less than minimum %v", val, *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			add("value %v greater than maximum %v", val, *s.Maximum)
+		}
+		if s.ExclusiveMinimum != nil && val <= *s.ExclusiveMinimum {
+			add("value %v not greater than exclusiveMinimum %v", val, *s.ExclusiveMinimum)
+		}
+		if s.ExclusiveMaximum != nil && val >= *s.ExclusiveMaximum {
+			add("value %v not less than exclusiveMaximum %v", val, *s.ExclusiveMaximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(val) < *s.MinItems {
+			add("%d items less than minItems %d", len(val), *s.MinItems)
+		}
+		if s.MaxItems != nil && len(val) > *s.MaxItems {
+			add("%d items greater than maxItems %d", len(val), *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range val {
+				s.Items.validate(index, pointer+"/"+strconv.Itoa(i), item, vs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := val[name]; !ok {
+				add("missing required property %s", name)
+			}
+		}
+
+		// sorted keys keep violation order stable
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ps, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*vs = append(*vs, Violation{
+						Index:   index,
+						Pointer: pointer + "/" + escapePointer(k),
+						Message: "additional property not allowed",
+					})
+				}
+				continue
+			}
+			ps.validate(index, pointer+"/"+escapePointer(k), val[k], vs)
+		}
+	}
+}
+
+func matchesType(types Types, v interface{}) bool {
+	actual := typeOf(v)
+	for _, t := range types {
+		if t == actual {
+			return true
+		}
+		if t == TYPE_NUMBER && actual == TYPE_INTEGER {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return TYPE_NULL
+	case bool:
+		return TYPE_BOOLEAN
+	case string:
+		return TYPE_STRING
+	case float64:
+		if val == math.Trunc(val) && !math.IsInf(val, 0) {
+			return TYPE_INTEGER
+		}
+		return TYPE_NUMBER
+	case []interface{}:
+		return TYPE_ARRAY
+	case map[string]interface{}:
+		return TYPE_OBJECT
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// normalize converts go values into their decoded json form,
+// so elements built in code validate the same as elements read from file
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil, bool, string, float64:
+		return val
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalize(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = normalize(item)
+		}
+		return out
+	case json.Number:
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val.String()
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+func escapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
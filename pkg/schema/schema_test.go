@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/comfforts/localstorage/pkg/models"
+)
+
+const TEST_SCHEMA = `{
+	"type": "object",
+	"required": ["name", "store_id", "country"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "maxLength": 40},
+		"store_id": {"type": "integer", "minimum": 1},
+		"country": {"enum": ["CN", "US"]},
+		"city": {"type": ["string", "null"], "pattern": "^[A-Z]"},
+		"latitude": {"type": "number", "minimum": -90, "maximum": 90},
+		"tags": {
+			"type": "array",
+			"maxItems": 2,
+			"items": {"type": "object", "required": ["k"], "properties": {"k": {"type": "string"}}}
+		}
+	}
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(TEST_SCHEMA))
+	require.NoError(t, err)
+
+	vs := s.Validate(0, models.JSONMapper{
+		"name":     "Plaza Hollywood",
+		"store_id": 1,
+		"country":  "CN",
+		"city":     "Hong Kong",
+		"latitude": 22.340700149536133,
+		"tags":     []interface{}{map[string]interface{}{"k": "v"}},
+	})
+	require.Equal(t, 0, len(vs))
+
+	vs = s.Validate(3, models.JSONMapper{
+		"name":     "",
+		"store_id": 1.5,
+		"country":  "HK",
+		"city":     "kowloon",
+		"latitude": 122.3,
+		"tags":     []interface{}{map[string]interface{}{"x": 1}, 2, 3},
+	})
+	pointers := map[string]int{}
+	for _, v := range vs {
+		t.Logf("TestValidate: %v", v)
+		require.Equal(t, 3, v.Index)
+		pointers[v.Pointer]++
+	}
+	require.Equal(t, map[string]int{
+		"/name":     1,
+		"/store_id": 1,
+		"/country":  1,
+		"/city":     1,
+		"/latitude": 1,
+		"/tags":     1,
+		"/tags/0":   1,
+		"/tags/1":   1,
+		"/tags/2":   1,
+	}, pointers)
+
+	vs = s.Validate(4, models.JSONMapper{"name": "Telford Plaza"})
+	require.Equal(t, 2, len(vs))
+	require.Equal(t, "", vs[0].Pointer)
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse([]byte(`{"type": "strings"}`))
+	require.Error(t, err)
+
+	_, err = Parse([]byte(`{"properties": {"name": {"pattern": "("}}}`))
+	require.Error(t, err)
+}
+
+func TestValidateStream(t *testing.T) {
+	s, err := Parse([]byte(TEST_SCHEMA))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inCh := make(chan models.JSONMapper)
+	outCh := make(chan models.JSONMapper)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(inCh)
+		inCh <- models.JSONMapper{"name": "Plaza Hollywood", "store_id": 1, "country": "CN"}
+		inCh <- models.JSONMapper{"name": "Exchange Square", "store_id": 0, "country": "CN"}
+		inCh <- models.JSONMapper{"name": "Telford Plaza", "store_id": 8, "country": "US"}
+	}()
+	go ValidateStream(ctx, s, inCh, outCh, errCh)
+
+	count := 0
+	violations := []Violation{}
+	for outCh != nil || errCh != nil {
+		select {
+		case _, ok := <-outCh:
+			if !ok {
+				outCh = nil
+				continue
+			}
+			count++
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			violations = append(violations, err.(Violation))
+		}
+	}
+	require.Equal(t, 2, count)
+	require.Equal(t, 1, len(violations))
+	require.Equal(t, 1, violations[0].Index)
+	require.Equal(t, "/store_id", violations[0].Pointer)
+}
@@ -0,0 +1,46 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+
+	ctx, parent := r.Start(context.Background(), "parent", String(FILE_PATH, "data/in.json"))
+	_, child := r.Start(ctx, "child")
+	child.SetAttributes(Int64(RECORD_COUNT, 3))
+	child.RecordError(nil)
+	child.RecordError(errors.New("failed"))
+	child.End()
+	child.End()
+	parent.End()
+
+	spans := r.Spans()
+	require.Equal(t, 2, len(spans))
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, spans[1].ID, spans[0].ParentID)
+	require.Equal(t, int64(3), spans[0].Attributes[RECORD_COUNT])
+	require.Equal(t, 1, len(spans[0].Errors))
+	require.False(t, spans[0].End.Before(spans[0].Start))
+
+	require.Equal(t, "parent", spans[1].Name)
+	require.Equal(t, 0, spans[1].ParentID)
+	require.Equal(t, "data/in.json", spans[1].Attributes[FILE_PATH])
+	require.Empty(t, spans[1].Errors)
+
+	r.Reset()
+	require.Empty(t, r.Spans())
+}
+
+func TestNoop(t *testing.T) {
+	ctx := context.Background()
+	sctx, span := Noop{}.Start(ctx, "noop", String(FILE_PATH, "data/in.json"))
+	require.Equal(t, ctx, sctx)
+	span.RecordError(errors.New("failed"))
+	span.End()
+}
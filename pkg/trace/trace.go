@@ -0,0 +1,58 @@
+package trace
+
+import (
+	"context"
+)
+
+// span attribute keys
+const (
+	FILE_PATH    = "file.path"
+	DEST_PATH    = "file.dest_path"
+	FILE_SIZE    = "file.size"
+	RECORD_COUNT = "record.count"
+	IO_BYTES     = "io.bytes"
+)
+
+// Attribute is a span's key/value attribute
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer opens spans around storage operations,
+// an OpenTelemetry adapter can wrap its tracer & spans
+type Tracer interface {
+	// Start opens named span as child of context's span,
+	// returns context carrying opened span
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is an open operation span, ended once operation is done
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// Noop opens spans that record nothing
+type Noop struct{}
+
+var _ Tracer = Noop{}
+
+func (Noop) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(attrs ...Attribute) {}
+func (noopSpan) RecordError(err error)            {}
+func (noopSpan) End()                             {}
@@ -0,0 +1,216 @@
+//go:build linux
+
+package watch
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+
+	"github.com/comfforts/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	inotifyMask uint32 = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+		syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+	// inotifyWait is epoll wait timeout in milliseconds, between context checks
+	inotifyWait int = 100
+)
+
+// inotify watches a directory tree with linux inotify
+type inotify struct {
+	w       *Watcher
+	fd      int
+	epfd    int
+	watches map[int32]string
+}
+
+// notify sets up inotify watches under dir & starts sending events,
+// returns error if inotify can't be set up
+func (w *Watcher) notify(ctx context.Context, dir string, rawCh chan<- Event, errCh chan<- error) error {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(fd)
+		return os.NewSyscallError("epoll_create1", err)
+	}
+	n := &inotify{
+		w:       w,
+		fd:      fd,
+		epfd:    epfd,
+		watches: map[int32]string{},
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		n.close()
+		return os.NewSyscallError("epoll_ctl", err)
+	}
+
+	existing, err := n.addTree(dir)
+	if err != nil {
+		n.close()
+		return err
+	}
+	if !w.config.Existing {
+		existing = nil
+	}
+	go n.run(ctx, existing, rawCh, errCh)
+	return nil
+}
+
+func (n *inotify) close() {
+	syscall.Close(n.epfd)
+	syscall.Close(n.fd)
+}
+
+// addTree adds watch for dir, & its subdirectories if recursive,
+// returns create events for paths found under dir
+func (n *inotify) addTree(dir string) ([]Event, error) {
+	events := []Event{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if path != dir {
+			events = append(events, Event{Path: path, Op: CREATE, IsDir: d.IsDir()})
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && !n.w.config.Recursive {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(n.fd, path, inotifyMask)
+		if err != nil {
+			if err == syscall.ENOENT {
+				return filepath.SkipDir
+			}
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		n.watches[int32(wd)] = path
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_WATCHING_DIR, dir)
+	}
+	return events, nil
+}
+
+// run reads inotify events & sends them on raw channel till context done
+func (n *inotify) run(ctx context.Context, existing []Event, rawCh chan<- Event, errCh chan<- error) {
+	defer n.close()
+
+	send := func(ev Event) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case rawCh <- ev:
+			return true
+		}
+	}
+	sendErr := func(err error) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case errCh <- err:
+			return true
+		}
+	}
+
+	for _, ev := range existing {
+		if !send(ev) {
+			return
+		}
+	}
+
+	epEvents := make([]syscall.EpollEvent, 1)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		ready, err := syscall.EpollWait(n.epfd, epEvents, inotifyWait)
+		if err == syscall.EINTR || ready == 0 {
+			continue
+		}
+		if err != nil {
+			sendErr(os.NewSyscallError("epoll_wait", err))
+			return
+		}
+
+		for {
+			size, err := syscall.Read(n.fd, buf)
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				break
+			}
+			if err != nil {
+				sendErr(os.NewSyscallError("read", err))
+				return
+			}
+			for _, ev := range n.parse(buf[:size], sendErr) {
+				if !send(ev) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// parse converts read inotify events, adding watches for new directories when recursive
+func (n *inotify) parse(buf []byte, sendErr func(error) bool) []Event {
+	events := []Event{}
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+		offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+		mask := raw.Mask
+		dir, ok := n.watches[raw.Wd]
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			sendErr(errors.NewAppError(ERROR_WATCH_OVERRUN, dir))
+			continue
+		}
+		if mask&syscall.IN_IGNORED != 0 {
+			delete(n.watches, raw.Wd)
+			continue
+		}
+		if !ok || len(nameBytes) == 0 {
+			continue
+		}
+		path := filepath.Join(dir, string(bytes.TrimRight(nameBytes, "\x00")))
+		isDir := mask&syscall.IN_ISDIR != 0
+
+		switch {
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			events = append(events, Event{Path: path, Op: CREATE, IsDir: isDir})
+			if isDir && n.w.config.Recursive {
+				// paths created before watch was added are sent as created
+				found, err := n.addTree(path)
+				if err != nil {
+					n.w.logger.Error("watch: error adding watch", zap.String("dir", path), zap.Error(err))
+					sendErr(err)
+					continue
+				}
+				events = append(events, found...)
+			}
+		case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+			events = append(events, Event{Path: path, Op: DELETE, IsDir: isDir})
+		case mask&(syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE|syscall.IN_ATTRIB) != 0:
+			if !isDir {
+				events = append(events, Event{Path: path, Op: MODIFY})
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package watch
+
+import (
+	"context"
+
+	"github.com/comfforts/errors"
+)
+
+// notify isn't supported, watch falls back to polling
+func (w *Watcher) notify(ctx context.Context, dir string, rawCh chan<- Event, errCh chan<- error) error {
+	return errors.NewAppError("inotify not supported")
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package watch
+
+// lockHeld can't check locks, only lock files are checked
+func lockHeld(path string) bool {
+	return false
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockHeld(t *testing.T) {
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	require.NoError(t, os.MkdirAll(TEST_DIR, os.ModePerm))
+	path := filepath.Join(TEST_DIR, "locked.csv")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	require.False(t, lockHeld(path))
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_EX))
+	require.True(t, lockHeld(path))
+	require.NoError(t, syscall.Flock(int(f.Fd()), syscall.LOCK_UN))
+	require.False(t, lockHeld(path))
+}
@@ -0,0 +1,415 @@
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/comfforts/errors"
+	"github.com/comfforts/logger"
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/lock"
+)
+
+const (
+	ERROR_NOT_A_DIR     string = "%s not a directory"
+	ERROR_WATCHING_DIR  string = "error watching %s"
+	ERROR_SCANNING_DIR  string = "error scanning %s"
+	ERROR_WATCH_OVERRUN string = "watch event queue overflowed, events for %s may be lost"
+)
+
+const (
+	DEFAULT_POLL_INTERVAL time.Duration = time.Second
+	DEFAULT_LOCK_SUFFIX   string        = lock.DEFAULT_LOCK_SUFFIX
+	// checkInterval is how often debounced events & file completion are checked
+	checkInterval time.Duration = 50 * time.Millisecond
+)
+
+type Op uint8
+
+const (
+	CREATE Op = iota + 1
+	MODIFY
+	DELETE
+	// COMPLETE is sent once a created or modified file has been stable & unlocked
+	COMPLETE
+)
+
+func (op Op) String() string {
+	switch op {
+	case CREATE:
+		return "create"
+	case MODIFY:
+		return "modify"
+	case DELETE:
+		return "delete"
+	case COMPLETE:
+		return "complete"
+	}
+	return "unknown"
+}
+
+type Event struct {
+	Path  string
+	Op    Op
+	IsDir bool
+	// Size & ModTime are file stats when event is sent, empty for deletes
+	Size    int64
+	ModTime time.Time
+}
+
+type Config struct {
+	// Recursive watches subdirectories, including ones created later
+	Recursive bool
+	// Poll forces polling, otherwise inotify is used on linux,
+	// polling elsewhere & when inotify can't be set up
+	Poll bool
+	// PollInterval is time between directory scans, defaults to DEFAULT_POLL_INTERVAL
+	PollInterval time.Duration
+	// Debounce, if set, coalesces events for a path until it's quiet for the duration
+	Debounce time.Duration
+	// Stable, if set, sends COMPLETE for created or modified files once their size
+	// & mod time are unchanged for the duration, with no lock held on them
+	Stable time.Duration
+	// LockSuffix names lock files marking a file as being written, e.g. filings.csv.lock,
+	// defaults to DEFAULT_LOCK_SUFFIX
+	LockSuffix string
+	// Pattern, if set, filters events by file name, as per filepath.Match,
+	// directory events are only sent without pattern
+	Pattern string
+	// Existing sends CREATE for files already present when watch starts
+	Existing bool
+}
+
+type Watcher struct {
+	config Config
+	logger logger.AppLogger
+}
+
+func NewWatcher(cfg Config, logger logger.AppLogger) (*Watcher, error) {
+	if logger == nil {
+		return nil, errors.NewAppError(errors.ERROR_MISSING_REQUIRED)
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DEFAULT_POLL_INTERVAL
+	}
+	if cfg.LockSuffix == "" {
+		cfg.LockSuffix = DEFAULT_LOCK_SUFFIX
+	}
+	if cfg.Pattern != "" {
+		if _, err := filepath.Match(cfg.Pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+	return &Watcher{
+		config: cfg,
+		logger: logger,
+	}, nil
+}
+
+// pending is a debounced event
+type pending struct {
+	event Event
+	due   time.Time
+}
+
+// tracked is a file waiting to be complete
+type tracked struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// Watch takes context, directory, event chan & err chan
+// sets up watch on dir & returns error if it can't be set up
+// sends create, modify, delete & complete events for files under dir
+// sends errors on err channel
+// closes event and err channels on context done
+func (w *Watcher) Watch(ctx context.Context, dir string, evCh chan Event, errCh chan error) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return errors.WrapError(err, ERROR_WATCHING_DIR, dir)
+	}
+	if !info.IsDir() {
+		return errors.NewAppError(ERROR_NOT_A_DIR, dir)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	rawCh := make(chan Event)
+	srcErrCh := make(chan error)
+	started := false
+	if !w.config.Poll {
+		if err := w.notify(ctx, dir, rawCh, srcErrCh); err != nil {
+			w.logger.Info("watch: falling back to polling", zap.String("dir", dir), zap.Error(err))
+		} else {
+			started = true
+		}
+	}
+	if !started {
+		prev, err := w.scan(ctx, dir)
+		if err != nil {
+			cancel()
+			return err
+		}
+		go w.poll(ctx, prev, dir, rawCh, srcErrCh)
+	}
+
+	go func() {
+		defer cancel()
+		w.run(ctx, rawCh, srcErrCh, evCh, errCh)
+	}()
+	return nil
+}
+
+// run debounces raw events, checks file completion & sends events till context done,
+// closes event and err channels on return
+func (w *Watcher) run(ctx context.Context, rawCh <-chan Event, srcErrCh <-chan error, evCh chan Event, errCh chan error) {
+	defer func() {
+		close(evCh)
+		close(errCh)
+	}()
+
+	debounced := map[string]*pending{}
+	waiting := map[string]*tracked{}
+	var tick <-chan time.Time
+	if w.config.Debounce > 0 || w.config.Stable > 0 {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	send := func(ev Event) bool {
+		if ev.Op != DELETE && ev.Op != COMPLETE {
+			if info, err := os.Lstat(ev.Path); err == nil {
+				ev.Size, ev.ModTime = info.Size(), info.ModTime()
+			}
+		}
+		if w.config.Stable > 0 && !ev.IsDir {
+			switch ev.Op {
+			case CREATE, MODIFY:
+				waiting[ev.Path] = &tracked{size: ev.Size, modTime: ev.ModTime, since: time.Now()}
+			case DELETE:
+				delete(waiting, ev.Path)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case evCh <- ev:
+			return true
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-srcErrCh:
+			select {
+			case <-ctx.Done():
+				return
+			case errCh <- err:
+			}
+		case ev := <-rawCh:
+			if !w.match(ev) {
+				continue
+			}
+			if w.config.Debounce <= 0 {
+				if !send(ev) {
+					return
+				}
+				continue
+			}
+			if p, ok := debounced[ev.Path]; ok {
+				op, keep := merge(p.event.Op, ev.Op)
+				if !keep {
+					delete(debounced, ev.Path)
+					continue
+				}
+				ev.Op = op
+			}
+			debounced[ev.Path] = &pending{event: ev, due: time.Now().Add(w.config.Debounce)}
+		case now := <-tick:
+			for _, path := range sortedKeys(debounced) {
+				p := debounced[path]
+				if now.Before(p.due) {
+					continue
+				}
+				delete(debounced, path)
+				if !send(p.event) {
+					return
+				}
+			}
+			for _, path := range sortedKeys(waiting) {
+				if _, ok := debounced[path]; ok {
+					continue
+				}
+				t := waiting[path]
+				info, err := os.Stat(path)
+				if err != nil {
+					delete(waiting, path)
+					continue
+				}
+				if info.Size() != t.size || !info.ModTime().Equal(t.modTime) {
+					t.size, t.modTime, t.since = info.Size(), info.ModTime(), now
+					continue
+				}
+				if now.Sub(t.since) < w.config.Stable || w.locked(path) {
+					continue
+				}
+				delete(waiting, path)
+				if !send(Event{Path: path, Op: COMPLETE, Size: info.Size(), ModTime: info.ModTime()}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// match filters events by file name pattern
+func (w *Watcher) match(ev Event) bool {
+	if w.config.Pattern == "" {
+		return true
+	}
+	if ev.IsDir {
+		return false
+	}
+	ok, _ := filepath.Match(w.config.Pattern, filepath.Base(ev.Path))
+	return ok
+}
+
+// locked checks for a lock file next to path, or a lock held on it
+func (w *Watcher) locked(path string) bool {
+	if _, err := os.Lstat(path + w.config.LockSuffix); err == nil {
+		return true
+	}
+	return lockHeld(path)
+}
+
+// merge coalesces a path's pending op with next op, keep is false when events cancel out
+func merge(prev, next Op) (op Op, keep bool) {
+	switch {
+	case prev == CREATE && next == MODIFY:
+		return CREATE, true
+	case prev == CREATE && next == DELETE:
+		return 0, false
+	case prev == DELETE && next == CREATE:
+		return MODIFY, true
+	}
+	return next, true
+}
+
+// fileState is a scanned path's state
+type fileState struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// poll scans dir at poll interval & sends differences from previous scan as events
+func (w *Watcher) poll(ctx context.Context, prev map[string]fileState, dir string, rawCh chan<- Event, errCh chan<- error) {
+	send := func(ev Event) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case rawCh <- ev:
+			return true
+		}
+	}
+
+	if w.config.Existing {
+		for _, path := range sortedKeys(prev) {
+			if !send(Event{Path: path, Op: CREATE, IsDir: prev[path].isDir}) {
+				return
+			}
+		}
+	}
+
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur, err := w.scan(ctx, dir)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case errCh <- err:
+			}
+			continue
+		}
+		for _, path := range sortedKeys(cur) {
+			c := cur[path]
+			p, ok := prev[path]
+			switch {
+			case !ok:
+				if !send(Event{Path: path, Op: CREATE, IsDir: c.isDir}) {
+					return
+				}
+			case !c.isDir && (c.size != p.size || !c.modTime.Equal(p.modTime)):
+				if !send(Event{Path: path, Op: MODIFY}) {
+					return
+				}
+			}
+		}
+		for _, path := range sortedKeys(prev) {
+			if _, ok := cur[path]; !ok {
+				if !send(Event{Path: path, Op: DELETE, IsDir: prev[path].isDir}) {
+					return
+				}
+			}
+		}
+		prev = cur
+	}
+}
+
+// scan returns state of paths under dir, recursively if configured
+func (w *Watcher) scan(ctx context.Context, dir string) (map[string]fileState, error) {
+	states := map[string]fileState{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				// removed during scan
+				return nil
+			}
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		states[path] = fileState{size: info.Size(), modTime: info.ModTime(), isDir: d.IsDir()}
+		if d.IsDir() && !w.config.Recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_SCANNING_DIR, dir)
+	}
+	return states, nil
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/comfforts/logger"
+	"github.com/stretchr/testify/require"
+)
+
+const TEST_DIR = "data"
+
+func TestWatch(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	for name, poll := range map[string]bool{"notify": false, "poll": true} {
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join(TEST_DIR, name)
+			require.NoError(t, os.MkdirAll(dir, os.ModePerm))
+			existing := filepath.Join(dir, "existing.csv")
+			require.NoError(t, os.WriteFile(existing, []byte("a|b\n"), 0644))
+
+			w, err := NewWatcher(Config{
+				Recursive:    true,
+				Poll:         poll,
+				PollInterval: 20 * time.Millisecond,
+				Debounce:     50 * time.Millisecond,
+				Stable:       100 * time.Millisecond,
+				Pattern:      "*.csv",
+				Existing:     true,
+			}, logger)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			evCh, errCh := make(chan Event), make(chan error)
+			err = w.Watch(ctx, dir, evCh, errCh)
+			require.NoError(t, err)
+
+			next := func() Event {
+				select {
+				case ev := <-evCh:
+					return ev
+				case err := <-errCh:
+					require.NoError(t, err)
+				case <-ctx.Done():
+					require.FailNow(t, "timed out waiting for event")
+				}
+				return Event{}
+			}
+
+			ev := next()
+			require.Equal(t, Event{Path: existing, Op: CREATE, Size: 4, ModTime: ev.ModTime}, ev)
+			require.Equal(t, COMPLETE, next().Op)
+
+			// file in new sub directory, written in parts under lock file
+			sub := filepath.Join(dir, "sub")
+			require.NoError(t, os.Mkdir(sub, os.ModePerm))
+			drop := filepath.Join(sub, "drop.csv")
+			require.NoError(t, os.WriteFile(drop+DEFAULT_LOCK_SUFFIX, nil, 0644))
+			f, err := os.Create(drop)
+			require.NoError(t, err)
+			_, err = f.WriteString("a|b\n")
+			require.NoError(t, err)
+
+			ev = next()
+			require.Equal(t, drop, ev.Path)
+			require.Equal(t, CREATE, ev.Op)
+
+			_, err = f.WriteString("1|2\n")
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			time.Sleep(300 * time.Millisecond)
+			require.NoError(t, os.Remove(drop+DEFAULT_LOCK_SUFFIX))
+
+			ev = next()
+			for ev.Op == MODIFY {
+				ev = next()
+			}
+			require.Equal(t, Event{Path: drop, Op: COMPLETE, Size: 8, ModTime: ev.ModTime}, ev)
+
+			require.NoError(t, os.Remove(existing))
+			require.Equal(t, Event{Path: existing, Op: DELETE}, next())
+
+			// created & deleted within debounce window isn't sent
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "temp.csv"), nil, 0644))
+			require.NoError(t, os.Remove(filepath.Join(dir, "temp.csv")))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "skipped.json"), nil, 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "last.csv"), nil, 0644))
+			ev = next()
+			require.Equal(t, filepath.Join(dir, "last.csv"), ev.Path)
+			require.Equal(t, CREATE, ev.Op)
+
+			cancel()
+			for range evCh {
+			}
+		})
+	}
+}
+
+func TestWatchErrors(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	defer func() {
+		err := os.RemoveAll(TEST_DIR)
+		require.NoError(t, err)
+	}()
+
+	_, err := NewWatcher(Config{Pattern: "["}, logger)
+	require.Error(t, err)
+
+	w, err := NewWatcher(Config{}, logger)
+	require.NoError(t, err)
+	evCh, errCh := make(chan Event), make(chan error)
+	err = w.Watch(context.Background(), filepath.Join(TEST_DIR, "missing"), evCh, errCh)
+	require.Error(t, err)
+}
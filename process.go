@@ -0,0 +1,81 @@
+package localstorage
+
+import (
+	"context"
+
+	"github.com/comfforts/localstorage/pkg/process"
+)
+
+type ProcessConfig = process.Config
+
+type ProcessFunc = process.Func
+
+// Process processes read results with a worker pool as per config,
+// for composing between readers & writers, e.g. ReadFileArray & WriteFile,
+// passes read errors through as received & sends processing errors, as process.RecordError,
+// as read response errors, closes returned stream on done
+func (lc *localStorageClient) Process(ctx context.Context, rs <-chan ReadResponse, cfg ProcessConfig, fn ProcessFunc) (<-chan ReadResponse, error) {
+	p, err := process.NewProcessor(cfg, fn, lc.logger)
+	if err != nil {
+		return nil, err
+	}
+
+	inCh := make(chan JSONMapper)
+	readErrCh := make(chan error)
+	go func() {
+		defer close(readErrCh)
+		splitReadStream(ctx, rs, inCh, readErrCh)
+	}()
+	outCh := make(chan JSONMapper)
+	errCh := make(chan error)
+	go p.ProcessStream(ctx, inCh, outCh, errCh)
+
+	prs := make(chan ReadResponse)
+	go func() {
+		defer close(prs)
+		for outCh != nil || errCh != nil || readErrCh != nil {
+			var resp ReadResponse
+			select {
+			case <-ctx.Done():
+				return
+			case r, ok := <-outCh:
+				if !ok {
+					outCh = nil
+					continue
+				}
+				resp.Result = r
+			case err, ok := <-errCh:
+				if !ok {
+					errCh = nil
+					continue
+				}
+				resp.Error = err
+			case err, ok := <-readErrCh:
+				if !ok {
+					readErrCh = nil
+					continue
+				}
+				resp.Error = err
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case prs <- resp:
+			}
+		}
+	}()
+	return prs, nil
+}
+
+// ProcessWriteStream processes write requests with a worker pool as per config,
+// returns request stream of results to pass on to WriteFile
+// & sends processing errors, as process.RecordError, on err chan, closes err chan on done
+func (lc *localStorageClient) ProcessWriteStream(ctx context.Context, reqStream chan JSONMapper, cfg ProcessConfig, fn ProcessFunc, errCh chan error) (chan JSONMapper, error) {
+	p, err := process.NewProcessor(cfg, fn, lc.logger)
+	if err != nil {
+		return nil, err
+	}
+	pReqStream := make(chan JSONMapper)
+	go p.ProcessStream(ctx, reqStream, pReqStream, errCh)
+	return pReqStream, nil
+}
@@ -0,0 +1,61 @@
+package localstorage
+
+import (
+	"context"
+	"time"
+
+	"github.com/comfforts/localstorage/pkg/profile"
+)
+
+type ProfileOptions struct {
+	// CSV configures reading csv files
+	CSV CSVConfig
+	// Columns, if set, limits profiled columns, in order
+	Columns []string
+	// InferTypes infers numbers, booleans & nulls of json string values,
+	// csv fields are always inferred
+	InferTypes bool
+	// Precision of distinct value estimates, defaults to profile.DEFAULT_PRECISION
+	Precision uint8
+}
+
+// ProfileFile streams records of json array, ndjson or csv file at filePath & returns
+// record count & per column null & empty counts, distinct value estimates,
+// min, max & length distributions & inferred types,
+// read errors abort profiling
+func (lc *localStorageClient) ProfileFile(ctx context.Context, filePath string, opts ProfileOptions) (p *profile.Profile, err error) {
+	defer lc.observe("ProfileFile", time.Now(), &err)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rs, headers, err := lc.readRecordsWithConfig(ctx, "ProfileFile", filePath, opts.CSV)
+	if err != nil {
+		return nil, err
+	}
+
+	profiler, err := profile.NewProfiler(profile.Config{
+		Columns:    opts.Columns,
+		InferTypes: opts.InferTypes || fileFormat(filePath) == FORMAT_CSV,
+		Precision:  opts.Precision,
+	}, lc.logger)
+	if err != nil {
+		return nil, err
+	}
+	profiler.AddColumns(headers...)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case r, ok := <-rs:
+			if !ok {
+				return profiler.Profile(), nil
+			}
+			if r.Error != nil {
+				return nil, r.Error
+			}
+			profiler.Add(r.Result)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package localstorage
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+type ReadFilesOptions struct {
+	// Concurrency, if more than 1, reads that many files at once, interleaving their records,
+	// otherwise files are read one after another in path order
+	Concurrency int
+	// Recursive reads files in subdirectories of a source directory
+	Recursive bool
+	// List filters source files, unsupported formats are always skipped, sort & paging are ignored
+	List ListOptions
+	// CSV configures reading of csv files
+	CSV CSVConfig
+}
+
+// FileResponse is a read response tagged with its source file,
+// Position is record's 1 based position in file, errors have position of last record read
+type FileResponse struct {
+	Source   string
+	Position int
+	Result   JSONMapper
+	Error    error
+}
+
+// ReadFiles reads json array, ndjson & csv files of a directory or glob pattern as one record stream,
+// returns read files & stream, closed once all files are read,
+// files that can't be opened are reported on stream & skipped
+func (lc *localStorageClient) ReadFiles(ctx context.Context, source string, opts ReadFilesOptions) (fs <-chan FileResponse, files []FileInfo, err error) {
+	defer lc.observeSetup("ReadFiles", time.Now(), &err)
+
+	files, err = lc.sourceFiles(ctx, source, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	pathCh := make(chan string)
+	frs := make(chan FileResponse)
+	go func() {
+		defer close(pathCh)
+		for _, f := range files {
+			select {
+			case <-ctx.Done():
+				return
+			case pathCh <- f.Path:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range pathCh {
+				if !lc.readSource(ctx, path, opts.CSV, frs) {
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(frs)
+	}()
+	return trackStream(ctx, lc.metrics, "ReadFiles", frs, func(fr FileResponse) error { return fr.Error }), files, nil
+}
+
+// readSource sends records of file at path as file responses, returns false once context is done
+func (lc *localStorageClient) readSource(ctx context.Context, path string, cfg CSVConfig, frs chan FileResponse) bool {
+	send := func(fr FileResponse) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case frs <- fr:
+			return true
+		}
+	}
+
+	rrs, _, err := lc.readRecordsWithConfig(ctx, "ReadFiles", path, cfg)
+	if err != nil {
+		return send(FileResponse{Source: path, Error: err})
+	}
+	position := 0
+	for rr := range rrs {
+		fr := FileResponse{Source: path, Result: rr.Result, Error: rr.Error}
+		if rr.Error == nil {
+			position++
+		}
+		fr.Position = position
+		if !send(fr) {
+			return false
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// sourceFiles lists record files of a directory, recursively if configured, or matching a glob pattern
+func (lc *localStorageClient) sourceFiles(ctx context.Context, source string, opts ReadFilesOptions) ([]FileInfo, error) {
+	listOpts := opts.List
+	listOpts.IncludeDirs = false
+	listOpts.SortBy, listOpts.Descending, listOpts.Offset, listOpts.Limit = SORT_NAME, false, 0, 0
+	filter := listOpts.Filter
+	listOpts.Filter = func(fi FileInfo) bool {
+		if fi.Format == FORMAT_UNKNOWN {
+			return false
+		}
+		return filter == nil || filter(fi)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil || !info.IsDir() {
+		return lc.Glob(ctx, source, listOpts)
+	}
+	if !opts.Recursive {
+		return lc.List(ctx, source, listOpts)
+	}
+	files := []FileInfo{}
+	err = lc.Walk(ctx, source, listOpts, func(fi FileInfo) error {
+		files = append(files, fi)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,379 @@
+package localstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/comfforts/errors"
+
+	csvFiler "github.com/comfforts/localstorage/pkg/csv"
+	jsonFiler "github.com/comfforts/localstorage/pkg/json"
+)
+
+const (
+	FORMAT_JSON    string = "json"
+	FORMAT_NDJSON  string = "ndjson"
+	FORMAT_CSV     string = "csv"
+	FORMAT_UNKNOWN string = "unknown"
+)
+
+// fileFormat detects record file format from file extension
+func fileFormat(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		return FORMAT_JSON
+	case ".ndjson", ".jsonl":
+		return FORMAT_NDJSON
+	case ".csv", ".psv", ".txt":
+		return FORMAT_CSV
+	default:
+		return FORMAT_UNKNOWN
+	}
+}
+
+// readRecords reads json array, ndjson or csv file as a record stream,
+// csv rows are keyed by header names & headers are returned for csv files,
+// closes returned stream on done
+func (lc *localStorageClient) readRecords(ctx context.Context, method, filePath string) (<-chan ReadResponse, []string, error) {
+	return lc.readRecordsWithConfig(ctx, method, filePath, CSVConfig{})
+}
+
+// ReadRecords reads json array, ndjson or csv file, based on file extension, as a record stream,
+// csv files are read as per given config & their headers are returned
+func (lc *localStorageClient) ReadRecords(ctx context.Context, filePath string, cfg CSVConfig) (<-chan ReadResponse, []string, error) {
+	start := time.Now()
+	rs, headers, err := lc.readRecordsWithConfig(ctx, "ReadRecords", filePath, cfg)
+	if err != nil {
+		lc.metrics.ObserveLatency("ReadRecords", time.Since(start), err)
+		return nil, nil, err
+	}
+	return trackStream(ctx, lc.metrics, "ReadRecords", rs, responseError), headers, nil
+}
+
+// readRecordsWithConfig reads records, csv files are read as per given config,
+// read metrics are recorded for given client method
+func (lc *localStorageClient) readRecordsWithConfig(ctx context.Context, method, filePath string, cfg CSVConfig) (<-chan ReadResponse, []string, error) {
+	if _, err := fileStats(filePath); err != nil {
+		return nil, nil, err
+	}
+
+	switch format := fileFormat(filePath); format {
+	case FORMAT_JSON, FORMAT_NDJSON:
+		rs, err := lc.readJSONRecords(ctx, method, filePath, format)
+		return rs, nil, err
+	case FORMAT_CSV:
+		return lc.readCSVRecords(ctx, method, filePath, cfg)
+	default:
+		return nil, nil, errors.NewAppError(ERROR_UNSUPPORTED_FORMAT, filePath)
+	}
+}
+
+func (lc *localStorageClient) readJSONRecords(ctx context.Context, method, filePath, format string) (<-chan ReadResponse, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.WrapError(err, ERROR_OPENING_FILE, filePath)
+	}
+
+	src, err := lc.decryptReader(ctx, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	jsonFile, err := jsonFiler.NewJSONFilerWithReader(file, src, JSONConfig{}, lc.logger)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	resCh := make(chan JSONMapper)
+	errCh := make(chan error)
+	if format == FORMAT_NDJSON {
+		go jsonFile.ReadNDJSONFile(ctx, resCh, errCh)
+	} else {
+		go jsonFile.ReadJSONFile(ctx, resCh, errCh)
+	}
+	return lc.readResponses(ctx, method, jsonFile, resCh, errCh), nil
+}
+
+func (lc *localStorageClient) readCSVRecords(ctx context.Context, method, filePath string, cfg CSVConfig) (<-chan ReadResponse, []string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, errors.WrapError(err, ERROR_OPENING_FILE, filePath)
+	}
+
+	src, err := lc.decryptReader(ctx, file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	csvFile, err := csvFiler.NewCSVFilerWithReader(file, src, cfg, lc.logger)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	headers, err := csvFile.ReadHeaders()
+	if err != nil {
+		csvFile.Close()
+		return nil, nil, err
+	}
+
+	resCh := make(chan JSONMapper)
+	errCh := make(chan error)
+	go csvFile.ReadCSVRecords(ctx, resCh, errCh)
+	return lc.readResponses(ctx, method, csvFile, resCh, errCh), headers, nil
+}
+
+// readResponses merges filer results & errors into a read response stream,
+// records method's read metrics, closes file & response stream on done
+func (lc *localStorageClient) readResponses(ctx context.Context, method string, file io.ReadSeekCloser, resCh chan JSONMapper, errCh chan error) <-chan ReadResponse {
+	rrs := make(chan ReadResponse)
+	go func() {
+		records, errs := 0, 0
+		defer close(rrs)
+		defer func() {
+			// filer stops once context is done, waits for it before closing file
+			drain(resCh, errCh)
+			lc.metrics.AddRecordsRead(method, records)
+			lc.metrics.AddDecodeErrors(method, errs)
+			lc.metrics.AddBytesRead(method, offset(file))
+			file.Close()
+		}()
+
+		for resCh != nil || errCh != nil {
+			var resp ReadResponse
+			select {
+			case <-ctx.Done():
+				return
+			case r, ok := <-resCh:
+				if !ok {
+					resCh = nil
+					continue
+				}
+				if r == nil {
+					continue
+				}
+				resp.Result = r
+				records++
+			case err, ok := <-errCh:
+				if !ok {
+					errCh = nil
+					continue
+				}
+				resp.Error = err
+				errs++
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case rrs <- resp:
+			}
+		}
+	}()
+	return rrs
+}
+
+// writeRecords writes record stream to json array, ndjson or csv file, based on file extension,
+// csv columns are written in headers order, or sorted keys of first record if headers are empty,
+// later records with keys missing from first record's then fail the write,
+// output is encrypted if encryption is configured & written under exclusive lock,
+// into a temp file renamed over filePath once written & check passes, so failed writes leave it as is,
+// write metrics are recorded for given client method
+func (lc *localStorageClient) writeRecords(ctx context.Context, method, filePath string, headers []string, recCh chan JSONMapper, check func() error) error {
+	format := fileFormat(filePath)
+	if format == FORMAT_UNKNOWN {
+		return errors.NewAppError(ERROR_UNSUPPORTED_FORMAT, filePath)
+	}
+
+	if err := createDirectory(filePath); err != nil {
+		return errors.WrapError(err, ERROR_CREATING_FILE, filePath)
+	}
+	lk, err := lc.locker.Lock(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	defer lc.unlock(lk)
+
+	file, err := createTemp(filePath)
+	if err != nil {
+		return errors.WrapError(err, ERROR_CREATING_FILE, filePath)
+	}
+	w, closeEnc, err := lc.encryptWriter(ctx, file)
+	if err != nil {
+		discardTemp(file)
+		return err
+	}
+	var records int64
+	recCh = countRecords(ctx, recCh, &records)
+	defer func() {
+		lc.metrics.AddRecordsWritten(method, int(atomic.LoadInt64(&records)))
+	}()
+
+	var colErr error
+	switch format {
+	case FORMAT_JSON:
+		err = jsonFiler.WriteJSONArray(ctx, w, recCh)
+	case FORMAT_NDJSON:
+		err = jsonFiler.WriteNDJSONFile(ctx, w, recCh)
+	default:
+		// columns inferred from first record are checked against later records
+		var columns map[string]bool
+		rowCh := make(chan []string)
+		go func() {
+			defer close(rowCh)
+			headersSent := false
+			sendHeaders := func() bool {
+				headersSent = true
+				select {
+				case <-ctx.Done():
+					return false
+				case rowCh <- headers:
+					return true
+				}
+			}
+			if len(headers) > 0 && !sendHeaders() {
+				return
+			}
+			for r := range recCh {
+				if !headersSent {
+					headers = recordKeys(r)
+					columns = map[string]bool{}
+					for _, h := range headers {
+						columns[h] = true
+					}
+					if !sendHeaders() {
+						return
+					}
+				}
+				for k := range r {
+					if columns != nil && !columns[k] {
+						colErr = errors.NewAppError(ERROR_UNKNOWN_COLUMN, k, filePath)
+						return
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case rowCh <- rowFromRecord(headers, r):
+				}
+			}
+		}()
+		err = csvFiler.WriteCSVFile(ctx, w, rowCh)
+		drain(rowCh, nil)
+	}
+	if err == nil && colErr == nil {
+		err = closeEnc()
+	}
+	if err == nil {
+		// writers stop once context is done, written output is then partial
+		err = ctx.Err()
+	}
+	lc.metrics.AddBytesWritten(method, offset(file))
+	if err != nil || colErr != nil {
+		discardTemp(file)
+		if colErr != nil {
+			return colErr
+		}
+		return errors.WrapError(err, ERROR_WRITING_FILE, filePath)
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			discardTemp(file)
+			return err
+		}
+	}
+	if err := commitTemp(file, filePath); err != nil {
+		return errors.WrapError(err, ERROR_CLOSING_FILE, filePath)
+	}
+	return nil
+}
+
+func rowFromRecord(headers []string, r JSONMapper) []string {
+	row := make([]string, len(headers))
+	for i, h := range headers {
+		row[i] = toString(r[h])
+	}
+	return row
+}
+
+// toString formats a record value as a csv field
+func toString(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+		return string(data)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+func recordKeys(r JSONMapper) []string {
+	keys := make([]string, 0, len(r))
+	for k := range r {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// drain discards remaining values of given channels until they are closed
+func drain[T any](resCh chan T, errCh chan error) {
+	for resCh != nil || errCh != nil {
+		select {
+		case _, ok := <-resCh:
+			if !ok {
+				resCh = nil
+			}
+		case _, ok := <-errCh:
+			if !ok {
+				errCh = nil
+			}
+		}
+	}
+}
+
+// firstError keeps the first of errors reported by concurrent stages
+type firstError struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (fe *firstError) set(err error) {
+	if err == nil {
+		return
+	}
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if fe.err == nil {
+		fe.err = err
+	}
+}
+
+func (fe *firstError) get() error {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	return fe.err
+}
@@ -0,0 +1,282 @@
+package localstorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/comfforts/errors"
+
+	csvFiler "github.com/comfforts/localstorage/pkg/csv"
+	"github.com/comfforts/localstorage/pkg/lock"
+)
+
+// SHARD_NAME_FORMAT names shard files, e.g. filings-00001.json
+const SHARD_NAME_FORMAT = "%s-%05d%s"
+
+type ShardOptions struct {
+	// MaxBytes, MaxRecords & MaxAge, if set, roll over to a new shard once a shard's
+	// written bytes or records reach them, or it's been open for MaxAge
+	MaxBytes   int64
+	MaxRecords int
+	MaxAge     time.Duration
+	// Headers are csv shard columns, defaults to sorted keys of first record,
+	// every shard has the same headers
+	Headers []string
+}
+
+type Shard struct {
+	Path    string `json:"path"`
+	Records int    `json:"records"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// WriteShards writes record stream into json array, ndjson or csv shards of filePath,
+// based on its extension, named as per SHARD_NAME_FORMAT, e.g. out/filings-00001.json,
+// each shard is a complete file once rolled over,
+// returns written shards, none for an empty stream,
+// on error or context done the open shard is removed & completed shards returned
+func (lc *localStorageClient) WriteShards(ctx context.Context, filePath string, opts ShardOptions, recCh chan JSONMapper) (shards []Shard, err error) {
+	lc.metrics.StreamStarted("WriteShards")
+	defer lc.observeShards("WriteShards", time.Now(), &shards, &err)
+	defer lc.recordShards(ctx, "WriteShards", filePath, &shards, &err)
+
+	format := fileFormat(filePath)
+	if format == FORMAT_UNKNOWN {
+		return nil, errors.NewAppError(ERROR_UNSUPPORTED_FORMAT, filePath)
+	}
+	if err := createDirectory(filePath); err != nil {
+		return nil, errors.WrapError(err, ERROR_CREATING_FILE, filePath)
+	}
+
+	ext := filepath.Ext(filePath)
+	base := strings.TrimSuffix(filePath, ext)
+	headers := opts.Headers
+
+	shards = []Shard{}
+	var cur *shardFile
+	var timer *time.Timer
+	var expired <-chan time.Time
+	roll := func() error {
+		if cur == nil {
+			return nil
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		shard, err := cur.close()
+		cur, timer, expired = nil, nil, nil
+		if err != nil {
+			return err
+		}
+		shards = append(shards, shard)
+		return nil
+	}
+	fail := func(err error) ([]Shard, error) {
+		if timer != nil {
+			timer.Stop()
+		}
+		if cur != nil {
+			cur.abort()
+		}
+		return shards, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		case <-expired:
+			if err := roll(); err != nil {
+				return fail(err)
+			}
+		case r, ok := <-recCh:
+			if !ok {
+				if err := roll(); err != nil {
+					return fail(err)
+				}
+				return shards, nil
+			}
+			if cur == nil {
+				if format == FORMAT_CSV && len(headers) == 0 {
+					headers = recordKeys(r)
+				}
+				path := fmt.Sprintf(SHARD_NAME_FORMAT, base, len(shards)+1, ext)
+				f, err := lc.openShard(ctx, path, format, headers)
+				if err != nil {
+					return fail(err)
+				}
+				cur = f
+				if opts.MaxAge > 0 {
+					timer = time.NewTimer(opts.MaxAge)
+					expired = timer.C
+				}
+			}
+			if err := cur.write(r); err != nil {
+				return fail(err)
+			}
+			if (opts.MaxBytes > 0 && cur.bytes >= opts.MaxBytes) || (opts.MaxRecords > 0 && cur.records >= opts.MaxRecords) {
+				if err := roll(); err != nil {
+					return fail(err)
+				}
+			}
+		}
+	}
+}
+
+// shardFile is an open shard, locked while written
+type shardFile struct {
+	path    string
+	format  string
+	headers []string
+	file    *os.File
+	lock    *lock.Lock
+	bw      *bufio.Writer
+	csv     *csv.Writer
+	records int
+	bytes   int64
+
+	// closeEnc writes final encrypted chunk of encrypted shards
+	closeEnc func() error
+}
+
+func (lc *localStorageClient) openShard(ctx context.Context, path, format string, headers []string) (*shardFile, error) {
+	lk, err := lc.locker.Lock(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		lc.unlock(lk)
+		return nil, errors.WrapError(err, ERROR_CREATING_FILE, path)
+	}
+	w, closeEnc, err := lc.encryptWriter(ctx, file)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		lc.unlock(lk)
+		return nil, err
+	}
+
+	s := &shardFile{
+		path:     path,
+		format:   format,
+		headers:  headers,
+		file:     file,
+		lock:     lk,
+		bw:       bufio.NewWriter(w),
+		closeEnc: closeEnc,
+	}
+	switch format {
+	case FORMAT_JSON:
+		err = s.writeString("[")
+	case FORMAT_CSV:
+		// csv writer is flushed per row into counted buffered writer, so shard size is current
+		s.csv = csv.NewWriter(countingWriter{s})
+		s.csv.Comma = csvFiler.DEFAULT_COMMA
+		err = s.writeRow(headers)
+	}
+	if err != nil {
+		s.abort()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *shardFile) write(r JSONMapper) error {
+	if s.format == FORMAT_CSV {
+		if err := s.writeRow(rowFromRecord(s.headers, r)); err != nil {
+			return err
+		}
+		s.records++
+		return nil
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return errors.WrapError(err, ERROR_WRITING_FILE, s.path)
+	}
+	switch {
+	case s.format == FORMAT_NDJSON:
+		data = append(data, '\n')
+	case s.records > 0:
+		data = append([]byte{','}, data...)
+	}
+	if err := s.writeBytes(data); err != nil {
+		return err
+	}
+	s.records++
+	return nil
+}
+
+func (s *shardFile) writeRow(row []string) error {
+	if err := s.csv.Write(row); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_FILE, s.path)
+	}
+	s.csv.Flush()
+	if err := s.csv.Error(); err != nil {
+		return errors.WrapError(err, ERROR_WRITING_FILE, s.path)
+	}
+	return nil
+}
+
+func (s *shardFile) writeString(str string) error {
+	return s.writeBytes([]byte(str))
+}
+
+func (s *shardFile) writeBytes(data []byte) error {
+	n, err := s.bw.Write(data)
+	s.bytes += int64(n)
+	if err != nil {
+		return errors.WrapError(err, ERROR_WRITING_FILE, s.path)
+	}
+	return nil
+}
+
+// close finalizes shard & releases its lock
+func (s *shardFile) close() (Shard, error) {
+	defer s.lock.Unlock()
+
+	if s.format == FORMAT_JSON {
+		if err := s.writeString("]\n"); err != nil {
+			s.file.Close()
+			return Shard{}, err
+		}
+	}
+	if err := s.bw.Flush(); err != nil {
+		s.file.Close()
+		return Shard{}, errors.WrapError(err, ERROR_WRITING_FILE, s.path)
+	}
+	if err := s.closeEnc(); err != nil {
+		s.file.Close()
+		return Shard{}, errors.WrapError(err, ERROR_WRITING_FILE, s.path)
+	}
+	if err := s.file.Close(); err != nil {
+		return Shard{}, errors.WrapError(err, ERROR_CLOSING_FILE, s.path)
+	}
+	return Shard{Path: s.path, Records: s.records, Bytes: s.bytes}, nil
+}
+
+// abort closes & removes incomplete shard
+func (s *shardFile) abort() {
+	s.file.Close()
+	os.Remove(s.path)
+	s.lock.Unlock()
+}
+
+// countingWriter writes into shard's buffered writer, counting bytes
+type countingWriter struct {
+	s *shardFile
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.s.bw.Write(p)
+	w.s.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,125 @@
+package localstorage
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/comfforts/localstorage/pkg/extsort"
+)
+
+type SortOptions struct {
+	// Keys are record fields to sort by, in order
+	Keys []string
+	// MemoryBudget is approximate number of bytes of records held in memory,
+	// larger inputs are spilled to sorted runs on disk & merged
+	MemoryBudget int64
+	// TempDir is directory for spilled runs, defaults to os temp dir
+	TempDir string
+}
+
+// stage is a record stream operator,
+// it closes out and err channels on done
+type stage func(ctx context.Context, inCh <-chan JSONMapper, outCh chan JSONMapper, errCh chan error)
+
+// SortFile sorts records of json array or csv file at srcPath by option keys,
+// within memory budget, & writes them to fileName in data directory,
+// output format is determined by fileName extension
+func (lc *localStorageClient) SortFile(ctx context.Context, srcPath, fileName string, opts SortOptions) (err error) {
+	defer lc.observe("SortFile", time.Now(), &err)
+	defer lc.recordResult(ctx, AuditEntry{Op: "SortFile", Path: srcPath, Dest: filepath.Join("data", fileName)}, &err)
+
+	wrapRun, unwrapRun := lc.spillRuns(ctx)
+	sorter, err := extsort.NewSorter(extsort.Config{
+		Less:         extsort.ByKeys(opts.Keys...),
+		MemoryBudget: opts.MemoryBudget,
+		TempDir:      opts.TempDir,
+		WrapRun:      wrapRun,
+		UnwrapRun:    unwrapRun,
+	}, lc.logger)
+	if err != nil {
+		return err
+	}
+
+	return lc.transformFile(ctx, "SortFile", srcPath, fileName, sorter.Sort)
+}
+
+// transformFile reads records of srcPath, passes them through stage
+// & writes stage output to fileName in data directory
+func (lc *localStorageClient) transformFile(ctx context.Context, method, srcPath, fileName string, fn stage) error {
+	return lc.transform(ctx, method, srcPath, filepath.Join("data", fileName), CSVConfig{}, nil, fn)
+}
+
+// transform reads records of srcPath, csv files as per cfg, passes them through stage
+// & writes stage output to dstPath, csv output columns are given headers or csv source headers,
+// read errors abort the transform, since partial input would silently drop records,
+// failed or cancelled transforms leave existing dstPath as is,
+// read metrics are recorded for given client method
+func (lc *localStorageClient) transform(ctx context.Context, method, srcPath, dstPath string, cfg CSVConfig, headers []string, fn stage) error {
+	callerCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rs, srcHeaders, err := lc.readRecordsWithConfig(ctx, method, srcPath, cfg)
+	if err != nil {
+		return err
+	}
+	if len(headers) == 0 {
+		headers = srcHeaders
+	}
+
+	fe := &firstError{}
+	inCh := make(chan JSONMapper)
+	go func() {
+		defer close(inCh)
+		for r := range rs {
+			if r.Error != nil {
+				fe.set(r.Error)
+				cancel()
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case inCh <- r.Result:
+			}
+		}
+	}()
+
+	outCh := make(chan JSONMapper)
+	errCh := make(chan error)
+	go fn(ctx, inCh, outCh, errCh)
+
+	stageDone := make(chan struct{})
+	go func() {
+		defer close(stageDone)
+		for err := range errCh {
+			lc.logger.Error("error transforming records", zap.Error(err), zap.String("filePath", srcPath))
+			fe.set(err)
+			cancel()
+		}
+	}()
+
+	err = lc.writeRecords(ctx, method, dstPath, headers, outCh, func() error {
+		// output is complete once stage is done, unless cancelled or records failed
+		<-stageDone
+		if err := callerCtx.Err(); err != nil {
+			return err
+		}
+		return fe.get()
+	})
+	if err != nil {
+		cancel()
+	}
+	drain(outCh, nil)
+	<-stageDone
+	if cerr := callerCtx.Err(); cerr != nil {
+		return cerr
+	}
+	if ferr := fe.get(); ferr != nil {
+		return ferr
+	}
+	return err
+}
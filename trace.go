@@ -0,0 +1,29 @@
+package localstorage
+
+import (
+	"context"
+
+	"github.com/comfforts/localstorage/pkg/trace"
+)
+
+// SPAN_PREFIX prefixes span names of client methods
+const SPAN_PREFIX = "localstorage."
+
+type Tracer = trace.Tracer
+
+// startSpan opens method's span on client tracer
+func (lc *localStorageClient) startSpan(ctx context.Context, method string, attrs ...trace.Attribute) (context.Context, trace.Span) {
+	return lc.tracer.Start(ctx, SPAN_PREFIX+method, attrs...)
+}
+
+// endSpan records err, if any, & ends span
+func endSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.End()
+}
+
+// failed records err on span & returns it as write response
+func failed(span trace.Span, err error) WriteResponse {
+	span.RecordError(err)
+	return WriteResponse{Error: err}
+}
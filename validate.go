@@ -0,0 +1,56 @@
+package localstorage
+
+import (
+	"context"
+
+	"github.com/comfforts/localstorage/pkg/schema"
+)
+
+// ValidateReadStream validates each read result against schema,
+// passes valid results through & sends violations, tagged with
+// element index and json pointer, as read response errors
+func ValidateReadStream(ctx context.Context, s *schema.Schema, rs <-chan ReadResponse) <-chan ReadResponse {
+	vrs := make(chan ReadResponse)
+	go func() {
+		defer close(vrs)
+		for i := 0; ; i++ {
+			var r ReadResponse
+			select {
+			case <-ctx.Done():
+				return
+			case resp, ok := <-rs:
+				if !ok {
+					return
+				}
+				r = resp
+			}
+
+			resps := []ReadResponse{r}
+			if r.Error == nil {
+				if vs := s.Validate(i, r.Result); len(vs) > 0 {
+					resps = resps[:0]
+					for _, v := range vs {
+						resps = append(resps, ReadResponse{Error: v})
+					}
+				}
+			}
+			for _, resp := range resps {
+				select {
+				case <-ctx.Done():
+					return
+				case vrs <- resp:
+				}
+			}
+		}
+	}()
+	return vrs
+}
+
+// ValidateWriteStream validates each write request against schema,
+// returns request stream of valid requests to pass on to WriteFile
+// & sends violations on err chan, closes err chan on done
+func ValidateWriteStream(ctx context.Context, s *schema.Schema, reqStream chan JSONMapper, errCh chan error) chan JSONMapper {
+	vReqStream := make(chan JSONMapper)
+	go schema.ValidateStream(ctx, s, reqStream, vReqStream, errCh)
+	return vReqStream
+}
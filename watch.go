@@ -0,0 +1,25 @@
+package localstorage
+
+import (
+	"context"
+
+	"github.com/comfforts/localstorage/pkg/watch"
+)
+
+type WatchConfig = watch.Config
+
+type WatchEvent = watch.Event
+
+// Watch watches dir, sending create, modify & delete events & errors till context done,
+// with cfg.Stable set, complete events signal files ready for ReadCSVFile or ReadFileArray,
+// closes event & error channels on return
+func (lc *localStorageClient) Watch(ctx context.Context, dir string, cfg WatchConfig, evCh chan WatchEvent, errCh chan error) error {
+	if err := checkDirectory(dir); err != nil {
+		return err
+	}
+	w, err := watch.NewWatcher(cfg, lc.logger)
+	if err != nil {
+		return err
+	}
+	return w.Watch(ctx, dir, evCh, errCh)
+}